@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type commandPaletteEntryKind string
+
+const (
+	commandPaletteEntryKindAction commandPaletteEntryKind = "action"
+	commandPaletteEntryKindScene  commandPaletteEntryKind = "scene"
+	commandPaletteEntryKindAdopt  commandPaletteEntryKind = "adopt"
+)
+
+type commandPaletteEntry struct {
+	Kind        commandPaletteEntryKind
+	Name        string
+	Description string
+	ID          string // When the name is not enough, e.g. the MAC address of the device to adopt
+}
+
+// commandPaletteSelected is the tea.Msg that gets dispatched when an entry of the palette is chosen
+type commandPaletteSelected struct {
+	entry commandPaletteEntry
+}
+
+// commandPaletteClosed is the tea.Msg that gets dispatched when the palette is dismissed
+type commandPaletteClosed struct{}
+
+const commandPaletteMaxRows = 8
+
+// CommandPaletteModel is a reusable component to pick an entry by typing part of its name
+type CommandPaletteModel struct {
+	input    textinput.Model
+	entries  []commandPaletteEntry
+	filtered []commandPaletteEntry
+	cursor   int
+	width    int
+}
+
+// NewCommandPaletteModel creates a new focused palette listing the given entries
+func NewCommandPaletteModel(entries []commandPaletteEntry, width int) *CommandPaletteModel {
+	input := textinput.New()
+	input.Prompt = "> "
+	input.Placeholder = "action, scene or device to adopt"
+	input.Focus()
+
+	m := &CommandPaletteModel{
+		input:   input,
+		entries: entries,
+		width:   width,
+	}
+	m.filter()
+	return m
+}
+
+func (m *CommandPaletteModel) filter() {
+	query := strings.ToLower(strings.TrimSpace(m.input.Value()))
+	m.filtered = m.filtered[:0]
+	for _, entry := range m.entries {
+		if strings.Contains(strings.ToLower(entry.Name), query) || strings.Contains(string(entry.Kind), query) {
+			m.filtered = append(m.filtered, entry)
+		}
+	}
+	m.cursor = max(0, min(m.cursor, len(m.filtered)-1))
+}
+
+// Init implements tea.Model
+func (m *CommandPaletteModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+// Update implements tea.Model
+func (m *CommandPaletteModel) Update(msg tea.Msg) (*CommandPaletteModel, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "esc":
+			return m, func() tea.Msg { return commandPaletteClosed{} }
+		case "enter":
+			if len(m.filtered) == 0 {
+				return m, nil
+			}
+			entry := m.filtered[m.cursor]
+			return m, func() tea.Msg { return commandPaletteSelected{entry: entry} }
+		case "up", "ctrl+p":
+			m.cursor = max(0, m.cursor-1)
+			return m, nil
+		case "down", "ctrl+n":
+			m.cursor = min(len(m.filtered)-1, m.cursor+1)
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	m.filter()
+	return m, cmd
+}
+
+// View implements tea.Model
+func (m *CommandPaletteModel) View() string {
+	var b strings.Builder
+	b.WriteString(m.input.View())
+
+	if len(m.filtered) == 0 {
+		b.WriteString("\n")
+		b.WriteString(deviceTableMutedStyle.Render("No matching action, scene or device"))
+	}
+
+	// Keep the cursor in the window of the shown entries
+	start := max(0, m.cursor-commandPaletteMaxRows+1)
+	end := min(len(m.filtered), start+commandPaletteMaxRows)
+	for i := start; i < end; i++ {
+		entry := m.filtered[i]
+		style := lipgloss.NewStyle()
+		if i == m.cursor {
+			style = deviceTableSelectedStyle
+		}
+		line := fmt.Sprintf("%-6s %s", entry.Kind, entry.Name)
+		if entry.Description != "" {
+			line += " · " + entry.Description
+		}
+		b.WriteString("\n")
+		b.WriteString(deviceTableCell(style, max(20, m.width), line))
+	}
+
+	b.WriteString("\n")
+	b.WriteString(deviceTableMutedStyle.Render("↑/↓ select · enter run · esc close"))
+
+	return b.String()
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amimof/huego"
+	"github.com/rs/zerolog/log"
+)
+
+// sendTimeout bounds a one-off command, including the scan needed to find a Govee device
+const sendTimeout = 15 * time.Second
+
+// errUsage is returned when the command line is invalid: the usage gets printed
+var errUsage = errors.New("invalid command line")
+
+func printUsage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [command]
+
+Without a command, the synchronizer runs until interrupted.
+
+Commands:
+  adopt <mac> <alias>                      add a discovered Govee device to configuration.json
+  discover [-timeout 3s]                   find the devices on the network and print configuration snippets
+  hue sensors|lights                       list the sensors or lights of the configured Hue bridge
+  send <device> on|off|bri N|color R,G,B   send a single command to a configured device
+  status [-address URL] [-token TOKEN]     show the devices of a running synchronizer
+
+Flags:
+`, name)
+	flag.PrintDefaults()
+}
+
+// runCommand runs the subcommand given on the command line, instead of the synchronizer
+func runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "adopt":
+		return runAdoptCommand(args[1:])
+	case "discover":
+		return runDiscoverCommand(ctx, args[1:])
+	case "hue":
+		return runHueCommand(ctx, args[1:])
+	case "send":
+		return runSendCommand(ctx, args[1:])
+	case "status":
+		return runStatusCommand(ctx, args[1:])
+	}
+	return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+}
+
+// printConfigurationSnippet prints the value as the given key of configuration.json
+func printConfigurationSnippet(key string, value any) {
+	snippet, err := json.MarshalIndent(map[string]any{key: value}, "", "  ")
+	if err != nil {
+		return
+	}
+	// Without the braces of the wrapping object, ready to be pasted in the existing one
+	lines := strings.Split(string(snippet), "\n")
+	for _, line := range lines[1 : len(lines)-1] {
+		fmt.Println(strings.TrimPrefix(line, "  "))
+	}
+}
+
+func runDiscoverCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("discover", flag.ContinueOnError)
+	timeout := flags.Duration("timeout", 3*time.Second, "how long to wait for the answers")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	// Only used to tell which devices are already configured
+	configuration, err := LoadConfiguration()
+	if err != nil {
+		log.Warn().Msgf("Cannot tell the devices already configured: %s", err)
+	}
+
+	lanInterfaces, err := resolveLANInterfaces(configuration.GoveeLAN.Interfaces)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	var (
+		wg             sync.WaitGroup
+		goveeDevices   []GoveeScanResponseMsgData
+		goveeErr       error
+		hueBridges     []huego.Bridge
+		hueErr         error
+		wledDevices    []DiscoveredWledDevice
+		wledErr        error
+		twinklyDevices []DiscoveredTwinklyDevice
+		twinklyErr     error
+	)
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		goveeDevices, goveeErr = discoverGoveeDevices(ctx, lanInterfaces)
+	}()
+	go func() {
+		defer wg.Done()
+		hueBridges, hueErr = discoverHueBridges(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		wledDevices, wledErr = discoverWledDevices(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		twinklyDevices, twinklyErr = discoverTwinklyDevices(ctx)
+	}()
+	wg.Wait()
+
+	fmt.Printf("Govee devices: %d found\n", len(goveeDevices))
+	if goveeErr != nil {
+		fmt.Printf("  %s\n", goveeErr)
+	}
+	newGoveeDevices := make(map[string]GoveeDeviceConfiguration)
+	for _, device := range goveeDevices {
+		fmt.Printf("  %-6s %-15s %s  wifi %s/%s  ble %s/%s", device.SKU, device.IP, device.Device,
+			device.WifiVersionHard, device.WifiVersionSoft, device.BleVersionHard, device.BleVersionSoft)
+		if alias, ok := configuration.GetGoveeDeviceAliasByMAC(device.Device); ok {
+			fmt.Printf("  configured as %q\n", alias)
+			continue
+		}
+		fmt.Println()
+		newGoveeDevices[suggestGoveeAlias(device.SKU, device.Device)] = GoveeDeviceConfiguration{MAC: device.Device}
+	}
+	if len(newGoveeDevices) > 0 {
+		printConfigurationSnippet("govee", newGoveeDevices)
+		fmt.Printf("Or add one with: %s adopt <mac> <alias>\n", filepath.Base(os.Args[0]))
+	}
+
+	fmt.Printf("\nHue bridges: %d found\n", len(hueBridges))
+	if hueErr != nil {
+		fmt.Printf("  %s\n", hueErr)
+	}
+	for _, bridge := range hueBridges {
+		fmt.Printf("  %-15s %s", bridge.Host, bridge.ID)
+		if bridge.Host == configuration.Hue.Bridge.IP {
+			fmt.Printf("  configured\n")
+			continue
+		}
+		fmt.Println()
+		printConfigurationSnippet("hue", map[string]any{"bridge": HueBridgeDeviceConfiguration{IP: bridge.Host}})
+		fmt.Println("  The username is given by the bridge to a new application, after pressing its link button.")
+	}
+
+	fmt.Printf("\nWLED devices: %d found\n", len(wledDevices))
+	if wledErr != nil {
+		fmt.Printf("  %s\n", wledErr)
+	}
+	newWledDevices := make(map[string]map[string]string)
+	for _, device := range wledDevices {
+		fmt.Printf("  %-15s %s", device.IP, device.Name)
+		if alias, ok := findConfiguredByIP(configuration.Wled, device.IP, func(device WledDeviceConfiguration) string { return device.IP }); ok {
+			fmt.Printf("  configured as %q\n", alias)
+			continue
+		}
+		fmt.Println()
+		newWledDevices[device.Name] = map[string]string{"ip": device.IP}
+	}
+	if len(newWledDevices) > 0 {
+		printConfigurationSnippet("wled", newWledDevices)
+	}
+
+	fmt.Printf("\nTwinkly devices: %d found\n", len(twinklyDevices))
+	if twinklyErr != nil {
+		fmt.Printf("  %s\n", twinklyErr)
+	}
+	newTwinklyDevices := make(map[string]TwinklyDeviceConfiguration)
+	for _, device := range twinklyDevices {
+		fmt.Printf("  %-15s %s", device.IP, device.Name)
+		if alias, ok := findConfiguredByIP(configuration.Twinkly, device.IP, func(device TwinklyDeviceConfiguration) string { return device.IP }); ok {
+			fmt.Printf("  configured as %q\n", alias)
+			continue
+		}
+		fmt.Println()
+		newTwinklyDevices[device.Name] = TwinklyDeviceConfiguration{IP: device.IP}
+	}
+	if len(newTwinklyDevices) > 0 {
+		printConfigurationSnippet("twinkly", newTwinklyDevices)
+	}
+
+	return nil
+}
+
+// runAdoptCommand adds a Govee device to configuration.json, e.g. one listed by discover or by /api/v1/discovered
+func runAdoptCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: adopt needs a MAC address and an alias", errUsage)
+	}
+	if _, err := net.ParseMAC(args[0]); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	mac, alias := strings.ToUpper(args[0]), strings.TrimSpace(args[1])
+	if alias == "" {
+		return fmt.Errorf("%w: the alias cannot be empty", errUsage)
+	}
+
+	configuration, err := LoadConfiguration()
+	if err != nil {
+		return err
+	}
+	if _, ok := configuration.GetDeviceProvider(alias); ok {
+		return fmt.Errorf("%w: a device is named %q", ErrAlreadyConfigured, alias)
+	}
+	if _, ok := configuration.Groups[alias]; ok {
+		return fmt.Errorf("%w: a group is named %q", ErrAlreadyConfigured, alias)
+	}
+
+	if err := AddGoveeDeviceToConfigurationFile(alias, mac); err != nil {
+		return err
+	}
+	fmt.Printf("Added %q (%s) to configuration.json: restart the synchronizer to control it\n", alias, mac)
+	return nil
+}
+
+// suggestGoveeAlias names a device after its model and the end of its MAC address, e.g. "H6159 2A3A"
+func suggestGoveeAlias(sku, mac string) string {
+	suffix := strings.ToUpper(strings.ReplaceAll(mac, ":", ""))
+	suffix = suffix[max(0, len(suffix)-4):]
+	return fmt.Sprintf("%s %s", sku, suffix)
+}
+
+func findConfiguredByIP[T any](devices map[string]T, ip string, getIP func(T) string) (string, bool) {
+	for alias, device := range devices {
+		if getIP(device) == ip {
+			return alias, true
+		}
+	}
+	return "", false
+}
+
+func runHueCommand(ctx context.Context, args []string) error {
+	if len(args) != 1 || (args[0] != "sensors" && args[0] != "lights") {
+		return fmt.Errorf("%w: expected \"hue sensors\" or \"hue lights\"", errUsage)
+	}
+
+	configuration, err := LoadConfiguration()
+	if err != nil {
+		return err
+	}
+	if configuration.Hue.Bridge.IP == "" || configuration.Hue.Bridge.Username == "" {
+		return fmt.Errorf("the Hue bridge IP and username are missing from the configuration: see the discover command")
+	}
+	bridge := huego.New(configuration.Hue.Bridge.IP, configuration.Hue.Bridge.Username)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer writer.Flush()
+
+	switch args[0] {
+	case "sensors":
+		sensors, err := bridge.GetSensorsContext(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting Hue sensors: %w", err)
+		}
+		slices.SortFunc(sensors, func(a, b huego.Sensor) int { return a.ID - b.ID })
+		fmt.Fprintln(writer, "ID\tNAME\tTYPE\tMODEL\tUNIQUE ID")
+		for _, sensor := range sensors {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\n", sensor.ID, sensor.Name, sensor.Type, sensor.ModelID, sensor.UniqueID)
+		}
+	case "lights":
+		lights, err := bridge.GetLightsContext(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting Hue lights: %w", err)
+		}
+		slices.SortFunc(lights, func(a, b huego.Light) int { return a.ID - b.ID })
+		fmt.Fprintln(writer, "ID\tNAME\tTYPE\tMODEL\tUNIQUE ID\tREACHABLE")
+		for _, light := range lights {
+			reachable := light.State != nil && light.State.Reachable
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%v\n", light.ID, light.Name, light.Type, light.ModelID, light.UniqueID, reachable)
+		}
+	}
+	return nil
+}
+
+// parseSendState parses on, off, "bri N" (0-100) and "color R,G,B" (0-255 each, spaces allowed around the commas)
+func parseSendState(args []string) (DeviceState, error) {
+	switch {
+	case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		on := args[0] == "on"
+		return DeviceState{On: &on}, nil
+	case len(args) == 2 && args[0] == "bri":
+		brightness, err := strconv.Atoi(args[1])
+		if err != nil || brightness < 0 || brightness > 100 {
+			return DeviceState{}, fmt.Errorf("%w: the brightness must be between 0 and 100", errUsage)
+		}
+		return DeviceState{Brightness: &brightness}, nil
+	case len(args) >= 2 && args[0] == "color":
+		// "color 255, 0, 0" comes split in several arguments
+		components := strings.Split(strings.Join(args[1:], ""), ",")
+		if len(components) != 3 {
+			return DeviceState{}, fmt.Errorf("%w: the color must be given as R,G,B", errUsage)
+		}
+		var rgb [3]int
+		for i, component := range components {
+			value, err := strconv.Atoi(strings.TrimSpace(component))
+			if err != nil || value < 0 || value > 255 {
+				return DeviceState{}, fmt.Errorf("%w: each color component must be between 0 and 255", errUsage)
+			}
+			rgb[i] = value
+		}
+		return DeviceState{Color: &deviceColor{Red: rgb[0], Green: rgb[1], Blue: rgb[2]}}, nil
+	}
+	return DeviceState{}, fmt.Errorf("%w: expected on, off, \"bri N\" or \"color R,G,B\"", errUsage)
+}
+
+// runSendCommand delivers one command through the dispatcher, as the synchronizer would
+func runSendCommand(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("%w: expected \"send <device> on|off|bri N|color R,G,B\"", errUsage)
+	}
+	device := args[0]
+	state, err := parseSendState(args[1:])
+	if err != nil {
+		return err
+	}
+
+	configuration, err := LoadConfiguration()
+	if err != nil {
+		return err
+	}
+	provider, ok := configuration.GetDeviceProvider(device)
+	if !ok {
+		return fmt.Errorf("device %s not found in the configuration", device)
+	}
+	Status.Register(device, provider)
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	connectionsCtx, closeConnections := context.WithCancel(context.WithoutCancel(ctx))
+	var connections sync.WaitGroup
+	defer connections.Wait()
+	defer closeConnections()
+
+	goveeConnection := NewGoveeConnection(configuration)
+	twinklyConnection := NewNoopTwinklyConnection()
+
+	switch provider {
+	case ProviderGovee:
+		// The address of the device is only known once it answers a scan
+		lanInterfaces, err := resolveLANInterfaces(configuration.GoveeLAN.Interfaces)
+		if err != nil {
+			return err
+		}
+		multicastConns, err := openMulticastConnections(lanInterfaces)
+		if err != nil {
+			return err
+		}
+		defer closeUDPConnections(multicastConns)
+		listeners, err := listenGoveeResponses(lanInterfaces)
+		if err != nil {
+			return err
+		}
+
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			goveeConnection.Start(connectionsCtx, listeners)
+		}()
+
+		if err := goveeConnection.waitForDevice(ctx, multicastConns, device); err != nil {
+			return err
+		}
+	case ProviderTwinkly:
+		ip := configuration.Twinkly[device].IP
+		twinklyConnection = NewTwinklyConnection(ip)
+		if err := twinklyConnection.Login(ctx, ip); err != nil {
+			return fmt.Errorf("error logging in to Twinkly device: %w", err)
+		}
+	}
+
+	dispatcher := NewDispatcher(goveeConnection, twinklyConnection, NewSwitchbotConnection(configuration), NewWledConnection(configuration))
+
+	results, unsubscribe := Dispatches.Subscribe()
+	defer unsubscribe()
+
+	goveeMessages, twinklyMessages, switchbotMessages, wledMessages := configuration.GetMessagesToApplyDeviceState(device, state, 0)
+	dispatcher.Dispatch(ctx, fmt.Sprintf("command to %s", device), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+	if err := dispatcher.Drain(ctx); err != nil {
+		return err
+	}
+
+	select {
+	case result := <-results:
+		if failure, failed := result.Failed[device]; failed {
+			return fmt.Errorf("error sending to %s: %s", device, failure)
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("error sending to %s: %w", device, ctx.Err())
+	}
+	fmt.Printf("Sent to %s\n", device)
+	return nil
+}
+
+func runStatusCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	address := flags.String("address", "http://localhost:8080", "base URL of the synchronizer API")
+	token := flags.String("token", "", "API token, when the API requires one")
+	insecure := flags.Bool("insecure", false, "accept the self-signed certificate of the API")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	if *insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	var devices []HTTPDevice
+	if err := getAPI(ctx, client, *address, "/api/v1/devices", *token, &devices); err != nil {
+		return err
+	}
+	var groups map[string]groupStatus
+	if err := getAPI(ctx, client, *address, "/api/v1/groups", *token, &groups); err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "DEVICE\tPROVIDER\tREACHABLE\tPOWER\tBRIGHTNESS\tCOLOR")
+	for _, device := range devices {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", device.Name, device.Provider,
+			formatTriState(device.Status.Reachable, "yes", "no"),
+			formatTriState(device.Status.On, "on", "off"),
+			formatPercentage(device.Status.Brightness),
+			formatColor(device.Status.Color))
+	}
+	writer.Flush()
+
+	if len(groups) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	fmt.Println()
+	writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "GROUP\tPOWER\tBRIGHTNESS\tMEMBERS")
+	for _, name := range names {
+		group := groups[name]
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", name, group.On, formatPercentage(group.Brightness), strings.Join(group.Members, ", "))
+	}
+	return writer.Flush()
+}
+
+// getAPI decodes the answer of a GET request to the synchronizer API
+func getAPI(ctx context.Context, client *http.Client, address, path, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(address, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error querying the synchronizer, is it running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiError HTTPError
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &apiError) == nil && apiError.Error.Message != "" {
+			return fmt.Errorf("error querying %s: %s", path, apiError.Error.Message)
+		}
+		return fmt.Errorf("error querying %s: status %d", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// formatTriState formats the -1 (unknown), 0 and 1 values of the status
+func formatTriState(value int, yes, no string) string {
+	switch value {
+	case 1:
+		return yes
+	case 0:
+		return no
+	}
+	return "?"
+}
+
+func formatColor(color deviceColor) string {
+	if color.Red < 0 {
+		return "?"
+	}
+	return fmt.Sprintf("%d,%d,%d", color.Red, color.Green, color.Blue)
+}
+
+func formatPercentage(value int) string {
+	if value < 0 {
+		return "?"
+	}
+	return fmt.Sprintf("%d%%", value)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseSendState(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected DeviceState
+	}{
+		{[]string{"on"}, DeviceState{On: valToPtr(true)}},
+		{[]string{"off"}, DeviceState{On: valToPtr(false)}},
+		{[]string{"bri", "0"}, DeviceState{Brightness: valToPtr(0)}},
+		{[]string{"bri", "100"}, DeviceState{Brightness: valToPtr(100)}},
+		{[]string{"color", "255,128,0"}, DeviceState{Color: &deviceColor{Red: 255, Green: 128, Blue: 0}}},
+		{[]string{"color", "255, 128, 0"}, DeviceState{Color: &deviceColor{Red: 255, Green: 128, Blue: 0}}},
+		{[]string{"color", " 255 ,128 , 0 "}, DeviceState{Color: &deviceColor{Red: 255, Green: 128, Blue: 0}}},
+		{[]string{"color", "255,", "128,", "0"}, DeviceState{Color: &deviceColor{Red: 255, Green: 128, Blue: 0}}},
+	} {
+		state, err := parseSendState(test.args)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(state, test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.args, test.expected, state)
+		}
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"toggle"},
+		{"on", "now"},
+		{"bri"},
+		{"bri", "-1"},
+		{"bri", "101"},
+		{"bri", "half"},
+		{"bri", "50", "60"},
+		{"color"},
+		{"color", "255,0"},
+		{"color", "255,0,0,0"},
+		{"color", "256,0,0"},
+		{"color", "-1,0,0"},
+		{"color", "red"},
+		{"color", "255", "0", "0"},
+	} {
+		if _, err := parseSendState(args); !errors.Is(err, errUsage) {
+			t.Errorf("%q: expected a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestSuggestGoveeAlias(t *testing.T) {
+	for _, test := range []struct {
+		sku, mac, expected string
+	}{
+		{"H6159", "0C:D6:C8:6B:82:C6:2A:7B", "H6159 2A7B"},
+		{"H6159", "0c:d6:c8:6b:82:c6:2a:7b", "H6159 2A7B"},
+		{"H6008", "2A7B", "H6008 2A7B"},
+		{"H6008", "7B", "H6008 7B"},
+		{"H6008", "", "H6008 "},
+	} {
+		if alias := suggestGoveeAlias(test.sku, test.mac); alias != test.expected {
+			t.Errorf("%s %s: expected %q, got %q", test.sku, test.mac, test.expected, alias)
+		}
+	}
+}
@@ -24,39 +24,56 @@ type Configuration struct {
 	Switchbot map[string]SwitchbotDeviceConfiguration `json:"switchbot"`
 	Wled      map[string]WledDeviceConfiguration      `json:"wled"`
 	Twinkly   map[string]TwinklyDeviceConfiguration   `json:"twinkly"`
+	Scenes    map[string]ConfigurationScene           `json:"scenes"`
+	Groups    map[string]ConfigurationGroup           `json:"groups"`
+
+	HTTP        HTTPConfiguration        `json:"http"`
+	EmulatedHue EmulatedHueConfiguration `json:"emulated_hue"`
+	MQTT        MQTTConfiguration        `json:"mqtt"`
+	GoveeLAN    GoveeLANConfiguration    `json:"govee_lan"`
+
+	GoveeScenesFile string `json:"govee_scenes_file"` // Scenes of each Govee model, defaults to govee_scenes.json when present
+
+	SuppressionWindowMs int `json:"suppression_window_ms"` // How long our own writes are not mistaken for new changes, defaults to 3 seconds
 
 	presenceSensorActionsCache gcache.Cache
 }
 
+// NewConfiguration loads configuration.json from the working directory, and panics when it cannot
 func NewConfiguration() Configuration {
-	wd, err := os.Getwd()
+	configuration, err := LoadConfiguration()
 	if err != nil {
-		panic(fmt.Errorf("error getting working directory: %w", err))
+		panic(err)
 	}
+	return configuration
+}
 
-	configurationFilePath := path.Join(wd, "configuration.json")
-	absoluteConfigurationFilePath, err := filepath.Abs(configurationFilePath)
+// LoadConfiguration loads configuration.json from the working directory
+func LoadConfiguration() (Configuration, error) {
+	absoluteConfigurationFilePath, err := configurationFilePath()
 	if err != nil {
-		panic(fmt.Errorf("error getting absolute path for configuration file: %w", err))
+		return Configuration{}, err
 	}
 
 	if _, err := os.Stat(absoluteConfigurationFilePath); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			panic(fmt.Errorf("configuration file not found: %w", err))
+			return Configuration{}, fmt.Errorf("configuration file not found: %w", err)
 		}
-		panic(fmt.Errorf("error checking configuration file: %w", err))
+		return Configuration{}, fmt.Errorf("error checking configuration file: %w", err)
 	}
 
 	rawConfiguration, err := os.ReadFile(absoluteConfigurationFilePath)
 	if err != nil {
-		panic(fmt.Errorf("error reading configuration file: %w", err))
+		return Configuration{}, fmt.Errorf("error reading configuration file: %w", err)
 	}
 
 	var configuration Configuration
 	if err := json.Unmarshal(rawConfiguration, &configuration); err != nil {
-		panic(fmt.Errorf("error unmarshalling configuration: %w", err))
+		return Configuration{}, fmt.Errorf("error unmarshalling configuration: %w", err)
 	}
 
+	configuration.expandGroupActions()
+
 	configuration.presenceSensorActionsCache = gcache.New(0).
 		Expiration(60 * time.Second).
 		LoaderFunc(func(i any) (any, error) {
@@ -73,7 +90,21 @@ func NewConfiguration() Configuration {
 		}).
 		Build()
 
-	return configuration
+	return configuration, nil
+}
+
+// configurationFilePath returns the absolute path of configuration.json in the working directory
+func configurationFilePath() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("error getting working directory: %w", err)
+	}
+
+	absoluteConfigurationFilePath, err := filepath.Abs(path.Join(wd, "configuration.json"))
+	if err != nil {
+		return "", fmt.Errorf("error getting absolute path for configuration file: %w", err)
+	}
+	return absoluteConfigurationFilePath, nil
 }
 
 func (c *Configuration) GetRequiredGoveeDevices() []string {
@@ -127,6 +158,144 @@ func (c *Configuration) GetAllTwinklyDeviceAliases() []string {
 	return aliases
 }
 
+func (c *Configuration) GetDeviceProvider(device string) (string, bool) {
+	if _, ok := c.Govee[device]; ok {
+		return ProviderGovee, true
+	}
+	if _, ok := c.Switchbot[device]; ok {
+		return ProviderSwitchbot, true
+	}
+	if _, ok := c.Wled[device]; ok {
+		return ProviderWled, true
+	}
+	if _, ok := c.Twinkly[device]; ok {
+		return ProviderTwinkly, true
+	}
+	return "", false
+}
+
+func (c *Configuration) GetGroupDevices(group string) ([]string, bool) {
+	groupConfiguration, ok := c.Groups[group]
+	if !ok {
+		return nil, false
+	}
+	return groupConfiguration.Devices, true
+}
+
+// ResolveDevices replaces group names with their members, removing duplicates
+func (c *Configuration) ResolveDevices(devicesOrGroups []string) []string {
+	var devices []string
+	for _, name := range devicesOrGroups {
+		members, isGroup := c.GetGroupDevices(name)
+		if !isGroup {
+			members = []string{name}
+		}
+		for _, member := range members {
+			if !slices.Contains(devices, member) {
+				devices = append(devices, member)
+			}
+		}
+	}
+	return devices
+}
+
+// expandGroupActions converts each group action into the provider specific actions of the group members
+func (c *Configuration) expandGroupActions() {
+	for i := range c.Actions {
+		action := &c.Actions[i]
+		for _, groupAction := range action.GroupActions {
+			members, ok := c.GetGroupDevices(groupAction.Group)
+			if !ok {
+				err := fmt.Errorf("unknown group: %s", groupAction.Group)
+				log.Err(err).Msgf("Error expanding group action: %s", err)
+				continue
+			}
+			for _, device := range members {
+				provider, ok := c.GetDeviceProvider(device)
+				if !ok {
+					err := fmt.Errorf("unknown device %s in group %s", device, groupAction.Group)
+					log.Err(err).Msgf("Error expanding group action: %s", err)
+					continue
+				}
+				switch provider {
+				case ProviderGovee:
+					action.GoveeActions = append(action.GoveeActions, ConfigurationActionGoveeAction{
+						Device:          device,
+						Action:          GoveeAction(groupAction.Action),
+						SyncValue:       groupAction.SyncValue,
+						BrightnessRange: groupAction.BrightnessRange,
+						Value:           groupAction.Value,
+					})
+				case ProviderSwitchbot:
+					action.SwitchbotActions = append(action.SwitchbotActions, ConfigurationActionSwitchbotAction{
+						Device:          device,
+						Action:          SwitchbotAction(groupAction.Action),
+						SyncValue:       groupAction.SyncValue,
+						BrightnessRange: groupAction.BrightnessRange,
+						Value:           groupAction.Value,
+					})
+				case ProviderWled:
+					action.WledActions = append(action.WledActions, ConfigurationActionWledAction{
+						Device:          device,
+						Action:          WledAction(groupAction.Action),
+						SyncValue:       groupAction.SyncValue,
+						BrightnessRange: groupAction.BrightnessRange,
+						Value:           groupAction.Value,
+					})
+				case ProviderTwinkly:
+					if groupAction.Action != "" && groupAction.Action != GroupActionTurnOn && groupAction.Action != GroupActionTurnOff {
+						log.Warn().Msgf("Group action [%s] not supported by Twinkly device [%s]: skipping", groupAction.Action, device)
+						continue
+					}
+					action.TwinklyActions = append(action.TwinklyActions, ConfigurationTwinklyAction{
+						Action:    TwinklyAction(groupAction.Action),
+						SyncValue: groupAction.SyncValue,
+					})
+				}
+			}
+		}
+	}
+}
+
+// actionBrightness returns the brightness to send for a "set", "increase" or "decrease brightness" action
+// with the given value: like for WLED, the value defaults to 50 when setting and to 10 otherwise.
+// The current brightness of the device comes from the last one sent or observed.
+func actionBrightness(device, action string, value any) int {
+	floatVal, ok := value.(float64)
+	switch GroupAction(action) {
+	case GroupActionSetBrightness:
+		if !ok {
+			floatVal = 50
+		}
+		return int(floatVal)
+	case GroupActionIncreaseBrightness, GroupActionDecreaseBrightness:
+		if !ok {
+			floatVal = 10
+		}
+		if GroupAction(action) == GroupActionDecreaseBrightness {
+			floatVal = -floatVal
+		}
+	}
+	currentBrightness := Brightness.GetDeviceBrightness(device, WithOnMissingBrightness(func(device string) (int, error) {
+		status, ok := Status.Get(device)
+		if !ok || status.Brightness < 0 {
+			return 0, fmt.Errorf("unknown brightness of %s", device)
+		}
+		return status.Brightness, nil
+	}))
+	return int(math.Max(math.Min(float64(currentBrightness)+floatVal, 100), 0))
+}
+
+// GetActionByName returns the first action with the given name
+func (c *Configuration) GetActionByName(name string) (ConfigurationAction, bool) {
+	for _, action := range c.Actions {
+		if action.Name != "" && action.Name == name {
+			return action, true
+		}
+	}
+	return ConfigurationAction{}, false
+}
+
 func (c *Configuration) GetRequiredHueDials() []string {
 	var dials []string
 	for _, action := range c.Actions {
@@ -149,133 +318,191 @@ func (c *Configuration) GetMessagesToDispatchOnHueTapDialButtonPressed(
 	for _, action := range c.Actions {
 		if action.Trigger == ActionTriggerHueTapDialButtonPress && action.DialName == dialName {
 			if slices.Contains(action.HueTapDialButtons, buttonPressed) {
-				for _, goveeAction := range action.GoveeActions {
-					var message []byte
-					switch goveeAction.Action {
-					case GoveeActionTurnOn:
-						message = mustMarshal(GoveeTurn{
-							Msg: GoveeTurnMsg{
-								Cmd: "turn",
-								Data: GoveeTurnMsgData{
-									Value: 1,
-								},
-							},
-						})
-						Status.SetOn(goveeAction.Device, true)
-					case GoveeActionTurnOff:
-						message = mustMarshal(GoveeTurn{
-							Msg: GoveeTurnMsg{
-								Cmd: "turn",
-								Data: GoveeTurnMsgData{
-									Value: 0,
-								},
-							},
-						})
-						Status.SetOn(goveeAction.Device, false)
-					default:
-						err := fmt.Errorf("unknown Govee action: %s", goveeAction.Action)
-						log.Err(err).Msgf("Error creating Govee message: %s", err)
-						continue
-					}
-					goveeMessages = append(goveeMessages, GoveeMessage{
-						Device: goveeAction.Device,
-						Data:   message,
-					})
-				}
-				for _, twinklyAction := range action.TwinklyActions {
-					var message TwinklyMessage
-					switch twinklyAction.Action {
-					case TwinklyActionTurnOn:
-						message = TwinklyMessageOn
-						Status.SetOn("Twinkly Device", true) // TODO: Add support to multiple devices
-					case TwinklyActionTurnOff:
-						message = TwinklyMessageOff
-						Status.SetOn("Twinkly Device", false) // TODO: Add support to multiple devices
-					default:
-						err := fmt.Errorf("unknown Twinkly action: %s", twinklyAction.Action)
-						log.Err(err).Msgf("Error creating Twinkly message: %s", err)
-						continue
-					}
-					twinklyMessages = append(twinklyMessages, message)
-				}
-				for _, switchbotAction := range action.SwitchbotActions {
-					message := NewSwitchbotMessageForDevice(switchbotAction.Device)
-					switch switchbotAction.Action {
-					case SwitchbotActionTurnOn:
-						message = message.TurnOn()
-						Status.SetOn(switchbotAction.Device, true)
-					case SwitchbotActionTurnOff:
-						message = message.TurnOff()
-						Status.SetOn(switchbotAction.Device, false)
-					default:
-						err := fmt.Errorf("unknown Switchbot action: %s", switchbotAction.Action)
-						log.Err(err).Msgf("Error creating Switchbot message: %s", err)
-						continue
-					}
-					if !message.IsEmpty() {
-						switchbotMessages = append(switchbotMessages, message)
-					}
-				}
-				for _, wledAction := range action.WledActions {
-					message := NewWledMessageForDevice(wledAction.Device)
-					switch wledAction.Action {
-					case WledActionTurnOn:
-						message = message.TurnOn()
-						Status.SetOn(wledAction.Device, true)
-					case WledActionTurnOff:
-						message = message.TurnOff()
-						Status.SetOn(wledAction.Device, false)
-					case WledActionSetBrightness:
-						floatVal, ok := wledAction.Value.(float64)
-						if !ok {
-							floatVal = 50
-						}
-						intVal := int(floatVal)
-						message = message.SetBrightness(intVal)
-						Brightness.SetForDevice(wledAction.Device, intVal)
-						Status.SetBrightness(wledAction.Device, intVal)
-					case WledActionIncreaseBrightness:
-						floatVal, ok := wledAction.Value.(float64)
-						if !ok {
-							floatVal = 10
-						}
-						intVal := int(floatVal)
-						currentBrightness := Brightness.GetDeviceBrightness(wledAction.Device, WithOnMissingBrightness(wledBrightnessRetriever.GetDeviceBrightness))
-						newBrightness := int(math.Min(float64(currentBrightness+intVal), 100))
-						message = message.SetBrightness(newBrightness)
-						Brightness.SetForDevice(wledAction.Device, newBrightness)
-						Status.SetBrightness(wledAction.Device, newBrightness)
-					case WledActionDecreaseBrightness:
-						floatVal, ok := wledAction.Value.(float64)
-						if !ok {
-							floatVal = 10
-						}
-						intVal := int(floatVal)
-						currentBrightness := Brightness.GetDeviceBrightness(wledAction.Device, WithOnMissingBrightness(wledBrightnessRetriever.GetDeviceBrightness))
-						newBrightness := int(math.Max(float64(currentBrightness-intVal), 0))
-						message = message.SetBrightness(newBrightness)
-						Brightness.SetForDevice(wledAction.Device, newBrightness)
-						Status.SetBrightness(wledAction.Device, newBrightness)
-					default:
-						err := fmt.Errorf("unknown WLED action: %s", wledAction.Action)
-						log.Err(err).Msgf("Error creating WLED message: %s", err)
-						continue
-					}
-					if !message.IsEmpty() {
-						log.Info().Msgf("Adding WLED message for device %s, action %s", wledAction.Device, wledAction.Action)
-						wledMessages = append(wledMessages, message)
-					}
-				}
+				actionGoveeMessages, actionTwinklyMessages, actionSwitchbotMessages, actionWledMessages := c.GetMessagesToDispatchOnAction(action, wledBrightnessRetriever)
+				goveeMessages = append(goveeMessages, actionGoveeMessages...)
+				twinklyMessages = append(twinklyMessages, actionTwinklyMessages...)
+				switchbotMessages = append(switchbotMessages, actionSwitchbotMessages...)
+				wledMessages = append(wledMessages, actionWledMessages...)
 			}
+		}
+	}
+	return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
+}
 
+// GetMessagesToDispatchOnAction builds the messages of every provider action (and scene action) listed in an action,
+// regardless of its trigger: this is what happens when a tap dial button is pressed
+func (c *Configuration) GetMessagesToDispatchOnAction(
+	action ConfigurationAction,
+	wledBrightnessRetriever BrightnessRetriever,
+) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
+	var goveeMessages []GoveeMessage
+	var twinklyMessages []TwinklyMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
+	transition := time.Duration(action.TransitionMs) * time.Millisecond
+	for _, goveeAction := range action.GoveeActions {
+		var message GoveeMessage
+		switch goveeAction.Action {
+		case GoveeActionTurnOn:
+			message = NewGoveeTurnMessage(goveeAction.Device, true)
+			Status.SetOn(goveeAction.Device, true)
+		case GoveeActionTurnOff:
+			message = NewGoveeTurnMessage(goveeAction.Device, false)
+			Status.SetOn(goveeAction.Device, false)
+		case GoveeActionSetBrightness, GoveeActionIncreaseBrightness, GoveeActionDecreaseBrightness:
+			brightness := actionBrightness(goveeAction.Device, string(goveeAction.Action), goveeAction.Value)
+			message = NewGoveeBrightnessMessage(goveeAction.Device, brightness)
+			Brightness.SetForDevice(goveeAction.Device, brightness)
+			Status.SetBrightness(goveeAction.Device, brightness)
+		case GoveeActionSetSegments:
+			segmentsMessage, err := NewGoveeSegmentColorMessage(goveeAction.Device, goveeAction.Segments)
+			if err != nil {
+				log.Err(err).Msgf("Error creating Govee segments message for %s: %s", goveeAction.Device, err)
+				continue
+			}
+			goveeMessages = append(goveeMessages, segmentsMessage)
+			continue
+		case GoveeActionSetGradient:
+			gradientMessages, err := NewGoveeGradientMessages(goveeAction.Device, goveeAction.Colors)
+			if err != nil {
+				log.Err(err).Msgf("Error creating Govee gradient messages for %s: %s", goveeAction.Device, err)
+				continue
+			}
+			goveeMessages = append(goveeMessages, gradientMessages...)
+			continue
+		case GoveeActionApplyScene:
+			name := GoveeScenes.Next(goveeAction.Device, goveeAction.Scenes)
+			// The scenes depend on the model, known once the device answers the scan
+			status, _ := Status.Get(goveeAction.Device)
+			if status.Model == "" {
+				log.Warn().Msgf("Cannot apply Govee scene %q to %s: its model is not known yet", name, goveeAction.Device)
+				continue
+			}
+			scene, ok := GoveeScenes.Get(status.Model, name)
+			if !ok {
+				log.Warn().Msgf("Cannot apply Govee scene %q to %s: not in the scenes of %s", name, goveeAction.Device, status.Model)
+				continue
+			}
+			goveeMessages = append(goveeMessages, NewGoveeSceneMessage(goveeAction.Device, scene))
+			Status.SetOn(goveeAction.Device, true)
+			continue
+		default:
+			err := fmt.Errorf("unknown Govee action: %s", goveeAction.Action)
+			log.Err(err).Msgf("Error creating Govee message: %s", err)
+			continue
+		}
+		goveeMessages = append(goveeMessages, message)
+	}
+	for _, twinklyAction := range action.TwinklyActions {
+		var message TwinklyMessage
+		switch twinklyAction.Action {
+		case TwinklyActionTurnOn:
+			message = TwinklyMessageOn
+			Status.SetOn("Twinkly Device", true) // TODO: Add support to multiple devices
+		case TwinklyActionTurnOff:
+			message = TwinklyMessageOff
+			Status.SetOn("Twinkly Device", false) // TODO: Add support to multiple devices
+		default:
+			err := fmt.Errorf("unknown Twinkly action: %s", twinklyAction.Action)
+			log.Err(err).Msgf("Error creating Twinkly message: %s", err)
+			continue
+		}
+		twinklyMessages = append(twinklyMessages, message)
+	}
+	for _, switchbotAction := range action.SwitchbotActions {
+		message := NewSwitchbotMessageForDevice(switchbotAction.Device)
+		switch switchbotAction.Action {
+		case SwitchbotActionTurnOn:
+			message = message.TurnOn()
+			Status.SetOn(switchbotAction.Device, true)
+		case SwitchbotActionTurnOff:
+			message = message.TurnOff()
+			Status.SetOn(switchbotAction.Device, false)
+		case SwitchbotActionSetBrightness, SwitchbotActionIncreaseBrightness, SwitchbotActionDecreaseBrightness:
+			brightness := actionBrightness(switchbotAction.Device, string(switchbotAction.Action), switchbotAction.Value)
+			message = message.SetBrightness(brightness)
+			Brightness.SetForDevice(switchbotAction.Device, brightness)
+			Status.SetBrightness(switchbotAction.Device, brightness)
+		default:
+			err := fmt.Errorf("unknown Switchbot action: %s", switchbotAction.Action)
+			log.Err(err).Msgf("Error creating Switchbot message: %s", err)
+			continue
+		}
+		if !message.IsEmpty() {
+			switchbotMessages = append(switchbotMessages, message)
 		}
 	}
+	for _, wledAction := range action.WledActions {
+		message := NewWledMessageForDevice(wledAction.Device)
+		switch wledAction.Action {
+		case WledActionTurnOn:
+			message = message.TurnOn()
+			Status.SetOn(wledAction.Device, true)
+		case WledActionTurnOff:
+			message = message.TurnOff()
+			Status.SetOn(wledAction.Device, false)
+		case WledActionSetBrightness:
+			floatVal, ok := wledAction.Value.(float64)
+			if !ok {
+				floatVal = 50
+			}
+			intVal := int(floatVal)
+			message = message.SetState(DeviceState{Brightness: &intVal}, transition)
+			Brightness.SetForDevice(wledAction.Device, intVal)
+			Status.SetBrightness(wledAction.Device, intVal)
+		case WledActionIncreaseBrightness:
+			floatVal, ok := wledAction.Value.(float64)
+			if !ok {
+				floatVal = 10
+			}
+			intVal := int(floatVal)
+			currentBrightness := Brightness.GetDeviceBrightness(wledAction.Device, WithOnMissingBrightness(wledBrightnessRetriever.GetDeviceBrightness))
+			newBrightness := int(math.Min(float64(currentBrightness+intVal), 100))
+			message = message.SetState(DeviceState{Brightness: &newBrightness}, transition)
+			Brightness.SetForDevice(wledAction.Device, newBrightness)
+			Status.SetBrightness(wledAction.Device, newBrightness)
+		case WledActionDecreaseBrightness:
+			floatVal, ok := wledAction.Value.(float64)
+			if !ok {
+				floatVal = 10
+			}
+			intVal := int(floatVal)
+			currentBrightness := Brightness.GetDeviceBrightness(wledAction.Device, WithOnMissingBrightness(wledBrightnessRetriever.GetDeviceBrightness))
+			newBrightness := int(math.Max(float64(currentBrightness-intVal), 0))
+			message = message.SetState(DeviceState{Brightness: &newBrightness}, transition)
+			Brightness.SetForDevice(wledAction.Device, newBrightness)
+			Status.SetBrightness(wledAction.Device, newBrightness)
+		default:
+			err := fmt.Errorf("unknown WLED action: %s", wledAction.Action)
+			log.Err(err).Msgf("Error creating WLED message: %s", err)
+			continue
+		}
+		if !message.IsEmpty() {
+			log.Info().Msgf("Adding WLED message for device %s, action %s", wledAction.Device, wledAction.Action)
+			wledMessages = append(wledMessages, message)
+		}
+	}
+	for _, sceneAction := range action.SceneActions {
+		sceneGoveeMessages, sceneTwinklyMessages, sceneSwitchbotMessages, sceneWledMessages := c.GetMessagesToDispatchOnSceneAction(sceneAction)
+		goveeMessages = append(goveeMessages, sceneGoveeMessages...)
+		twinklyMessages = append(twinklyMessages, sceneTwinklyMessages...)
+		switchbotMessages = append(switchbotMessages, sceneSwitchbotMessages...)
+		wledMessages = append(wledMessages, sceneWledMessages...)
+	}
 	return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
 }
 
 func (c *Configuration) IsLightRequired(lightName string) bool {
+	return c.isHueSyncSourceRequired(HueSyncSource{Name: lightName})
+}
+
+func (c *Configuration) IsHueGroupRequired(groupName string) bool {
+	return c.isHueSyncSourceRequired(HueSyncSource{Name: groupName, Group: true})
+}
+
+func (c *Configuration) isHueSyncSourceRequired(source HueSyncSource) bool {
 	for _, action := range c.Actions {
-		if action.Trigger == ActionTriggerHueLightSync && action.LightName == lightName {
+		if action.IsSyncedWith(source) {
 			return true
 		}
 	}
@@ -283,64 +510,160 @@ func (c *Configuration) IsLightRequired(lightName string) bool {
 	return false
 }
 
-func (c *Configuration) GetMessagesToDispatchOnHueLightOnOffChange(lightName string, on bool) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
+// GetDevicesSyncedToHue returns the devices whose state has to be read back, with their provider
+func (c *Configuration) GetDevicesSyncedToHue() map[string]string {
+	devices := make(map[string]string)
+	for _, action := range c.Actions {
+		if !action.IsSyncedToHue(action.GetHueSyncSource()) {
+			continue
+		}
+		for _, device := range action.GetDevices() {
+			if provider, ok := c.GetDeviceProvider(device); ok {
+				devices[device] = provider
+			}
+		}
+	}
+	return devices
+}
+
+// HueStateUpdate is a write to a Hue light or group caused by a change observed on a device
+type HueStateUpdate struct {
+	Source     HueSyncSource
+	State      DeviceState
+	Transition time.Duration
+}
+
+// GetHueStateUpdatesOnDeviceChange mirrors the changed attributes of the device onto the Hue lights and groups
+// it is synced with, unless the Hue side changed too within the suppression window and the rule says Hue wins
+func (c *Configuration) GetHueStateUpdatesOnDeviceChange(device string, changed DeviceState) []HueStateUpdate {
+	var updates []HueStateUpdate
+	for _, action := range c.Actions {
+		source := action.GetHueSyncSource()
+		if !action.IsSyncedToHue(source) || !slices.Contains(action.GetDevices(), device) {
+			continue
+		}
+		if action.ConflictResolution == ConflictResolutionHue && SyncGuard.ChangedRecentlyBy(hueLightGuardTarget(source), ChangeOriginHue) {
+			log.Debug().Msgf("Hue [%s] changed on its own, ignoring device [%s] change", source, device)
+			continue
+		}
+		updates = append(updates, HueStateUpdate{
+			Source:     source,
+			State:      changed,
+			Transition: time.Duration(action.TransitionMs) * time.Millisecond,
+		})
+	}
+	return updates
+}
+
+// GetMessagesToDispatchOnHueLightChange applies all the changed attributes at once, in order: on/off, brightness and color.
+// Brightness and color are not synced while the light is off, since most devices would turn on when receiving them.
+func (c *Configuration) GetMessagesToDispatchOnHueLightChange(source HueSyncSource, status LightStatus, changes LightChanges) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
+	var goveeMessages []GoveeMessage
+	var twinklyMessages []TwinklyMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
+
+	// The yielding devices are left out before building the messages: building them records the new state
+	// of each device, which would hide its own change and claim a state that is never sent
+	yielding := c.getYieldingDevices(source)
+	if len(yielding) > 0 {
+		c = c.withoutDevices(yielding)
+	}
+
+	if changes.On {
+		goveeMessages, twinklyMessages, switchbotMessages, wledMessages = c.GetMessagesToDispatchOnHueLightOnOffChange(source, status.on, status.allOn)
+	}
+
+	if status.on && changes.Brightness {
+		brightnessGoveeMessages, brightnessSwitchbotMessages, brightnessWledMessages := c.GetMessagesToDispatchOnHueLightBrightnessChange(source, status.brightness)
+		goveeMessages = append(goveeMessages, brightnessGoveeMessages...)
+		switchbotMessages = append(switchbotMessages, brightnessSwitchbotMessages...)
+		wledMessages = append(wledMessages, brightnessWledMessages...)
+	}
+
+	if status.on && changes.Color {
+		colorGoveeMessages, colorSwitchbotMessages, colorWledMessages := c.GetMessagesToDispatchOnHueLightColorChange(source, status.r, status.g, status.b)
+		goveeMessages = append(goveeMessages, colorGoveeMessages...)
+		switchbotMessages = append(switchbotMessages, colorSwitchbotMessages...)
+		wledMessages = append(wledMessages, colorWledMessages...)
+	}
+
+	// Scenes recalled on the change may target the yielding devices too
+	return dropDevices(yielding, goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+}
+
+// getYieldingDevices returns the devices that have just been changed on their own,
+// synced with the source by a rule whose conflicts are resolved in favour of the device
+func (c *Configuration) getYieldingDevices(source HueSyncSource) map[string]bool {
+	yielding := make(map[string]bool)
+	for _, action := range c.Actions {
+		if !action.IsSyncedWith(source) || action.ConflictResolution != ConflictResolutionDevice {
+			continue
+		}
+		for _, device := range action.GetDevices() {
+			if SyncGuard.ChangedRecentlyBy(device, ChangeOriginDevice) {
+				log.Debug().Msgf("Device [%s] changed on its own, ignoring [%s] change", device, source)
+				yielding[device] = true
+			}
+		}
+	}
+	return yielding
+}
+
+// withoutDevices returns a copy of the configuration whose actions do not target the given devices
+func (c *Configuration) withoutDevices(devices map[string]bool) *Configuration {
+	copied := *c
+	copied.Actions = make([]ConfigurationAction, len(c.Actions))
+	for i, action := range c.Actions {
+		action.GoveeActions = slices.DeleteFunc(slices.Clone(action.GoveeActions), func(a ConfigurationActionGoveeAction) bool { return devices[a.Device] })
+		if devices["Twinkly Device"] { // TODO: Add support to multiple devices
+			action.TwinklyActions = nil
+		}
+		action.SwitchbotActions = slices.DeleteFunc(slices.Clone(action.SwitchbotActions), func(a ConfigurationActionSwitchbotAction) bool { return devices[a.Device] })
+		action.WledActions = slices.DeleteFunc(slices.Clone(action.WledActions), func(a ConfigurationActionWledAction) bool { return devices[a.Device] })
+		copied.Actions[i] = action
+	}
+	return &copied
+}
+
+// dropDevices removes the messages to the given devices
+func dropDevices(
+	devices map[string]bool,
+	goveeMessages []GoveeMessage,
+	twinklyMessages []TwinklyMessage,
+	switchbotMessages []SwitchbotMessage,
+	wledMessages []WledMessage,
+) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
+	if len(devices) == 0 {
+		return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
+	}
+
+	goveeMessages = slices.DeleteFunc(goveeMessages, func(m GoveeMessage) bool { return devices[m.Device] })
+	if devices["Twinkly Device"] { // TODO: Add support to multiple devices
+		twinklyMessages = nil
+	}
+	switchbotMessages = slices.DeleteFunc(switchbotMessages, func(m SwitchbotMessage) bool { return devices[m.Device] })
+	wledMessages = slices.DeleteFunc(wledMessages, func(m WledMessage) bool { return devices[m.Device] })
+	return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
+}
+
+// GetMessagesToDispatchOnHueLightOnOffChange handles both lights and groups:
+// for lights anyOn and allOn carry the same value
+func (c *Configuration) GetMessagesToDispatchOnHueLightOnOffChange(source HueSyncSource, anyOn, allOn bool) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
 	var goveeMessages []GoveeMessage
 	var twinklyMessages []TwinklyMessage
 	var switchbotMessages []SwitchbotMessage
 	var wledMessages []WledMessage
 	for _, action := range c.Actions {
-		if action.Trigger == ActionTriggerHueLightSync && action.LightName == lightName {
+		if action.IsSyncedWith(source) {
+			on := action.GetHueOnState(anyOn, allOn)
 			for _, goveeAction := range action.GoveeActions {
-				var message []byte
 				switch {
-				case goveeAction.SyncValue == LightSyncValueOnOff:
-					if on {
-						message = mustMarshal(GoveeTurn{
-							Msg: GoveeTurnMsg{
-								Cmd: "turn",
-								Data: GoveeTurnMsgData{
-									Value: 1,
-								},
-							},
-						})
-						Status.SetOn(goveeAction.Device, true)
-					} else {
-						message = mustMarshal(GoveeTurn{
-							Msg: GoveeTurnMsg{
-								Cmd: "turn",
-								Data: GoveeTurnMsgData{
-									Value: 0,
-								},
-							},
-						})
-						Status.SetOn(goveeAction.Device, false)
-					}
-				case goveeAction.SyncValue == LightSyncValueOn && on:
-					message = mustMarshal(GoveeTurn{
-						Msg: GoveeTurnMsg{
-							Cmd: "turn",
-							Data: GoveeTurnMsgData{
-								Value: 1,
-							},
-						},
-					})
-					Status.SetOn(goveeAction.Device, true)
-				case goveeAction.SyncValue == LightSyncValueOff && !on:
-					message = mustMarshal(GoveeTurn{
-						Msg: GoveeTurnMsg{
-							Cmd: "turn",
-							Data: GoveeTurnMsgData{
-								Value: 0,
-							},
-						},
-					})
-					Status.SetOn(goveeAction.Device, false)
-				}
-				if message != nil {
-					goveeMessages = append(goveeMessages, GoveeMessage{
-						Device: goveeAction.Device,
-						Data:   message,
-					})
+				case goveeAction.SyncValue == LightSyncValueOnOff,
+					goveeAction.SyncValue == LightSyncValueOn && on,
+					goveeAction.SyncValue == LightSyncValueOff && !on:
+					goveeMessages = append(goveeMessages, NewGoveeTurnMessage(goveeAction.Device, on))
+					Status.SetOn(goveeAction.Device, on)
 				}
 			}
 			for _, twinklyAction := range action.TwinklyActions {
@@ -422,49 +745,40 @@ func (c *Configuration) GetMessagesToDispatchOnHueLightOnOffChange(lightName str
 					wledMessages = append(wledMessages, message)
 				}
 			}
+			for _, sceneAction := range action.SceneActions {
+				if !sceneActionMatchesOnOff(sceneAction, on) {
+					continue
+				}
+				sceneGoveeMessages, sceneTwinklyMessages, sceneSwitchbotMessages, sceneWledMessages := c.GetMessagesToDispatchOnSceneAction(sceneAction)
+				goveeMessages = append(goveeMessages, sceneGoveeMessages...)
+				twinklyMessages = append(twinklyMessages, sceneTwinklyMessages...)
+				switchbotMessages = append(switchbotMessages, sceneSwitchbotMessages...)
+				wledMessages = append(wledMessages, sceneWledMessages...)
+			}
 		}
 	}
 	return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
 }
 
-func (c *Configuration) GetMessagesToDispatchOnHueLightBrightnessChange(lightName string, brightness int) ([]GoveeMessage, []SwitchbotMessage, []WledMessage) {
+func (c *Configuration) GetMessagesToDispatchOnHueLightBrightnessChange(source HueSyncSource, brightness int) ([]GoveeMessage, []SwitchbotMessage, []WledMessage) {
 	var goveeMessages []GoveeMessage
 	var switchbotMessages []SwitchbotMessage
 	var wledMessages []WledMessage
 	for _, action := range c.Actions {
-		if action.Trigger == ActionTriggerHueLightSync && action.LightName == lightName {
+		if action.IsSyncedWith(source) {
+			transition := time.Duration(action.TransitionMs) * time.Millisecond
 			for _, goveeAction := range action.GoveeActions {
-				var message []byte
-				brightnessValueChanged := -1
 				switch goveeAction.SyncValue {
 				case LightSyncValueBrightness:
 					brightnessToSend := brightness
 					if len(goveeAction.BrightnessRange) == 2 {
-						// brightnessRangeDelta := goveeAction.BrightnessRange[1] - goveeAction.BrightnessRange[0]
-						// brightnessPercentageDelta := float64(brightnessRangeDelta) * (float64(brightness) / 100)
-						// brightnessToSend = int(float64(goveeAction.BrightnessRange[0]) + brightnessPercentageDelta)
-						// brightnessToSend = int(math.Min(math.Max(float64(brightnessToSend), 0), 100))
 						brightnessToSend = getAdjustedBrightnessByRange(brightness, goveeAction.BrightnessRange)
 					}
-					brightnessValueChanged = brightnessToSend
-					message = mustMarshal(GoveeBrightnessRequest{
-						Msg: GoveeBrightnessRequestMsg{
-							Cmd: "brightness",
-							Data: GoveeBrightnessRequestMsgData{
-								Value: brightnessToSend,
-							},
-						},
-					})
-				}
-				if message != nil {
-					goveeMessages = append(goveeMessages, GoveeMessage{
-						Device: goveeAction.Device,
-						Data:   message,
-					})
-					if brightnessValueChanged == -1 {
-						Status.SetBrightness(goveeAction.Device, brightnessValueChanged)
-						Brightness.SetForDevice(goveeAction.Device, brightnessValueChanged)
-					}
+					previousStatus, _ := Status.Get(goveeAction.Device)
+					goveeMessages = append(goveeMessages, NewGoveeBrightnessMessage(goveeAction.Device, brightnessToSend).
+						WithTransition(NewBrightnessTransition(transition, previousStatus.Brightness, brightnessToSend)))
+					Status.SetBrightness(goveeAction.Device, brightnessToSend)
+					Brightness.SetForDevice(goveeAction.Device, brightnessToSend)
 				}
 			}
 			for _, switchbotAction := range action.SwitchbotActions {
@@ -490,9 +804,9 @@ func (c *Configuration) GetMessagesToDispatchOnHueLightBrightnessChange(lightNam
 					if len(wledAction.BrightnessRange) == 2 {
 						brightnessToSend = getAdjustedBrightnessByRange(brightness, wledAction.BrightnessRange)
 					}
-					brightnessToSend = mapBrightness(brightnessToSend, []int{0, 100}, []int{0, 255})
-					message = message.SetBrightness(brightnessToSend)
+					message = message.SetState(DeviceState{Brightness: &brightnessToSend}, transition)
 					Status.SetBrightness(wledAction.Device, brightnessToSend)
+					Brightness.SetForDevice(wledAction.Device, brightnessToSend)
 				}
 				if !message.IsEmpty() {
 					wledMessages = append(wledMessages, message)
@@ -548,39 +862,98 @@ func getAdjustedBrightnessByRange(inBrightness int, brightnessRange []int) int {
 	return adjustedBrightness
 }
 
-func (c *Configuration) GetMessagesToDispatchOnHueLightColorChange(lightName string, r, g, b uint8) []GoveeMessage {
-	var messages []GoveeMessage
+func (c *Configuration) GetMessagesToDispatchOnHueLightColorChange(source HueSyncSource, r, g, b uint8) ([]GoveeMessage, []SwitchbotMessage, []WledMessage) {
+	var goveeMessages []GoveeMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
 	for _, action := range c.Actions {
-		if action.Trigger == ActionTriggerHueLightSync && action.LightName == lightName {
+		if action.IsSyncedWith(source) {
+			transition := time.Duration(action.TransitionMs) * time.Millisecond
+			color := deviceColor{Red: int(r), Green: int(g), Blue: int(b)}
 			for _, goveeAction := range action.GoveeActions {
-				var message []byte
 				switch goveeAction.SyncValue {
 				case LightSyncValueColor:
-					message = mustMarshal(GoveeColorRequest{
-						Msg: GoveeColorRequestMsg{
-							Cmd: "colorwc",
-							Data: GoveeColorRequestMsgData{
-								Color: GoveeColorRequestMsgDataColor{
-									R: int(r),
-									G: int(g),
-									B: int(b),
-								},
-							},
-						},
-					})
+					if len(goveeAction.SyncSegments) > 0 {
+						// Only a zone of the device follows the light: its color is not the one of the device,
+						// and there is no transition to step through
+						segmentsMessage, err := NewGoveeSegmentColorMessage(goveeAction.Device, []GoveeSegmentColor{{Segments: goveeAction.SyncSegments, Color: color}})
+						if err != nil {
+							log.Err(err).Msgf("Error creating Govee segments message for %s: %s", goveeAction.Device, err)
+							continue
+						}
+						goveeMessages = append(goveeMessages, segmentsMessage)
+						Status.SetOn(goveeAction.Device, true)
+						continue
+					}
+					previousStatus, _ := Status.Get(goveeAction.Device)
+					goveeMessages = append(goveeMessages, NewGoveeColorMessage(goveeAction.Device, color.Red, color.Green, color.Blue).
+						WithTransition(NewColorTransition(transition, previousStatus.Color, color)))
 					Status.SetOn(goveeAction.Device, true)
-					Status.SetColor(goveeAction.Device, int(r), int(g), int(b))
+					Status.SetColor(goveeAction.Device, color.Red, color.Green, color.Blue)
 				}
-				if message != nil {
-					messages = append(messages, GoveeMessage{
-						Device: goveeAction.Device,
-						Data:   message,
-					})
+			}
+			for _, switchbotAction := range action.SwitchbotActions {
+				message := NewSwitchbotMessageForDevice(switchbotAction.Device)
+				switch switchbotAction.SyncValue {
+				case LightSyncValueColor:
+					message = message.SetColor(int(r), int(g), int(b))
+					Status.SetColor(switchbotAction.Device, int(r), int(g), int(b))
+				}
+				if !message.IsEmpty() {
+					switchbotMessages = append(switchbotMessages, message)
+				}
+			}
+			for _, wledAction := range action.WledActions {
+				message := NewWledMessageForDevice(wledAction.Device)
+				switch wledAction.SyncValue {
+				case LightSyncValueColor:
+					message = message.SetState(DeviceState{Color: &color}, transition)
+					Status.SetColor(wledAction.Device, int(r), int(g), int(b))
+				}
+				if !message.IsEmpty() {
+					wledMessages = append(wledMessages, message)
 				}
 			}
 		}
 	}
-	return messages
+	return goveeMessages, switchbotMessages, wledMessages
+}
+
+func (c *Configuration) GetRequiredHueScenes() []string {
+	var scenes []string
+	for _, action := range c.Actions {
+		if action.Trigger == ActionTriggerHueSceneRecall {
+			scenes = append(scenes, action.HueSceneName)
+		}
+	}
+	return scenes
+}
+
+// GetMessagesToDispatchOnHueSceneRecall matches actions by scene name and, when the action specifies it,
+// by the name of the room or zone the scene belongs to (Hue scene names are only unique within a group)
+func (c *Configuration) GetMessagesToDispatchOnHueSceneRecall(
+	sceneName string,
+	groupName string,
+	wledBrightnessRetriever BrightnessRetriever,
+) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
+	var goveeMessages []GoveeMessage
+	var twinklyMessages []TwinklyMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
+	for _, action := range c.Actions {
+		if action.Trigger != ActionTriggerHueSceneRecall || action.HueSceneName != sceneName {
+			continue
+		}
+		if action.GroupName != "" && action.GroupName != groupName {
+			continue
+		}
+		actionGoveeMessages, actionTwinklyMessages, actionSwitchbotMessages, actionWledMessages := c.GetMessagesToDispatchOnAction(action, wledBrightnessRetriever)
+		goveeMessages = append(goveeMessages, actionGoveeMessages...)
+		twinklyMessages = append(twinklyMessages, actionTwinklyMessages...)
+		switchbotMessages = append(switchbotMessages, actionSwitchbotMessages...)
+		wledMessages = append(wledMessages, actionWledMessages...)
+	}
+	return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
 }
 
 func (c *Configuration) GetRequiredPresenceSensors() []string {
@@ -617,9 +990,177 @@ func (c *Configuration) GetMessagesToDispatchOnHuePresenceSensorChange(name stri
 	if presenceSensorInterface == nil {
 		return nil, nil, nil, nil
 	}
-	// presenceSensorAction := presenceSensorInterface.(*ConfigurationAction)
-	// TODO: Implement this
-	return nil, nil, nil, nil
+	presenceSensorAction := presenceSensorInterface.(*ConfigurationAction)
+	// TODO: Implement provider actions
+	var goveeMessages []GoveeMessage
+	var twinklyMessages []TwinklyMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
+	for _, sceneAction := range presenceSensorAction.SceneActions {
+		if !sceneActionMatchesOnOff(sceneAction, presence) {
+			continue
+		}
+		sceneGoveeMessages, sceneTwinklyMessages, sceneSwitchbotMessages, sceneWledMessages := c.GetMessagesToDispatchOnSceneAction(sceneAction)
+		goveeMessages = append(goveeMessages, sceneGoveeMessages...)
+		twinklyMessages = append(twinklyMessages, sceneTwinklyMessages...)
+		switchbotMessages = append(switchbotMessages, sceneSwitchbotMessages...)
+		wledMessages = append(wledMessages, sceneWledMessages...)
+	}
+	return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
+}
+
+// sceneActionMatchesOnOff tells whether a scene action has to run when a light (or a presence sensor) turns on or off.
+// Scene actions with "on-off" or without sync value run on both transitions.
+func sceneActionMatchesOnOff(sceneAction ConfigurationActionSceneAction, on bool) bool {
+	switch sceneAction.SyncValue {
+	case LightSyncValueOn:
+		return on
+	case LightSyncValueOff:
+		return !on
+	case LightSyncValueOnOff, "":
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Configuration) GetMessagesToDispatchOnSceneAction(sceneAction ConfigurationActionSceneAction) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
+	switch sceneAction.Action {
+	case SceneActionSnapshot:
+		scene, err := Scenes.Snapshot(sceneAction.Scene, c.ResolveDevices(sceneAction.Devices))
+		if err != nil {
+			log.Err(err).Msgf("Error capturing scene: %s", err)
+			return nil, nil, nil, nil
+		}
+		log.Info().Msgf("Captured scene [%s] with %d devices", sceneAction.Scene, len(scene.Devices))
+		return nil, nil, nil, nil
+	case SceneActionApply, SceneActionRestore, "":
+		scene, ok := Scenes.Get(sceneAction.Scene)
+		if !ok {
+			err := fmt.Errorf("unknown scene: %s", sceneAction.Scene)
+			log.Err(err).Msgf("Error applying scene: %s", err)
+			return nil, nil, nil, nil
+		}
+		if sceneAction.Action == SceneActionRestore {
+			Scenes.DiscardSnapshot(sceneAction.Scene)
+		}
+		log.Info().Msgf("Applying scene [%s]", sceneAction.Scene)
+		return c.GetMessagesToApplyScene(scene)
+	default:
+		err := fmt.Errorf("unknown scene action: %s", sceneAction.Action)
+		log.Err(err).Msgf("Error creating scene messages: %s", err)
+		return nil, nil, nil, nil
+	}
+}
+
+func (c *Configuration) GetMessagesToApplyScene(scene ConfigurationScene) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
+	var goveeMessages []GoveeMessage
+	var twinklyMessages []TwinklyMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
+	for deviceOrGroup, state := range scene.Devices {
+		for _, device := range c.ResolveDevices([]string{deviceOrGroup}) {
+			deviceGoveeMessages, deviceTwinklyMessages, deviceSwitchbotMessages, deviceWledMessages := c.GetMessagesToApplyDeviceState(device, state, time.Duration(scene.TransitionMs)*time.Millisecond)
+			goveeMessages = append(goveeMessages, deviceGoveeMessages...)
+			twinklyMessages = append(twinklyMessages, deviceTwinklyMessages...)
+			switchbotMessages = append(switchbotMessages, deviceSwitchbotMessages...)
+			wledMessages = append(wledMessages, deviceWledMessages...)
+		}
+	}
+	return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
+}
+
+// GetMessagesToApplyDeviceState builds the provider specific messages needed to bring a device to the given state,
+// fading brightness and color over the transition duration (if any).
+// When the state turns the device off, brightness, color and effect are not sent.
+func (c *Configuration) GetMessagesToApplyDeviceState(device string, state DeviceState, transition time.Duration) ([]GoveeMessage, []TwinklyMessage, []SwitchbotMessage, []WledMessage) {
+	var goveeMessages []GoveeMessage
+	var twinklyMessages []TwinklyMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
+
+	provider, ok := c.GetDeviceProvider(device)
+	if !ok {
+		err := fmt.Errorf("unknown device: %s", device)
+		log.Err(err).Msgf("Error applying device state: %s", err)
+		return nil, nil, nil, nil
+	}
+
+	if state.On != nil && !*state.On {
+		state = DeviceState{On: state.On}
+	}
+
+	switch provider {
+	case ProviderGovee:
+		previousStatus, _ := Status.Get(device)
+		if state.On != nil {
+			goveeMessages = append(goveeMessages, NewGoveeTurnMessage(device, *state.On))
+			Status.SetOn(device, *state.On)
+		}
+		if state.Brightness != nil {
+			goveeMessages = append(goveeMessages, NewGoveeBrightnessMessage(device, *state.Brightness).
+				WithTransition(NewBrightnessTransition(transition, previousStatus.Brightness, *state.Brightness)))
+			Status.SetBrightness(device, *state.Brightness)
+			Brightness.SetForDevice(device, *state.Brightness)
+		}
+		if state.Color != nil {
+			goveeMessages = append(goveeMessages, NewGoveeColorMessage(device, state.Color.Red, state.Color.Green, state.Color.Blue).
+				WithTransition(NewColorTransition(transition, previousStatus.Color, *state.Color)))
+			Status.SetColor(device, state.Color.Red, state.Color.Green, state.Color.Blue)
+		}
+		if state.Effect != nil {
+			log.Debug().Msgf("Ignoring effect for Govee device [%s]: not supported", device)
+		}
+	case ProviderSwitchbot:
+		if state.On != nil {
+			message := NewSwitchbotMessageForDevice(device)
+			if *state.On {
+				message = message.TurnOn()
+			} else {
+				message = message.TurnOff()
+			}
+			switchbotMessages = append(switchbotMessages, message)
+			Status.SetOn(device, *state.On)
+		}
+		if state.Brightness != nil {
+			switchbotMessages = append(switchbotMessages, NewSwitchbotMessageForDevice(device).SetBrightness(*state.Brightness))
+			Status.SetBrightness(device, *state.Brightness)
+		}
+		if state.Color != nil {
+			switchbotMessages = append(switchbotMessages, NewSwitchbotMessageForDevice(device).SetColor(state.Color.Red, state.Color.Green, state.Color.Blue))
+			Status.SetColor(device, state.Color.Red, state.Color.Green, state.Color.Blue)
+		}
+		if state.Effect != nil {
+			log.Debug().Msgf("Ignoring effect for Switchbot device [%s]: not supported", device)
+		}
+	case ProviderWled:
+		wledMessages = append(wledMessages, NewWledMessageForDevice(device).SetState(state, transition))
+		if state.On != nil {
+			Status.SetOn(device, *state.On)
+		}
+		if state.Brightness != nil {
+			Status.SetBrightness(device, *state.Brightness)
+			Brightness.SetForDevice(device, *state.Brightness)
+		}
+		if state.Color != nil {
+			Status.SetColor(device, state.Color.Red, state.Color.Green, state.Color.Blue)
+		}
+	case ProviderTwinkly:
+		// TODO: Add support to multiple devices
+		if state.On != nil {
+			if *state.On {
+				twinklyMessages = append(twinklyMessages, TwinklyMessageOn)
+			} else {
+				twinklyMessages = append(twinklyMessages, TwinklyMessageOff)
+			}
+			Status.SetOn(device, *state.On)
+		}
+		if state.Brightness != nil || state.Color != nil || state.Effect != nil {
+			log.Debug().Msgf("Ignoring brightness, color and effect for Twinkly device [%s]: not supported", device)
+		}
+	}
+
+	return goveeMessages, twinklyMessages, switchbotMessages, wledMessages
 }
 
 func (c *Configuration) GetDialNameByID(id string) (string, bool) {
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrAlreadyConfigured is returned when a device to add to configuration.json conflicts with the configured ones
+var ErrAlreadyConfigured = errors.New("already configured")
+
+// jsonObjectField is a member of a JSON object, with its value as written in the file
+type jsonObjectField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// AddGoveeDeviceToConfigurationFile adds the device to the govee section of configuration.json.
+// The other sections are kept as written and in the same order: the file is only reindented.
+// The running components keep using the configuration they started with.
+func AddGoveeDeviceToConfigurationFile(alias, mac string) error {
+	filePath, err := configurationFilePath()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("error checking configuration file: %w", err)
+	}
+	rawConfiguration, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading configuration file: %w", err)
+	}
+
+	sections, err := decodeJSONObjectFields(rawConfiguration)
+	if err != nil {
+		return fmt.Errorf("error parsing configuration file: %w", err)
+	}
+
+	var devices []jsonObjectField
+	goveeIndex := slices.IndexFunc(sections, func(section jsonObjectField) bool { return section.Key == "govee" })
+	if goveeIndex >= 0 && string(bytes.TrimSpace(sections[goveeIndex].Value)) != "null" {
+		devices, err = decodeJSONObjectFields(sections[goveeIndex].Value)
+		if err != nil {
+			return fmt.Errorf("error parsing the govee section of the configuration file: %w", err)
+		}
+	}
+	for _, device := range devices {
+		if device.Key == alias {
+			return fmt.Errorf("%w: a Govee device is named %q", ErrAlreadyConfigured, alias)
+		}
+		var deviceConfiguration GoveeDeviceConfiguration
+		if err := json.Unmarshal(device.Value, &deviceConfiguration); err == nil && strings.EqualFold(deviceConfiguration.MAC, mac) {
+			return fmt.Errorf("%w: %s is configured as %q", ErrAlreadyConfigured, mac, device.Key)
+		}
+	}
+
+	deviceConfiguration, err := json.Marshal(GoveeDeviceConfiguration{MAC: mac})
+	if err != nil {
+		return fmt.Errorf("error encoding device configuration: %w", err)
+	}
+	devices = append(devices, jsonObjectField{Key: alias, Value: deviceConfiguration})
+	goveeSection, err := encodeJSONObjectFields(devices)
+	if err != nil {
+		return fmt.Errorf("error encoding the govee section: %w", err)
+	}
+	if goveeIndex >= 0 {
+		sections[goveeIndex].Value = goveeSection
+	} else {
+		sections = append(sections, jsonObjectField{Key: "govee", Value: goveeSection})
+	}
+
+	compactConfiguration, err := encodeJSONObjectFields(sections)
+	if err != nil {
+		return fmt.Errorf("error encoding configuration: %w", err)
+	}
+	var newConfiguration bytes.Buffer
+	if err := json.Indent(&newConfiguration, compactConfiguration, "", "    "); err != nil {
+		return fmt.Errorf("error indenting configuration: %w", err)
+	}
+	if bytes.HasSuffix(rawConfiguration, []byte("\n")) {
+		newConfiguration.WriteByte('\n')
+	}
+
+	return replaceFile(filePath, newConfiguration.Bytes(), info.Mode().Perm())
+}
+
+// decodeJSONObjectFields reads the members of a JSON object in the order they are written
+func decodeJSONObjectFields(data []byte) ([]jsonObjectField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+
+	var fields []jsonObjectField
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		// Object keys are always strings
+		fields = append(fields, jsonObjectField{Key: token.(string), Value: value})
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// encodeJSONObjectFields writes the members as a compact JSON object, in the given order
+func encodeJSONObjectFields(fields []jsonObjectField) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(field.Value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// replaceFile writes a sibling temporary file and renames it, so that the file is never left half written
+func replaceFile(filePath string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing %s: %w", file.Name(), err)
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return fmt.Errorf("error setting permissions of %s: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", file.Name(), err)
+	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		return fmt.Errorf("error replacing %s: %w", filePath, err)
+	}
+	return nil
+}
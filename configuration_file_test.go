@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAddGoveeDeviceToConfigurationFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	original := `{
+    "app_name": "test",
+    "govee": {
+        "Lamp": {
+            "mac": "33:1E:D6:38:32:31:2A:3A"
+        }
+    },
+    "http": {
+        "address": ":8080"
+    }
+}
+`
+	if err := os.WriteFile("configuration.json", []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddGoveeDeviceToConfigurationFile("Strip", "0C:D6:C8:6B:82:C6:2A:7B"); err != nil {
+		t.Fatalf("adopting: %s", err)
+	}
+
+	expected := strings.Replace(original, `            "mac": "33:1E:D6:38:32:31:2A:3A"
+        }
+`, `            "mac": "33:1E:D6:38:32:31:2A:3A"
+        },
+        "Strip": {
+            "mac": "0C:D6:C8:6B:82:C6:2A:7B"
+        }
+`, 1)
+	written, err := os.ReadFile("configuration.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != expected {
+		t.Fatalf("unexpected configuration:\n%s\nexpected:\n%s", written, expected)
+	}
+	if info, err := os.Stat("configuration.json"); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("permissions not kept: %v %v", info.Mode(), err)
+	}
+
+	configuration, err := LoadConfiguration()
+	if err != nil {
+		t.Fatalf("loading the written configuration: %s", err)
+	}
+	if alias, ok := configuration.GetGoveeDeviceAliasByMAC("0C:D6:C8:6B:82:C6:2A:7B"); !ok || alias != "Strip" {
+		t.Fatalf("adopted device not loaded: %q %v", alias, ok)
+	}
+
+	for _, conflict := range []struct{ alias, mac string }{
+		{"Strip", "D5:F2:CA:5F:C3:46:35:34"},
+		{"Other", "0c:d6:c8:6b:82:c6:2a:7b"},
+	} {
+		if err := AddGoveeDeviceToConfigurationFile(conflict.alias, conflict.mac); !errors.Is(err, ErrAlreadyConfigured) {
+			t.Errorf("adopting %s as %q: expected ErrAlreadyConfigured, got %v", conflict.mac, conflict.alias, err)
+		}
+	}
+}
@@ -1,20 +1,31 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type ActionTrigger string
 
 const (
 	ActionTriggerHueTapDialButtonPress ActionTrigger = "hue tap dial button press"
 	ActionTriggerHueLightSync          ActionTrigger = "hue light sync"
+	ActionTriggerHueGroupSync          ActionTrigger = "hue group sync"
+	ActionTriggerHueSceneRecall        ActionTrigger = "hue scene recall"
 	ActionTriggerPresenceSensor        ActionTrigger = "presence sensor"
 )
 
 type GoveeAction string
 
 const (
-	GoveeActionTurnOn  GoveeAction = "turn on"
-	GoveeActionTurnOff GoveeAction = "turn off"
+	GoveeActionTurnOn             GoveeAction = "turn on"
+	GoveeActionTurnOff            GoveeAction = "turn off"
+	GoveeActionSetBrightness      GoveeAction = "set brightness"
+	GoveeActionIncreaseBrightness GoveeAction = "increase brightness"
+	GoveeActionDecreaseBrightness GoveeAction = "decrease brightness"
+	GoveeActionSetSegments        GoveeAction = "set segments" // Colors of single segments, see "segments"
+	GoveeActionSetGradient        GoveeAction = "set gradient" // Colors blended along the device, see "colors"
+	GoveeActionApplyScene         GoveeAction = "apply scene"  // Built-in scene or DIY effect, see "scenes"
 )
 
 type TwinklyAction string
@@ -27,8 +38,11 @@ const (
 type SwitchbotAction string
 
 const (
-	SwitchbotActionTurnOn  SwitchbotAction = "turn on"
-	SwitchbotActionTurnOff SwitchbotAction = "turn off"
+	SwitchbotActionTurnOn             SwitchbotAction = "turn on"
+	SwitchbotActionTurnOff            SwitchbotAction = "turn off"
+	SwitchbotActionSetBrightness      SwitchbotAction = "set brightness"
+	SwitchbotActionIncreaseBrightness SwitchbotAction = "increase brightness"
+	SwitchbotActionDecreaseBrightness SwitchbotAction = "decrease brightness"
 )
 
 type WledAction string
@@ -51,6 +65,76 @@ const (
 	LightSyncValueColor      LightSyncValue = "color"
 )
 
+type GroupAction string
+
+const (
+	GroupActionTurnOn             GroupAction = "turn on"
+	GroupActionTurnOff            GroupAction = "turn off"
+	GroupActionSetBrightness      GroupAction = "set brightness"
+	GroupActionIncreaseBrightness GroupAction = "increase brightness"
+	GroupActionDecreaseBrightness GroupAction = "decrease brightness"
+)
+
+type SceneAction string
+
+const (
+	SceneActionApply    SceneAction = "apply"
+	SceneActionSnapshot SceneAction = "snapshot"
+	SceneActionRestore  SceneAction = "restore"
+)
+
+const (
+	ProviderGovee     = "Govee"
+	ProviderSwitchbot = "Switchbot"
+	ProviderWled      = "WLED"
+	ProviderTwinkly   = "Twinkly"
+)
+
+// DeviceCapability is something a device can be asked to do
+type DeviceCapability string
+
+const (
+	DeviceCapabilityOnOff            DeviceCapability = "on_off"
+	DeviceCapabilityBrightness       DeviceCapability = "brightness"
+	DeviceCapabilityColor            DeviceCapability = "color"
+	DeviceCapabilityColorTemperature DeviceCapability = "color_temperature" // Approximated through RGB
+	DeviceCapabilityEffect           DeviceCapability = "effect"
+)
+
+// ProviderCapabilities lists what GetMessagesToApplyDeviceState supports for each provider
+var ProviderCapabilities = map[string][]DeviceCapability{
+	ProviderGovee:     {DeviceCapabilityOnOff, DeviceCapabilityBrightness, DeviceCapabilityColor, DeviceCapabilityColorTemperature},
+	ProviderSwitchbot: {DeviceCapabilityOnOff, DeviceCapabilityBrightness, DeviceCapabilityColor, DeviceCapabilityColorTemperature},
+	ProviderWled:      {DeviceCapabilityOnOff, DeviceCapabilityBrightness, DeviceCapabilityColor, DeviceCapabilityColorTemperature, DeviceCapabilityEffect},
+	ProviderTwinkly:   {DeviceCapabilityOnOff},
+}
+
+type HueGroupOnState string
+
+const (
+	HueGroupOnStateAnyOn HueGroupOnState = "any_on"
+	HueGroupOnStateAllOn HueGroupOnState = "all_on"
+)
+
+// SyncDirection tells which way a "hue light sync" or "hue group sync" rule mirrors the state
+type SyncDirection string
+
+const (
+	SyncDirectionHueToDevice   SyncDirection = "hue to device" // Default
+	SyncDirectionDeviceToHue   SyncDirection = "device to hue"
+	SyncDirectionBidirectional SyncDirection = "bidirectional"
+)
+
+// ConflictResolution tells which side wins when both the Hue light and a synced device change
+// within the suppression window
+type ConflictResolution string
+
+const (
+	ConflictResolutionLatest ConflictResolution = "latest" // Default: the last change wins
+	ConflictResolutionHue    ConflictResolution = "hue"
+	ConflictResolutionDevice ConflictResolution = "device"
+)
+
 type TwinklyMessage string
 
 const (
@@ -59,6 +143,7 @@ const (
 )
 
 type ConfigurationAction struct {
+	Name               string                               `json:"name"` // Optional, needed to trigger the action through the API
 	Trigger            ActionTrigger                        `json:"trigger"`
 	DialName           string                               `json:"dial_name"`
 	PresenceSensorName string                               `json:"presence_sensor_name"`
@@ -67,14 +152,91 @@ type ConfigurationAction struct {
 	TwinklyActions     []ConfigurationTwinklyAction         `json:"twinkly_actions"`
 	SwitchbotActions   []ConfigurationActionSwitchbotAction `json:"switchbot_actions"`
 	WledActions        []ConfigurationActionWledAction      `json:"wled_actions"`
+	SceneActions       []ConfigurationActionSceneAction     `json:"scene_actions"`
+	GroupActions       []ConfigurationActionGroupAction     `json:"group_actions"`
 	LightName          string                               `json:"light_name"`
+	GroupName          string                               `json:"group_name"`          // Hue room or zone name, for "hue group sync" and optionally for "hue scene recall"
+	TransitionMs       int                                  `json:"transition_ms"`       // Fade duration for brightness and color changes
+	HueSceneName       string                               `json:"hue_scene_name"`      // For "hue scene recall"
+	HueGroupOnState    HueGroupOnState                      `json:"hue_group_on_state"`  // "any_on" (default) or "all_on", for "hue group sync"
+	Direction          SyncDirection                        `json:"direction"`           // For "hue light sync" and "hue group sync"
+	ConflictResolution ConflictResolution                   `json:"conflict_resolution"` // For "bidirectional" sync
+}
+
+// HueSyncSource identifies the Hue light, or the Hue group (room or zone), whose state gets mirrored
+type HueSyncSource struct {
+	Name  string
+	Group bool
+}
+
+func (s HueSyncSource) String() string {
+	if s.Group {
+		return fmt.Sprintf("group %s", s.Name)
+	}
+	return s.Name
+}
+
+// IsSyncedWith tells whether the action mirrors the Hue source onto its devices
+func (a ConfigurationAction) IsSyncedWith(source HueSyncSource) bool {
+	return a.mirrors(source) && a.Direction != SyncDirectionDeviceToHue
+}
+
+// IsSyncedToHue tells whether the action mirrors its devices back onto the Hue source
+func (a ConfigurationAction) IsSyncedToHue(source HueSyncSource) bool {
+	return a.mirrors(source) && (a.Direction == SyncDirectionDeviceToHue || a.Direction == SyncDirectionBidirectional)
+}
+
+func (a ConfigurationAction) mirrors(source HueSyncSource) bool {
+	if source.Group {
+		return a.Trigger == ActionTriggerHueGroupSync && a.GroupName == source.Name
+	}
+	return a.Trigger == ActionTriggerHueLightSync && a.LightName == source.Name
+}
+
+// GetHueSyncSource returns the Hue light or group of a "hue light sync" or "hue group sync" action
+func (a ConfigurationAction) GetHueSyncSource() HueSyncSource {
+	if a.Trigger == ActionTriggerHueGroupSync {
+		return HueSyncSource{Name: a.GroupName, Group: true}
+	}
+	return HueSyncSource{Name: a.LightName}
+}
+
+// GetDevices returns the devices targeted by the provider actions
+func (a ConfigurationAction) GetDevices() []string {
+	var devices []string
+	for _, goveeAction := range a.GoveeActions {
+		devices = append(devices, goveeAction.Device)
+	}
+	if len(a.TwinklyActions) > 0 {
+		devices = append(devices, "Twinkly Device") // TODO: Add support to multiple devices
+	}
+	for _, switchbotAction := range a.SwitchbotActions {
+		devices = append(devices, switchbotAction.Device)
+	}
+	for _, wledAction := range a.WledActions {
+		devices = append(devices, wledAction.Device)
+	}
+	return devices
+}
+
+// GetHueOnState picks the on state the action is interested in: lights report the same value for both
+func (a ConfigurationAction) GetHueOnState(anyOn, allOn bool) bool {
+	if a.HueGroupOnState == HueGroupOnStateAllOn {
+		return allOn
+	}
+	return anyOn
 }
 
 type ConfigurationActionGoveeAction struct {
-	Device          string         `json:"device"`
-	Action          GoveeAction    `json:"action"`
-	SyncValue       LightSyncValue `json:"sync_value"`
-	BrightnessRange []int          `json:"brightness_range"`
+	Device          string              `json:"device"`
+	Action          GoveeAction         `json:"action"`
+	SyncValue       LightSyncValue      `json:"sync_value"`
+	BrightnessRange []int               `json:"brightness_range"`
+	Segments        []GoveeSegmentColor `json:"segments"`      // For "set segments"
+	Colors          []deviceColor       `json:"colors"`        // For "set gradient", from one end of the device to the other
+	SyncSegments    []int               `json:"sync_segments"` // For the "color" sync from Hue: only these segments follow the light
+	Scenes          []string            `json:"scenes"`        // For "apply scene": names in the Govee scenes file, applied in turn at each trigger
+	Value           any                 `json:"value"`         // For the brightness actions, like for WLED
 }
 
 type ConfigurationTwinklyAction struct {
@@ -88,6 +250,7 @@ type ConfigurationActionSwitchbotAction struct {
 	Action          SwitchbotAction `json:"action"`
 	SyncValue       LightSyncValue  `json:"sync_value"`
 	BrightnessRange []int           `json:"brightness_range"`
+	Value           any             `json:"value"` // For the brightness actions, like for WLED
 }
 
 type ConfigurationActionWledAction struct {
@@ -98,9 +261,135 @@ type ConfigurationActionWledAction struct {
 	Value           any            `json:"value"` // like for example "10" or for brightness increase, or "10" to set brightness exactly to 10
 }
 
+// ConfigurationActionGroupAction targets every member of a group: when the configuration is loaded
+// it gets expanded into the provider specific actions of each member.
+type ConfigurationActionGroupAction struct {
+	Group           string         `json:"group"`
+	Action          GroupAction    `json:"action"`
+	SyncValue       LightSyncValue `json:"sync_value"`
+	BrightnessRange []int          `json:"brightness_range"`
+	Value           any            `json:"value"`
+}
+
+type ConfigurationGroup struct {
+	Devices []string `json:"devices"` // Friendly device names, from any provider
+}
+
+type ConfigurationActionSceneAction struct {
+	Scene     string         `json:"scene"`
+	Action    SceneAction    `json:"action"`
+	SyncValue LightSyncValue `json:"sync_value"` // Only "on" and "off" are meaningful for scenes
+	Devices   []string       `json:"devices"`    // Devices or groups captured by "snapshot"; every registered device when empty
+}
+
+type ConfigurationScene struct {
+	Devices      map[string]DeviceState `json:"devices"` // Key is friendly device or group name
+	TransitionMs int                    `json:"transition_ms"`
+}
+
+// DeviceState describes the desired state of a device: nil fields are left untouched
+type DeviceState struct {
+	On         *bool        `json:"on,omitempty"`
+	Brightness *int         `json:"brightness,omitempty"` // 0-100
+	Color      *deviceColor `json:"color,omitempty"`
+	Effect     *int         `json:"effect,omitempty"` // WLED effect ID, ignored by other providers
+}
+
+func (s DeviceState) IsEmpty() bool {
+	return s.On == nil && s.Brightness == nil && s.Color == nil && s.Effect == nil
+}
+
 type GoveeMessage struct {
 	Device string
 	Data   []byte
+	// Transition, when set, replaces Data with a software-stepped fade (see Transitions)
+	Transition *Transition
+	// Cancels lists the running fades that this instant update must stop, so that their next steps do not undo it
+	Cancels []TransitionAttribute
+	// Razer tells whether the message needs the razer streaming mode on or off
+	Razer GoveeRazerMode
+}
+
+// GoveeRazerMode is the state of the razer streaming mode a message needs: while the mode is on,
+// the device ignores the other color commands
+type GoveeRazerMode int
+
+const (
+	GoveeRazerModeAny GoveeRazerMode = iota // On/off and brightness work either way
+	GoveeRazerModeOn                        // Razer packets, enabling the mode
+	GoveeRazerModeOff                       // The other color commands
+)
+
+func NewGoveeTurnMessage(device string, on bool) GoveeMessage {
+	value := 0
+	if on {
+		value = 1
+	}
+	return GoveeMessage{
+		Device:  device,
+		Cancels: []TransitionAttribute{TransitionAttributeBrightness, TransitionAttributeColor},
+		Data: mustMarshal(GoveeTurn{
+			Msg: GoveeTurnMsg{
+				Cmd: "turn",
+				Data: GoveeTurnMsgData{
+					Value: value,
+				},
+			},
+		}),
+	}
+}
+
+func NewGoveeBrightnessMessage(device string, brightness int) GoveeMessage {
+	return GoveeMessage{
+		Device:  device,
+		Cancels: []TransitionAttribute{TransitionAttributeBrightness},
+		Data: mustMarshal(GoveeBrightnessRequest{
+			Msg: GoveeBrightnessRequestMsg{
+				Cmd: "brightness",
+				Data: GoveeBrightnessRequestMsgData{
+					Value: brightness,
+				},
+			},
+		}),
+	}
+}
+
+func NewGoveeColorMessage(device string, r, g, b int) GoveeMessage {
+	return GoveeMessage{
+		Device:  device,
+		Cancels: []TransitionAttribute{TransitionAttributeColor},
+		Razer:   GoveeRazerModeOff,
+		Data: mustMarshal(GoveeColorRequest{
+			Msg: GoveeColorRequestMsg{
+				Cmd: "colorwc",
+				Data: GoveeColorRequestMsgData{
+					Color: GoveeColorRequestMsgDataColor{
+						R: r,
+						G: g,
+						B: b,
+					},
+				},
+			},
+		}),
+	}
+}
+
+func NewGoveeStatusRequestMessage(device string) GoveeMessage {
+	return GoveeMessage{
+		Device: device,
+		Data: mustMarshal(GoveeStatusRequest{
+			Msg: GoveeStatusRequestMsg{
+				Cmd:  "devStatus",
+				Data: GoveeStatusRequestMsgData{},
+			},
+		}),
+	}
+}
+
+// WithTransition attaches a transition to the message, when one is needed
+func (m GoveeMessage) WithTransition(transition *Transition) GoveeMessage {
+	m.Transition = transition
+	return m
 }
 
 type GoveeDeviceConfiguration struct {
@@ -112,6 +401,58 @@ type HueConfiguration struct {
 	Devices map[string]HueDeviceConfiguration `json:"devices"`
 }
 
+// EmulatedHueConfiguration exposes the non-Hue devices through a Hue-compatible bridge API.
+// Like a real bridge it pairs with anyone on the network, so it bypasses the HTTP API tokens:
+// it refuses to start when tokens are configured, unless AllowUnauthenticated is set.
+type EmulatedHueConfiguration struct {
+	Enabled              bool   `json:"enabled"`
+	IP                   string `json:"ip"`                    // Advertised address, detected when empty
+	Port                 int    `json:"port"`                  // Defaults to 80, the only port most Hue clients try
+	AllowUnauthenticated bool   `json:"allow_unauthenticated"` // Start even when the HTTP API requires tokens
+}
+
+// GoveeLANConfiguration chooses the network interfaces the Govee devices are scanned and listened to on
+type GoveeLANConfiguration struct {
+	Interfaces []string `json:"interfaces"` // Names (e.g. "eth0") or IPv4 addresses, every interface when empty
+}
+
+// MQTTConfiguration connects to a broker to publish the devices (with Home Assistant discovery) and accept commands
+type MQTTConfiguration struct {
+	Broker          string `json:"broker"` // E.g. "tcp://192.168.1.10:1883": MQTT is disabled when empty
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	ClientID        string `json:"client_id"`        // Defaults to the topic prefix
+	TopicPrefix     string `json:"topic_prefix"`     // Defaults to the app name
+	DiscoveryPrefix string `json:"discovery_prefix"` // Defaults to "homeassistant"
+}
+
+// HTTPConfiguration secures the REST API: without tokens every request is allowed
+type HTTPConfiguration struct {
+	Address      string                   `json:"address"` // Bind address, defaults to ":8080" (all interfaces)
+	Tokens       []HTTPTokenConfiguration `json:"tokens"`  // Not checked by the emulated Hue bridge (see EmulatedHueConfiguration)
+	TLS          HTTPTLSConfiguration     `json:"tls"`
+	AuditLogFile string                   `json:"audit_log_file"` // JSON lines of every control request, besides the application log
+}
+
+type HTTPTokenScope string
+
+const (
+	HTTPTokenScopeRead    HTTPTokenScope = "read"    // GET requests only, streams included
+	HTTPTokenScopeControl HTTPTokenScope = "control" // Every request
+)
+
+type HTTPTokenConfiguration struct {
+	Name  string         `json:"name"` // Who uses the token, as written in the audit log
+	Token string         `json:"token"`
+	Scope HTTPTokenScope `json:"scope"`
+}
+
+type HTTPTLSConfiguration struct {
+	Enabled  bool   `json:"enabled"`
+	CertFile string `json:"cert_file"` // Defaults to "tls/cert.pem": a self-signed certificate is generated when missing
+	KeyFile  string `json:"key_file"`  // Defaults to "tls/key.pem"
+}
+
 type HueBridgeDeviceConfiguration struct {
 	Username string `json:"username"`
 	IP       string `json:"ip"`
@@ -172,6 +513,13 @@ func (m SwitchbotMessage) SetBrightness(brightness int) SwitchbotMessage {
 	return m
 }
 
+func (m SwitchbotMessage) SetColor(r, g, b int) SwitchbotMessage {
+	m.Command = "setColor"
+	m.Parameter = fmt.Sprintf("%d:%d:%d", r, g, b)
+	m.CommandType = "command"
+	return m
+}
+
 type WledDeviceConfiguration struct {
 	Device string `json:"device"`
 	IP     string `json:"ip"`
@@ -208,3 +556,29 @@ func (m WledMessage) SetBrightness(brightness int) WledMessage {
 	m.Body = []byte(fmt.Sprintf(`{"bri":%d}`, brightness))
 	return m
 }
+
+// SetState merges on/brightness (0-100)/color/effect into a single WLED message,
+// letting the device fade natively when a transition is given
+func (m WledMessage) SetState(state DeviceState, transition time.Duration) WledMessage {
+	request := WledStateRequest{
+		On: state.On,
+	}
+	if transition > 0 {
+		request.Transition = valToPtr(wledTransition(transition))
+	}
+	if state.Brightness != nil {
+		brightness := mapBrightness(*state.Brightness, []int{0, 100}, []int{0, 255})
+		request.Bri = &brightness
+	}
+	if state.Color != nil || state.Effect != nil {
+		segment := WledSegmentRequest{
+			Fx: state.Effect,
+		}
+		if state.Color != nil {
+			segment.Col = [][]int{{state.Color.Red, state.Color.Green, state.Color.Blue}}
+		}
+		request.Seg = []WledSegmentRequest{segment}
+	}
+	m.Body = mustMarshal(request)
+	return m
+}
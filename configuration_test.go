@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGroupBrightnessActions(t *testing.T) {
+	configuration := Configuration{
+		Govee:     map[string]GoveeDeviceConfiguration{"group lamp": {MAC: "AA:BB"}},
+		Switchbot: map[string]SwitchbotDeviceConfiguration{"group bulb": {DeviceID: "bulb"}},
+		Groups:    map[string]ConfigurationGroup{"brightness group": {Devices: []string{"group lamp", "group bulb"}}},
+	}
+	for _, groupAction := range []ConfigurationActionGroupAction{
+		{Group: "brightness group", Action: GroupActionSetBrightness, Value: 30.0},
+		{Group: "brightness group", Action: GroupActionIncreaseBrightness, Value: 20.0},
+		{Group: "brightness group", Action: GroupActionDecreaseBrightness},
+	} {
+		configuration.Actions = append(configuration.Actions, ConfigurationAction{
+			Name:         string(groupAction.Action),
+			GroupActions: []ConfigurationActionGroupAction{groupAction},
+		})
+	}
+	configuration.expandGroupActions()
+
+	// Each action starts from the brightness set by the previous one
+	for i, expected := range []int{30, 50, 40} {
+		action := configuration.Actions[i]
+		goveeMessages, _, switchbotMessages, _ := configuration.GetMessagesToDispatchOnAction(action, nil)
+		if len(goveeMessages) != 1 || len(switchbotMessages) != 1 {
+			t.Fatalf("%s: expected a message for each member, got %v and %v", action.Name, goveeMessages, switchbotMessages)
+		}
+
+		var request GoveeBrightnessRequest
+		if err := json.Unmarshal(goveeMessages[0].Data, &request); err != nil {
+			t.Fatal(err)
+		}
+		if request.Msg.Cmd != "brightness" || request.Msg.Data.Value != expected {
+			t.Errorf("%s: expected Govee brightness %d, got %s", action.Name, expected, goveeMessages[0].Data)
+		}
+		if message := switchbotMessages[0]; message.Command != "setBrightness" || message.Parameter != strconv.Itoa(expected) {
+			t.Errorf("%s: expected Switchbot brightness %d, got %+v", action.Name, expected, message)
+		}
+	}
+}
+
+func TestConflictResolutionDevice(t *testing.T) {
+	wledActions := func(devices ...string) []ConfigurationActionWledAction {
+		var actions []ConfigurationActionWledAction
+		for _, device := range devices {
+			for _, syncValue := range []LightSyncValue{LightSyncValueOnOff, LightSyncValueBrightness} {
+				actions = append(actions, ConfigurationActionWledAction{Device: device, SyncValue: syncValue})
+			}
+		}
+		return actions
+	}
+	configuration := Configuration{
+		Wled: map[string]WledDeviceConfiguration{"conflict strip": {}, "conflict bulb": {}},
+		Actions: []ConfigurationAction{
+			{
+				Trigger: ActionTriggerHueLightSync, LightName: "Conflict desk", Direction: SyncDirectionBidirectional,
+				ConflictResolution: ConflictResolutionDevice, WledActions: wledActions("conflict strip", "conflict bulb"),
+			},
+			{
+				Trigger: ActionTriggerHueLightSync, LightName: "Conflict shelf", Direction: SyncDirectionBidirectional,
+				ConflictResolution: ConflictResolutionHue, WledActions: wledActions("conflict strip"),
+			},
+		},
+	}
+	Status.Register("conflict bulb", ProviderWled)
+	// The strip has just been turned on from its own app
+	Status.Observe("conflict strip", DeviceState{On: valToPtr(true)}, ChangeOriginDevice)
+	SyncGuard.RecordChange("conflict strip", ChangeOriginDevice)
+
+	devices := func(messages []WledMessage) []string {
+		var devices []string
+		for _, message := range messages {
+			devices = append(devices, message.Device)
+		}
+		return devices
+	}
+	now := time.Now()
+	for _, test := range []struct {
+		name     string
+		status   LightStatus
+		changes  LightChanges
+		expected []string
+	}{
+		{"turned off", LightStatus{lastUpdate: &now}, LightChanges{On: true}, []string{"conflict bulb"}},
+		{"turned on and dimmed", LightStatus{lastUpdate: &now, on: true, allOn: true, brightness: 30}, LightChanges{On: true, Brightness: true}, []string{"conflict bulb", "conflict bulb"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, _, wledMessages := configuration.GetMessagesToDispatchOnHueLightChange(HueSyncSource{Name: "Conflict desk"}, test.status, test.changes)
+			if sent := devices(wledMessages); !slices.Equal(sent, test.expected) {
+				t.Errorf("expected messages to %v, got %v", test.expected, sent)
+			}
+		})
+	}
+	if status, _ := Status.Get("conflict strip"); status.On != 1 || status.Brightness != -1 || status.Origin != ChangeOriginDevice {
+		t.Errorf("status of the yielding strip changed: %+v", status)
+	}
+
+	// A rule resolved in favour of Hue still writes to the strip, without hiding its change from the other rule
+	_, _, _, wledMessages := configuration.GetMessagesToDispatchOnHueLightChange(HueSyncSource{Name: "Conflict shelf"}, LightStatus{lastUpdate: &now}, LightChanges{On: true})
+	if sent := devices(wledMessages); !slices.Equal(sent, []string{"conflict strip"}) {
+		t.Errorf("expected a message to the strip, got %v", sent)
+	}
+	if !SyncGuard.ChangedRecentlyBy("conflict strip", ChangeOriginDevice) {
+		t.Errorf("change of the strip overwritten by our own write")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const viteDevServerURL = "http://localhost:5173"
+
+// dashboardFiles holds the dashboard built by Vite into dashboard/dist (see dashboard/README.md)
+//
+//go:embed all:dashboard
+var dashboardFiles embed.FS
+
+// newDashboardHandler serves the embedded dashboard. Unknown paths get index.html, so that the client side
+// routes survive a reload.
+func newDashboardHandler() http.Handler {
+	distFiles, err := fs.Sub(dashboardFiles, "dashboard/dist")
+	if err == nil {
+		_, err = fs.Stat(distFiles, "index.html")
+	}
+	if err != nil {
+		log.Warn().Msgf("Dashboard not built: run `pnpm build` before building the binary")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Dashboard not built: run `pnpm build` before building the binary, or start with -dev", http.StatusNotFound)
+		})
+	}
+
+	fileServer := http.FileServerFS(distFiles)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" {
+			name = "index.html"
+		}
+		if _, err := fs.Stat(distFiles, name); errors.Is(err, fs.ErrNotExist) {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/"
+		}
+		if strings.HasPrefix(name, "assets/") {
+			// Vite puts a content hash in the names of the assets
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// newDevServerProxy forwards the dashboard requests (hot reload WebSocket included) to the Vite dev server
+func newDevServerProxy(target string) (http.Handler, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing dev server URL: %w", err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, fmt.Sprintf("Vite dev server not reachable at %s: %s", target, err), http.StatusBadGateway)
+	}
+	return proxy, nil
+}
@@ -2,12 +2,70 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
 )
 
-func StartViteDevServer(ctx context.Context) error {
+// StartViteDevServer runs "pnpm dev" and copies its output to stdout and stderr until ctx is cancelled.
+// The whole process group is killed then, and the writers are closed once the output is copied.
+func StartViteDevServer(ctx context.Context, stdout, stderr io.WriteCloser) error {
+	defer stdout.Close()
+	defer stderr.Close()
+
+	cmd := exec.Command("/bin/sh", "-c", "pnpm dev")
+	// The shell and its children share a group, to be killed together
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	cmdStdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error getting stdout pipe: %w", err)
+	}
+	cmdStderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("error getting stderr pipe: %w", err)
+	}
+
+	log.Info().Msgf("Starting dev server...")
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting dev server: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(stdout, cmdStdout)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(stderr, cmdStderr)
+	}()
 
-	// cmd.Stdout = os.Stdout
-	// cmd.Stderr = os.Stderr
+	exited := make(chan error, 1)
+	go func() {
+		// The pipes must be fully read before waiting
+		wg.Wait()
+		exited <- cmd.Wait()
+	}()
 
-	return nil
+	select {
+	case err := <-exited:
+		if err != nil {
+			return fmt.Errorf("dev server exited: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		// Unblock the copies in case nobody reads the output anymore
+		stdout.Close()
+		stderr.Close()
+		<-exited
+		return nil
+	}
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DeviceStateReader reads the actual state of a device, which may have been changed from its own app or button
+type DeviceStateReader interface {
+	ReadState(ctx context.Context, device string) (DeviceState, error)
+}
+
+// deviceObservationIntervals is how often the devices synced to Hue get polled.
+// Switchbot goes through the cloud API, whose daily quota does not allow frequent polling.
+var deviceObservationIntervals = map[string]time.Duration{
+	ProviderGovee:     2 * time.Second,
+	ProviderWled:      2 * time.Second,
+	ProviderSwitchbot: 60 * time.Second,
+}
+
+const (
+	deviceBrightnessDeadBand = 1 // Percentage points
+	deviceColorDeadBand      = 2 // Per RGB channel
+)
+
+// DeviceObserver polls the devices of "device to hue" and "bidirectional" sync rules,
+// and writes their changes to the Hue lights and groups they are synced with
+type DeviceObserver struct {
+	readers map[string]DeviceStateReader // Key is provider
+	hue     *HueConnection
+}
+
+func NewDeviceObserver(
+	govee *GoveeConnection,
+	switchbot *SwitchbotConnection,
+	wled *WledConnection,
+	hue *HueConnection,
+) *DeviceObserver {
+	return &DeviceObserver{
+		readers: map[string]DeviceStateReader{
+			ProviderGovee:     govee,
+			ProviderSwitchbot: switchbot,
+			ProviderWled:      wled,
+		},
+		hue: hue,
+	}
+}
+
+// Start polls the devices until ctx is cancelled, then returns once every observation has stopped
+func (o *DeviceObserver) Start(ctx context.Context, configuration Configuration) {
+	var wg sync.WaitGroup
+	for device, provider := range configuration.GetDevicesSyncedToHue() {
+		reader, ok := o.readers[provider]
+		if !ok {
+			log.Warn().Msgf("Reading the state of %s devices is not supported: device [%s] cannot be synced to Hue", provider, device)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.observe(ctx, configuration, device, provider, reader)
+		}()
+	}
+	wg.Wait()
+}
+
+func (o *DeviceObserver) observe(ctx context.Context, configuration Configuration, device, provider string, reader DeviceStateReader) {
+	ticker := time.NewTicker(deviceObservationIntervals[provider])
+	defer ticker.Stop()
+
+	var observed *DeviceState
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		readCtx, cancel := context.WithTimeout(ctx, dispatchPolicies[provider].Timeout)
+		current, err := reader.ReadState(readCtx, device)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		Status.SetReachable(device, err == nil)
+		if err != nil {
+			log.Debug().Err(err).Msgf("Error reading state of device [%s]", device)
+			continue
+		}
+
+		// The first observation only sets the reference: at startup Hue drives the devices, not the other way around
+		if observed == nil {
+			observed = &current
+			Status.Observe(device, current, ChangeOriginDevice)
+			continue
+		}
+
+		changed := detectDeviceStateChanges(*observed, current)
+		if changed.IsEmpty() {
+			continue
+		}
+		*observed = mergeDeviceState(*observed, changed)
+
+		if SyncGuard.IsEcho(device, changed) {
+			log.Trace().Msgf("Ignoring echo of our own change on device [%s]", device)
+			continue
+		}
+		SyncGuard.RecordChange(device, ChangeOriginDevice)
+		Status.Observe(device, changed, ChangeOriginDevice)
+
+		for _, update := range configuration.GetHueStateUpdatesOnDeviceChange(device, changed) {
+			if err := o.hue.SetHueState(ctx, update.Source, update.State, update.Transition); err != nil {
+				log.Err(err).Msgf("error syncing device %s to Hue %s: %s", device, update.Source, err)
+			}
+		}
+	}
+}
+
+// detectDeviceStateChanges returns the attributes that changed beyond the dead-bands.
+// As for Hue lights, brightness and color changes are held back while the device is off.
+func detectDeviceStateChanges(previous, current DeviceState) DeviceState {
+	var changed DeviceState
+	if current.On != nil && (previous.On == nil || *previous.On != *current.On) {
+		changed.On = current.On
+	}
+	if current.On != nil && !*current.On {
+		return changed
+	}
+	if current.Brightness != nil && (previous.Brightness == nil ||
+		math.Abs(float64(*previous.Brightness-*current.Brightness)) > deviceBrightnessDeadBand) {
+		changed.Brightness = current.Brightness
+	}
+	if current.Color != nil && (previous.Color == nil ||
+		math.Abs(float64(previous.Color.Red-current.Color.Red)) > deviceColorDeadBand ||
+		math.Abs(float64(previous.Color.Green-current.Color.Green)) > deviceColorDeadBand ||
+		math.Abs(float64(previous.Color.Blue-current.Color.Blue)) > deviceColorDeadBand) {
+		changed.Color = current.Color
+	}
+	return changed
+}
+
+func mergeDeviceState(state, changed DeviceState) DeviceState {
+	if changed.On != nil {
+		state.On = changed.On
+	}
+	if changed.Brightness != nil {
+		state.Brightness = changed.Brightness
+	}
+	if changed.Color != nil {
+		state.Color = changed.Color
+	}
+	return state
+}
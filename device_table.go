@@ -0,0 +1,265 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// deviceTableColumn describes a column of the device table and how to sort the rows by it
+type deviceTableColumn struct {
+	Title   string
+	Width   int
+	Compare func(a, b deviceTableRow) int
+}
+
+type deviceTableRow struct {
+	Name   string
+	Status deviceStatus
+}
+
+var deviceTableColumns = []deviceTableColumn{
+	{Title: "Device", Width: 24, Compare: func(a, b deviceTableRow) int { return strings.Compare(a.Name, b.Name) }},
+	{Title: "Provider", Width: 10, Compare: func(a, b deviceTableRow) int { return strings.Compare(a.Status.Provider, b.Status.Provider) }},
+	{Title: "Reachable", Width: 11, Compare: func(a, b deviceTableRow) int { return cmp.Compare(a.Status.Reachable, b.Status.Reachable) }},
+	{Title: "Power", Width: 6, Compare: func(a, b deviceTableRow) int { return cmp.Compare(a.Status.On, b.Status.On) }},
+	{Title: "Brightness", Width: 17, Compare: func(a, b deviceTableRow) int { return cmp.Compare(a.Status.Brightness, b.Status.Brightness) }},
+	{Title: "Color", Width: 12, Compare: func(a, b deviceTableRow) int {
+		return cmp.Or(
+			cmp.Compare(a.Status.Color.Red, b.Status.Color.Red),
+			cmp.Compare(a.Status.Color.Green, b.Status.Color.Green),
+			cmp.Compare(a.Status.Color.Blue, b.Status.Color.Blue),
+		)
+	}},
+}
+
+var (
+	deviceTableHeaderStyle   = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	deviceTableSelectedStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("229")).Background(lipgloss.Color("57"))
+	deviceTableMutedStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+)
+
+// DeviceTableModel is a reusable component that displays the live status of every registered device.
+// It is rendered with lipgloss rather than bubbles/table, which truncates the cells without
+// taking the ANSI sequences of the color swatches into account.
+type DeviceTableModel struct {
+	rows     []deviceTableRow
+	cursor   int
+	offset   int // First row shown when the rows do not fit the height
+	sortBy   int // Index in deviceTableColumns
+	reversed bool
+
+	width  int
+	height int
+}
+
+// NewDeviceTableModel creates a new device table, loaded with the current status of the devices
+func NewDeviceTableModel() *DeviceTableModel {
+	m := &DeviceTableModel{}
+	m.Refresh()
+	return m
+}
+
+func (m *DeviceTableModel) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.scrollToCursor()
+}
+
+// Refresh reloads the rows from Status, keeping the cursor on the selected device
+func (m *DeviceTableModel) Refresh() {
+	selected, _ := m.Selected()
+
+	statuses := Status.GetAll()
+	m.rows = make([]deviceTableRow, 0, len(statuses))
+	for name, status := range statuses {
+		m.rows = append(m.rows, deviceTableRow{Name: name, Status: status})
+	}
+	m.sort()
+	m.selectDevice(selected)
+}
+
+// Selected returns the name of the device under the cursor
+func (m *DeviceTableModel) Selected() (string, bool) {
+	if m.cursor < 0 || m.cursor >= len(m.rows) {
+		return "", false
+	}
+	return m.rows[m.cursor].Name, true
+}
+
+func (m *DeviceTableModel) sort() {
+	column := deviceTableColumns[m.sortBy]
+	slices.SortStableFunc(m.rows, func(a, b deviceTableRow) int {
+		// The name breaks the ties, so that the order does not change at every refresh
+		result := cmp.Or(column.Compare(a, b), strings.Compare(a.Name, b.Name))
+		if m.reversed {
+			return -result
+		}
+		return result
+	})
+}
+
+func (m *DeviceTableModel) selectDevice(name string) {
+	m.cursor = max(0, min(m.cursor, len(m.rows)-1))
+	for i, row := range m.rows {
+		if row.Name == name {
+			m.cursor = i
+			break
+		}
+	}
+	m.scrollToCursor()
+}
+
+// visibleRows is the number of rows fitting the height, excluding header and footer
+func (m *DeviceTableModel) visibleRows() int {
+	if m.height <= 0 {
+		return len(m.rows)
+	}
+	return max(1, m.height-2)
+}
+
+func (m *DeviceTableModel) scrollToCursor() {
+	visible := m.visibleRows()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+visible {
+		m.offset = m.cursor - visible + 1
+	}
+	m.offset = max(0, min(m.offset, len(m.rows)-visible))
+}
+
+// Init implements tea.Model
+func (m *DeviceTableModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model
+func (m *DeviceTableModel) Update(msg tea.Msg) (*DeviceTableModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		selected, _ := m.Selected()
+		switch msg.String() {
+		case "up", "k":
+			m.cursor = max(0, m.cursor-1)
+		case "down", "j":
+			m.cursor = min(len(m.rows)-1, m.cursor+1)
+		case "home", "g":
+			m.cursor = 0
+		case "end", "G":
+			m.cursor = len(m.rows) - 1
+		case "s":
+			m.sortBy = (m.sortBy + 1) % len(deviceTableColumns)
+			m.sort()
+			m.selectDevice(selected)
+		case "S":
+			m.reversed = !m.reversed
+			m.sort()
+			m.selectDevice(selected)
+		}
+		m.scrollToCursor()
+	case tuiUpdateModel:
+		m.Refresh()
+	}
+	return m, nil
+}
+
+// View implements tea.Model
+func (m *DeviceTableModel) View() string {
+	var b strings.Builder
+
+	header := make([]string, len(deviceTableColumns))
+	for i, column := range deviceTableColumns {
+		title := column.Title
+		if i == m.sortBy {
+			arrow := "▲"
+			if m.reversed {
+				arrow = "▼"
+			}
+			title += " " + arrow
+		}
+		header[i] = deviceTableCell(deviceTableHeaderStyle, column.Width, title)
+	}
+	b.WriteString(strings.Join(header, " "))
+
+	if len(m.rows) == 0 {
+		b.WriteString("\n")
+		b.WriteString(deviceTableMutedStyle.Render("No devices configured"))
+	}
+
+	end := min(len(m.rows), m.offset+m.visibleRows())
+	for i := m.offset; i < end; i++ {
+		b.WriteString("\n")
+		b.WriteString(m.renderRow(m.rows[i], i == m.cursor))
+	}
+
+	b.WriteString("\n")
+	b.WriteString(deviceTableMutedStyle.Render(fmt.Sprintf(
+		"%d/%d · ↑/↓ select · s sort by %s · S reverse",
+		min(m.cursor+1, len(m.rows)), len(m.rows), strings.ToLower(deviceTableColumns[m.sortBy].Title))))
+
+	return b.String()
+}
+
+func (m *DeviceTableModel) renderRow(row deviceTableRow, selected bool) string {
+	base := lipgloss.NewStyle()
+	if selected {
+		base = deviceTableSelectedStyle
+	}
+	status := row.Status
+
+	reachable := deviceTableCell(base.Foreground(lipgloss.Color("241")), deviceTableColumns[2].Width, "? unknown")
+	switch status.Reachable {
+	case 1:
+		reachable = deviceTableCell(base.Foreground(lipgloss.Color("10")), deviceTableColumns[2].Width, "● yes")
+	case 0:
+		reachable = deviceTableCell(base.Foreground(lipgloss.Color("9")), deviceTableColumns[2].Width, "● no")
+	}
+
+	power := deviceTableCell(base.Foreground(lipgloss.Color("241")), deviceTableColumns[3].Width, "?")
+	switch status.On {
+	case 1:
+		power = deviceTableCell(base.Foreground(lipgloss.Color("10")), deviceTableColumns[3].Width, "on")
+	case 0:
+		power = deviceTableCell(base, deviceTableColumns[3].Width, "off")
+	}
+
+	brightness := deviceTableCell(base.Foreground(lipgloss.Color("241")), deviceTableColumns[4].Width, "?")
+	if status.Brightness >= 0 {
+		filled := (min(status.Brightness, 100) + 5) / 10
+		brightness = deviceTableCell(base, deviceTableColumns[4].Width,
+			fmt.Sprintf("%s%s %3d%%", strings.Repeat("█", filled), strings.Repeat("░", 10-filled), status.Brightness))
+	}
+
+	color := deviceTableCell(base.Foreground(lipgloss.Color("241")), deviceTableColumns[5].Width, "?")
+	if status.Color.Red >= 0 && status.Color.Green >= 0 && status.Color.Blue >= 0 {
+		hex := fmt.Sprintf("#%02x%02x%02x", status.Color.Red, status.Color.Green, status.Color.Blue)
+		color = lipgloss.NewStyle().Background(lipgloss.Color(hex)).Render("  ") +
+			deviceTableCell(base, deviceTableColumns[5].Width-2, " "+hex)
+	}
+
+	return strings.Join([]string{
+		deviceTableCell(base, deviceTableColumns[0].Width, row.Name),
+		deviceTableCell(base, deviceTableColumns[1].Width, status.Provider),
+		reachable,
+		power,
+		brightness,
+		color,
+	}, base.Render(" "))
+}
+
+// deviceTableCell pads or truncates the text to the width of the column
+func deviceTableCell(style lipgloss.Style, width int, text string) string {
+	if lipgloss.Width(text) > width {
+		runes := []rune(text)
+		for len(runes) > 0 && lipgloss.Width(string(runes))+1 > width {
+			runes = runes[:len(runes)-1]
+		}
+		text = string(runes) + "…"
+	}
+	return style.Width(width).Render(text)
+}
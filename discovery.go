@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/amimof/huego"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	mdnsAddress             = "224.0.0.251:5353"
+	wledMDNSService         = "_wled._tcp.local."
+	twinklyDiscoveryAddress = "255.255.255.255:5555"
+)
+
+type DiscoveredWledDevice struct {
+	Name string
+	IP   string
+}
+
+type DiscoveredTwinklyDevice struct {
+	Name string
+	IP   string
+}
+
+// readUDPUntilDone reads the datagrams received on conn until ctx is done, then closes conn
+func readUDPUntilDone(ctx context.Context, conn *net.UDPConn, handle func(data []byte, from *net.UDPAddr)) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buffer := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return fmt.Errorf("error reading UDP response: %w", err)
+		}
+		handle(buffer[:n], from)
+	}
+}
+
+// discoverGoveeDevices sends a scan request out of the interfaces and collects the answers until ctx is done.
+// The answers are sent to the port the synchronizer listens on, so both cannot run at the same time.
+func discoverGoveeDevices(ctx context.Context, interfaces []lanInterface) ([]GoveeScanResponseMsgData, error) {
+	listeners, err := listenGoveeResponses(interfaces)
+	if err != nil {
+		return nil, err
+	}
+	defer closeUDPConnections(listeners)
+
+	multicastConns, err := openMulticastConnections(interfaces)
+	if err != nil {
+		return nil, err
+	}
+	defer closeUDPConnections(multicastConns)
+
+	if err := sendScanRequests(multicastConns); err != nil {
+		return nil, err
+	}
+
+	var (
+		devices      []GoveeScanResponseMsgData
+		devicesMutex sync.Mutex
+		errs         = make([]error, len(listeners))
+		wg           sync.WaitGroup
+	)
+	for i, listener := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = readUDPUntilDone(ctx, listener, func(data []byte, _ *net.UDPAddr) {
+				var response GoveeScanResponse
+				if err := json.Unmarshal(data, &response); err != nil || response.Msg.Cmd != "scan" {
+					return
+				}
+				devicesMutex.Lock()
+				defer devicesMutex.Unlock()
+				// Devices may answer more than once, or on more than one interface
+				if !slices.ContainsFunc(devices, func(device GoveeScanResponseMsgData) bool { return device.Device == response.Msg.Data.Device }) {
+					devices = append(devices, response.Msg.Data)
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(devices, func(a, b GoveeScanResponseMsgData) int {
+		return strings.Compare(a.IP, b.IP)
+	})
+	return devices, errors.Join(errs...)
+}
+
+// discoverHueBridges asks the Philips discovery service for the bridges of the local network
+func discoverHueBridges(ctx context.Context) ([]huego.Bridge, error) {
+	bridges, err := huego.DiscoverAllContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering Hue bridges: %w", err)
+	}
+	return bridges, nil
+}
+
+// discoverWledDevices browses the WLED mDNS service. The query is sent from a random port,
+// so that the devices answer directly to it (RFC 6762, section 6.7).
+func discoverWledDevices(ctx context.Context) ([]DiscoveredWledDevice, error) {
+	service, err := dnsmessage.NewName(wledMDNSService)
+	if err != nil {
+		return nil, fmt.Errorf("error building mDNS query: %w", err)
+	}
+	query, err := (&dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, fmt.Errorf("error building mDNS query: %w", err)
+	}
+
+	address, err := net.ResolveUDPAddr("udp4", mdnsAddress)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving mDNS address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening mDNS socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(query, address); err != nil {
+		return nil, fmt.Errorf("error sending mDNS query: %w", err)
+	}
+
+	var devices []DiscoveredWledDevice
+	err = readUDPUntilDone(ctx, conn, func(data []byte, from *net.UDPAddr) {
+		var response dnsmessage.Message
+		if err := response.Unpack(data); err != nil {
+			return
+		}
+		// The answer holds the instance name, the additional records its address: fall back to the sender otherwise
+		ip := from.IP.String()
+		for _, resource := range response.Additionals {
+			if a, ok := resource.Body.(*dnsmessage.AResource); ok {
+				ip = net.IP(a.A[:]).String()
+			}
+		}
+		for _, resource := range slices.Concat(response.Answers, response.Additionals) {
+			ptr, ok := resource.Body.(*dnsmessage.PTRResource)
+			if !ok || !strings.EqualFold(resource.Header.Name.String(), wledMDNSService) {
+				continue
+			}
+			name := strings.TrimSuffix(ptr.PTR.String(), "."+wledMDNSService)
+			if !slices.ContainsFunc(devices, func(device DiscoveredWledDevice) bool { return device.IP == ip }) {
+				devices = append(devices, DiscoveredWledDevice{Name: name, IP: ip})
+			}
+		}
+	})
+	return devices, err
+}
+
+// discoverTwinklyDevices broadcasts the Twinkly discovery request. Each device answers with
+// its IP address (in reverse byte order), "OK" and its name.
+func discoverTwinklyDevices(ctx context.Context) ([]DiscoveredTwinklyDevice, error) {
+	address, err := net.ResolveUDPAddr("udp4", twinklyDiscoveryAddress)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving Twinkly discovery address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening Twinkly discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP([]byte("\x01discover"), address); err != nil {
+		return nil, fmt.Errorf("error sending Twinkly discovery request: %w", err)
+	}
+
+	var devices []DiscoveredTwinklyDevice
+	err = readUDPUntilDone(ctx, conn, func(data []byte, _ *net.UDPAddr) {
+		device, ok := parseTwinklyDiscoveryReply(data)
+		if ok && !slices.Contains(devices, device) {
+			devices = append(devices, device)
+		}
+	})
+	return devices, err
+}
+
+// parseTwinklyDiscoveryReply decodes the answer of a device, ignoring the other packets (e.g. our own request)
+func parseTwinklyDiscoveryReply(data []byte) (DiscoveredTwinklyDevice, bool) {
+	if len(data) < 6 || string(data[4:6]) != "OK" {
+		return DiscoveredTwinklyDevice{}, false
+	}
+	return DiscoveredTwinklyDevice{
+		IP:   net.IPv4(data[3], data[2], data[1], data[0]).String(),
+		Name: strings.TrimRight(string(data[6:]), "\x00"),
+	}, true
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestParseTwinklyDiscoveryReply(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		data     string
+		expected DiscoveredTwinklyDevice
+		ok       bool
+	}{
+		{"reply", "\x0a\x01\xa8\xc0OKTwinkly_33AB12\x00", DiscoveredTwinklyDevice{IP: "192.168.1.10", Name: "Twinkly_33AB12"}, true},
+		{"reply without terminator", "\x0a\x01\xa8\xc0OKTree", DiscoveredTwinklyDevice{IP: "192.168.1.10", Name: "Tree"}, true},
+		{"reply without name", "\x0a\x01\xa8\xc0OK", DiscoveredTwinklyDevice{IP: "192.168.1.10"}, true},
+		{"own request", "\x01discover", DiscoveredTwinklyDevice{}, false},
+		{"short packet", "\x0a\x01\xa8\xc0O", DiscoveredTwinklyDevice{}, false},
+		{"empty packet", "", DiscoveredTwinklyDevice{}, false},
+		{"not OK", "\x0a\x01\xa8\xc0KOTree", DiscoveredTwinklyDevice{}, false},
+	} {
+		device, ok := parseTwinklyDiscoveryReply([]byte(test.data))
+		if ok != test.ok || device != test.expected {
+			t.Errorf("%s: expected %+v %v, got %+v %v", test.name, test.expected, test.ok, device, ok)
+		}
+	}
+}
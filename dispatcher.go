@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+var ErrDispatcherClosed = errors.New("dispatcher closed")
+
+// HTTPStatusError is returned by HTTP based connections when the device (or the cloud API) answers with a non 2xx status
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.StatusCode)
+}
+
+func checkHTTPResponse(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &HTTPStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// isTransientError tells whether sending the message again may succeed
+func isTransientError(err error) bool {
+	if errors.Is(err, ErrDeviceNotFound) {
+		return false
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+type dispatchPolicy struct {
+	Timeout time.Duration // Of a single attempt
+	Retries int
+	Backoff time.Duration // Doubled after every failed attempt
+}
+
+var dispatchPolicies = map[string]dispatchPolicy{
+	ProviderGovee:     {Timeout: 500 * time.Millisecond, Retries: 1, Backoff: 50 * time.Millisecond},
+	ProviderWled:      {Timeout: 1 * time.Second, Retries: 2, Backoff: 200 * time.Millisecond},
+	ProviderSwitchbot: {Timeout: 5 * time.Second, Retries: 2, Backoff: 500 * time.Millisecond},
+	ProviderTwinkly:   {Timeout: 2 * time.Second, Retries: 2, Backoff: 250 * time.Millisecond},
+}
+
+type dispatchJob struct {
+	provider string
+	device   string
+	send     func(ctx context.Context) error
+	done     func(err error)
+}
+
+// Dispatcher sends the messages of an action to all the involved devices concurrently.
+// Every device has its own queue, so that messages to the same device keep their order
+// (across actions too) while a slow device does not hold back the others.
+// The queues outlive the contexts of the callers: they are only stopped by Drain.
+type Dispatcher struct {
+	govee     *GoveeConnection
+	twinkly   *TwinklyConnection
+	switchbot *SwitchbotConnection
+	wled      *WledConnection
+
+	queuesMutex struct{ sync.Mutex }
+	queues      map[string]chan dispatchJob // Key is provider + device name
+
+	razerMutex   struct{ sync.Mutex }
+	razerDevices map[string]bool // Govee devices left in the razer streaming mode
+
+	closedMutex struct{ sync.RWMutex }
+	closed      bool
+	workers     sync.WaitGroup // One per queue
+
+	ctx    context.Context // Lifetime of the sends: cancelled when the queues cannot be drained in time
+	cancel context.CancelFunc
+}
+
+func NewDispatcher(
+	govee *GoveeConnection,
+	twinkly *TwinklyConnection,
+	switchbot *SwitchbotConnection,
+	wled *WledConnection,
+) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		govee:        govee,
+		twinkly:      twinkly,
+		switchbot:    switchbot,
+		wled:         wled,
+		queues:       make(map[string]chan dispatchJob),
+		razerDevices: make(map[string]bool),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Drain stops accepting messages and waits for the queued ones to be sent. When ctx is done first,
+// the pending messages are given up and the error reports it.
+func (d *Dispatcher) Drain(ctx context.Context) error {
+	d.closedMutex.Lock()
+	if !d.closed {
+		d.closed = true
+		d.queuesMutex.Lock()
+		for _, queue := range d.queues {
+			close(queue)
+		}
+		d.queuesMutex.Unlock()
+	}
+	d.closedMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-drained
+		return fmt.Errorf("error draining the dispatch queues: %w", ctx.Err())
+	}
+}
+
+// Dispatch enqueues the messages and returns immediately: the outcome is logged and recorded in Dispatches
+// once every device has been served. ctx only bounds the wait for room in the queues.
+func (d *Dispatcher) Dispatch(
+	ctx context.Context,
+	name string,
+	goveeMessages []GoveeMessage,
+	twinklyMessages []TwinklyMessage,
+	switchbotMessages []SwitchbotMessage,
+	wledMessages []WledMessage,
+) {
+	var jobs []dispatchJob
+
+	for _, message := range goveeMessages {
+		jobs = append(jobs, dispatchJob{
+			provider: ProviderGovee,
+			device:   message.Device,
+			send: func(ctx context.Context) error {
+				return d.sendGoveeMessage(ctx, message)
+			},
+		})
+	}
+
+	for _, message := range twinklyMessages {
+		jobs = append(jobs, dispatchJob{
+			provider: ProviderTwinkly,
+			device:   ProviderTwinkly, // TODO: Add support to multiple devices
+			send: func(ctx context.Context) error {
+				return d.twinkly.SendMsg(ctx, message)
+			},
+		})
+	}
+
+	for _, message := range switchbotMessages {
+		jobs = append(jobs, dispatchJob{
+			provider: ProviderSwitchbot,
+			device:   message.Device,
+			send: func(ctx context.Context) error {
+				return d.switchbot.SendMsg(ctx, message)
+			},
+		})
+	}
+
+	for _, message := range wledMessages {
+		jobs = append(jobs, dispatchJob{
+			provider: ProviderWled,
+			device:   message.Device,
+			send: func(ctx context.Context) error {
+				return d.wled.SendMsg(ctx, message)
+			},
+		})
+	}
+
+	if len(jobs) == 0 {
+		return
+	}
+
+	result := &DispatchResult{
+		Name:      name,
+		StartedAt: time.Now(),
+		Failed:    make(map[string]string),
+	}
+	var resultMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, job := range jobs {
+		job.done = func(err error) {
+			defer wg.Done()
+			// A cancelled dispatch says nothing about the device
+			if !errors.Is(err, context.Canceled) && !errors.Is(err, ErrDispatcherClosed) {
+				Status.SetReachable(job.device, err == nil)
+			}
+			resultMutex.Lock()
+			defer resultMutex.Unlock()
+			if err != nil {
+				// The first error of a device is the most relevant: the following messages may fail because of it
+				if _, alreadyFailed := result.Failed[job.device]; !alreadyFailed {
+					result.Failed[job.device] = err.Error()
+				}
+				result.Succeeded = slices.DeleteFunc(result.Succeeded, func(device string) bool { return device == job.device })
+				return
+			}
+			if _, alreadyFailed := result.Failed[job.device]; !alreadyFailed && !slices.Contains(result.Succeeded, job.device) {
+				result.Succeeded = append(result.Succeeded, job.device)
+			}
+		}
+		wg.Add(1)
+		d.enqueue(ctx, job)
+	}
+
+	go func() {
+		wg.Wait()
+		result.Duration = time.Since(result.StartedAt)
+		if len(result.Failed) > 0 {
+			log.Warn().
+				Strs("succeeded", result.Succeeded).
+				Interface("failed", result.Failed).
+				Msgf("Action [%s] dispatched with %d failed devices in %s", name, len(result.Failed), result.Duration)
+		} else {
+			log.Debug().
+				Strs("succeeded", result.Succeeded).
+				Msgf("Action [%s] dispatched in %s", name, result.Duration)
+		}
+		Dispatches.Add(*result)
+	}()
+}
+
+// enqueue gives up when ctx is done before the queue has room for the job
+func (d *Dispatcher) enqueue(ctx context.Context, job dispatchJob) {
+	// Held until the job is queued, so that Drain does not close the queue in the meantime
+	d.closedMutex.RLock()
+	defer d.closedMutex.RUnlock()
+	if d.closed {
+		job.done(ErrDispatcherClosed)
+		return
+	}
+
+	key := job.provider + "/" + job.device
+
+	d.queuesMutex.Lock()
+	queue, ok := d.queues[key]
+	if !ok {
+		queue = make(chan dispatchJob, 100)
+		d.queues[key] = queue
+		d.workers.Add(1)
+		go d.serveQueue(queue)
+	}
+	d.queuesMutex.Unlock()
+
+	select {
+	case queue <- job:
+	case <-ctx.Done():
+		job.done(ctx.Err())
+	}
+}
+
+// serveQueue sends the jobs one at a time, until the queue is closed by Drain
+func (d *Dispatcher) serveQueue(queue <-chan dispatchJob) {
+	defer d.workers.Done()
+	for job := range queue {
+		job.done(d.send(d.ctx, job))
+	}
+}
+
+// send tries to deliver the message within the provider timeout, retrying transient failures with exponential backoff
+func (d *Dispatcher) send(ctx context.Context, job dispatchJob) error {
+	policy := dispatchPolicies[job.provider]
+	backoff := policy.Backoff
+
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, policy.Timeout)
+		err := job.send(attemptCtx)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+		if !isTransientError(err) || attempt >= policy.Retries {
+			return err
+		}
+
+		log.Debug().Err(err).Msgf("Retrying message to %s device [%s] in %s", job.provider, job.device, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// sendGoveeMessage sends the message right away, or starts its transition in background
+func (d *Dispatcher) sendGoveeMessage(ctx context.Context, message GoveeMessage) error {
+	if err := d.setGoveeRazerMode(ctx, message); err != nil {
+		return err
+	}
+
+	if message.Transition != nil {
+		// The transition outlives the attempt, so it is bound to the dispatcher instead: it stops when shutting down
+		Transitions.Run(d.ctx, message.Device, ProviderGovee, *message.Transition, func(step TransitionStep) error {
+			if step.Brightness != nil {
+				return d.govee.SendMsg(d.ctx, message.Device, NewGoveeBrightnessMessage(message.Device, *step.Brightness).Data)
+			}
+			return d.govee.SendMsg(d.ctx, message.Device, NewGoveeColorMessage(message.Device, step.Color.Red, step.Color.Green, step.Color.Blue).Data)
+		})
+		return nil
+	}
+
+	// An instant update must not be overwritten by the following steps of a running transition
+	for _, attribute := range message.Cancels {
+		Transitions.Cancel(message.Device, attribute)
+	}
+
+	return d.govee.SendMsg(ctx, message.Device, message.Data)
+}
+
+// setGoveeRazerMode leaves the razer streaming mode before a color command the device would ignore in it.
+// The messages to a device are sent in order by its queue, so the mode is the one of the last message sent.
+func (d *Dispatcher) setGoveeRazerMode(ctx context.Context, message GoveeMessage) error {
+	d.razerMutex.Lock()
+	defer d.razerMutex.Unlock()
+	switch message.Razer {
+	case GoveeRazerModeOn:
+		d.razerDevices[message.Device] = true
+	case GoveeRazerModeOff:
+		if !d.razerDevices[message.Device] {
+			return nil
+		}
+		if err := d.govee.SendMsg(ctx, message.Device, NewGoveeRazerDisableMessage(message.Device).Data); err != nil {
+			return fmt.Errorf("error leaving the razer mode: %w", err)
+		}
+		delete(d.razerDevices, message.Device)
+	}
+	return nil
+}
+
+type DispatchResult struct {
+	Name      string            `json:"name"`
+	StartedAt time.Time         `json:"started_at"`
+	Duration  time.Duration     `json:"duration"`
+	Succeeded []string          `json:"succeeded"`
+	Failed    map[string]string `json:"failed"` // Key is device name, value is the error
+}
+
+type dispatchHistory struct {
+	mtx     struct{ sync.RWMutex }
+	results []DispatchResult
+
+	subscribers      map[int]chan DispatchResult
+	nextSubscriberID int
+}
+
+const dispatchHistorySize = 50
+
+// Dispatches keeps the outcome of the most recent dispatched actions
+var Dispatches = &dispatchHistory{
+	subscribers: make(map[int]chan DispatchResult),
+}
+
+func (h *dispatchHistory) Add(result DispatchResult) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.results = append(h.results, result)
+	if len(h.results) > dispatchHistorySize {
+		h.results = h.results[len(h.results)-dispatchHistorySize:]
+	}
+	for _, subscriber := range h.subscribers {
+		select {
+		case subscriber <- result:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the channel receiving the results as they are added, and the function to call to stop receiving them.
+// Subscribers too slow to keep up lose results.
+func (h *dispatchHistory) Subscribe() (<-chan DispatchResult, func()) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	id := h.nextSubscriberID
+	h.nextSubscriberID++
+	subscriber := make(chan DispatchResult, 100)
+	h.subscribers[id] = subscriber
+	return subscriber, func() {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		delete(h.subscribers, id)
+	}
+}
+
+func (h *dispatchHistory) GetAll() []DispatchResult {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	return slices.Clone(h.results)
+}
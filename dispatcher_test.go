@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWledDevices serves the WLED devices of the test: each device answers with its own status codes in turn,
+// then with 200, and records the bodies it receives
+type testWledDevices struct {
+	mtx       struct{ sync.Mutex }
+	responses map[string][]int // Key is device name
+	received  map[string][]string
+	delay     time.Duration
+}
+
+func startTestWledDevices(t *testing.T, responses map[string][]int, devices ...string) (*testWledDevices, *WledConnection) {
+	t.Helper()
+	fake := &testWledDevices{responses: responses, received: make(map[string][]string)}
+
+	configuration := Configuration{Wled: make(map[string]WledDeviceConfiguration)}
+	for _, device := range devices {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			fake.mtx.Lock()
+			fake.received[device] = append(fake.received[device], string(body))
+			status := http.StatusOK
+			if pending := fake.responses[device]; len(pending) > 0 {
+				status, fake.responses[device] = pending[0], pending[1:]
+			}
+			delay := fake.delay
+			fake.mtx.Unlock()
+			time.Sleep(delay)
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(server.Close)
+		configuration.Wled[device] = WledDeviceConfiguration{Device: device, IP: strings.TrimPrefix(server.URL, "http://")}
+	}
+	return fake, NewWledConnection(configuration)
+}
+
+func (f *testWledDevices) Received(device string) []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return slices.Clone(f.received[device])
+}
+
+// waitDispatchResult returns the next result of the named action
+func waitDispatchResult(t *testing.T, results <-chan DispatchResult, name string) DispatchResult {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case result := <-results:
+			if result.Name == name {
+				return result
+			}
+		case <-timeout:
+			t.Fatalf("no dispatch result for %s", name)
+		}
+	}
+}
+
+func testWledMessages(device string, bodies ...string) []WledMessage {
+	var messages []WledMessage
+	for _, body := range bodies {
+		messages = append(messages, WledMessage{Device: device, Body: []byte(body)})
+	}
+	return messages
+}
+
+func TestDispatcherKeepsDeviceOrder(t *testing.T) {
+	fake, wled := startTestWledDevices(t, nil, "strip")
+	fake.delay = 10 * time.Millisecond
+	dispatcher := NewDispatcher(nil, nil, nil, wled)
+	results, unsubscribe := Dispatches.Subscribe()
+	defer unsubscribe()
+
+	// Messages of consecutive actions share the queue of the device
+	dispatcher.Dispatch(t.Context(), "order-1", nil, nil, nil, testWledMessages("strip", "1", "2", "3"))
+	dispatcher.Dispatch(t.Context(), "order-2", nil, nil, nil, testWledMessages("strip", "4", "5"))
+	waitDispatchResult(t, results, "order-2")
+
+	if received := fake.Received("strip"); !slices.Equal(received, []string{"1", "2", "3", "4", "5"}) {
+		t.Errorf("messages sent out of order: %v", received)
+	}
+}
+
+func TestDispatcherResult(t *testing.T) {
+	fake, wled := startTestWledDevices(t, map[string][]int{
+		"flaky":    {http.StatusServiceUnavailable},
+		"busy":     {http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests},
+		"rejected": {http.StatusBadRequest},
+	}, "ok", "flaky", "busy", "rejected")
+	dispatcher := NewDispatcher(nil, nil, nil, wled)
+	results, unsubscribe := Dispatches.Subscribe()
+	defer unsubscribe()
+
+	var messages []WledMessage
+	for _, device := range []string{"ok", "flaky", "busy", "rejected", "missing"} {
+		messages = append(messages, testWledMessages(device, "{}")...)
+	}
+	dispatcher.Dispatch(t.Context(), "result", nil, nil, nil, messages)
+	result := waitDispatchResult(t, results, "result")
+
+	slices.Sort(result.Succeeded)
+	if !slices.Equal(result.Succeeded, []string{"flaky", "ok"}) {
+		t.Errorf("unexpected succeeded devices: %v", result.Succeeded)
+	}
+	for _, device := range []string{"busy", "rejected", "missing"} {
+		if _, ok := result.Failed[device]; !ok {
+			t.Errorf("%s not reported as failed: %v", device, result.Failed)
+		}
+	}
+	if len(result.Failed) != 3 {
+		t.Errorf("unexpected failed devices: %v", result.Failed)
+	}
+
+	// Transient failures are retried up to the provider policy, the others are not
+	for device, attempts := range map[string]int{
+		"ok":       1,
+		"flaky":    2,
+		"busy":     dispatchPolicies[ProviderWled].Retries + 1,
+		"rejected": 1,
+	} {
+		if received := fake.Received(device); len(received) != attempts {
+			t.Errorf("%s: expected %d attempts, got %d", device, attempts, len(received))
+		}
+	}
+}
+
+func TestDispatcherDrain(t *testing.T) {
+	fake, wled := startTestWledDevices(t, nil, "strip")
+	fake.delay = 20 * time.Millisecond
+	dispatcher := NewDispatcher(nil, nil, nil, wled)
+	results, unsubscribe := Dispatches.Subscribe()
+	defer unsubscribe()
+
+	dispatcher.Dispatch(t.Context(), "queued", nil, nil, nil, testWledMessages("strip", "1", "2", "3"))
+	if err := dispatcher.Drain(t.Context()); err != nil {
+		t.Fatalf("draining: %s", err)
+	}
+	// The queued messages are sent before Drain returns
+	if received := fake.Received("strip"); len(received) != 3 {
+		t.Errorf("expected the queued messages to be sent, got %v", received)
+	}
+	waitDispatchResult(t, results, "queued")
+
+	dispatcher.Dispatch(t.Context(), "closed", nil, nil, nil, testWledMessages("strip", "4"))
+	result := waitDispatchResult(t, results, "closed")
+	if result.Failed["strip"] != ErrDispatcherClosed.Error() {
+		t.Errorf("expected the dispatch to be refused, got %v", result.Failed)
+	}
+	if received := fake.Received("strip"); len(received) != 3 {
+		t.Errorf("message sent after draining: %v", received)
+	}
+}
+
+func TestDispatcherDrainTimeout(t *testing.T) {
+	fake, wled := startTestWledDevices(t, nil, "strip")
+	fake.delay = 200 * time.Millisecond
+	dispatcher := NewDispatcher(nil, nil, nil, wled)
+
+	dispatcher.Dispatch(t.Context(), "slow", nil, nil, nil, testWledMessages("strip", "1", "2", "3", "4", "5"))
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if err := dispatcher.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the drain to time out, got %v", err)
+	}
+	// The pending messages are given up
+	if received := fake.Received("strip"); len(received) == 5 {
+		t.Errorf("expected the pending messages to be given up, got %v", received)
+	}
+}
+
+func TestDispatcherLeavesGoveeRazerMode(t *testing.T) {
+	govee := NewGoveeConnection(Configuration{})
+	sent := make(chan []byte, 16)
+	govee.goveeDevices["strip"] = &FoundGoveeDevice{Device: "strip", channelOpen: true, sendChan: sent}
+	dispatcher := NewDispatcher(govee, nil, nil, nil)
+	results, unsubscribe := Dispatches.Subscribe()
+	defer unsubscribe()
+
+	gradient, err := NewGoveeGradientMessages("strip", []deviceColor{{Red: 255}, {Blue: 255}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	brightness := NewGoveeBrightnessMessage("strip", 50)
+	red := NewGoveeColorMessage("strip", 255, 0, 0)
+	blue := NewGoveeColorMessage("strip", 0, 0, 255)
+	dispatcher.Dispatch(t.Context(), "razer", append(gradient, brightness, red, blue), nil, nil, nil)
+	if result := waitDispatchResult(t, results, "razer"); len(result.Failed) > 0 {
+		t.Fatalf("dispatch failed: %v", result.Failed)
+	}
+
+	// Brightness works in the razer mode, the color only once it is disabled
+	expected := [][]byte{gradient[0].Data, gradient[1].Data, brightness.Data, NewGoveeRazerDisableMessage("strip").Data, red.Data, blue.Data}
+	var packets [][]byte
+	for len(packets) < len(expected) {
+		select {
+		case data := <-sent:
+			packets = append(packets, data)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d packets, got %d", len(expected), len(packets))
+		}
+	}
+	for i := range expected {
+		if string(packets[i]) != string(expected[i]) {
+			t.Errorf("packet %d: expected %s, got %s", i, expected[i], packets[i])
+		}
+	}
+
+	var disable GoveeRazer
+	if err := json.Unmarshal(expected[3], &disable); err != nil {
+		t.Fatal(err)
+	}
+	// The enable packet with the flag cleared
+	if disable.Msg.Cmd != "razer" || disable.Msg.Data.Pt != "uwABsQAL" {
+		t.Errorf("unexpected disable message: %s", expected[3])
+	}
+}
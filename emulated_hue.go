@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	emulatedHueDefaultPort = 80
+	ssdpPort               = 1900
+	ssdpNotifyInterval     = 60 * time.Second
+)
+
+// ssdpSearchTargets are the M-SEARCH targets Hue clients use to look for a bridge
+var ssdpSearchTargets = []string{"ssdp:all", "upnp:rootdevice", "urn:schemas-upnp-org:device:basic:1"}
+
+// EmulatedHueBridge serves a subset of the Hue API v1 (enough for Hue apps and voice assistants supporting
+// third-party bridges) backed by the device registry: every non-Hue device is exposed as a Hue light.
+// Pairing always succeeds, as if the link button were pressed, and any username is accepted,
+// so that clients keep working across restarts.
+type EmulatedHueBridge struct {
+	configuration Configuration
+	dispatcher    *Dispatcher
+
+	ip       string
+	port     int
+	mac      string // Lowercase hex, no separators
+	bridgeID string
+
+	lights map[string]string // Key is the light ID, value is the device name
+
+	usersMutex struct{ sync.RWMutex }
+	users      map[string]string // Key is username, value is the device type reported when pairing
+
+	ctx context.Context // Lifetime of the bridge, used for dispatching: requests contexts end too early
+}
+
+// ErrEmulatedHueUnauthenticated is returned when the emulated bridge would bypass the configured API tokens
+var ErrEmulatedHueUnauthenticated = errors.New("the emulated Hue bridge does not check the HTTP API tokens")
+
+func NewEmulatedHueBridge(configuration Configuration, dispatcher *Dispatcher) (*EmulatedHueBridge, error) {
+	if len(configuration.HTTP.Tokens) > 0 {
+		if !configuration.EmulatedHue.AllowUnauthenticated {
+			return nil, fmt.Errorf("%w: set emulated_hue.allow_unauthenticated to start it anyway", ErrEmulatedHueUnauthenticated)
+		}
+		log.Warn().Msgf("The emulated Hue bridge does not check the HTTP API tokens: anyone on the network can control the devices through it")
+	}
+
+	ip := configuration.EmulatedHue.IP
+	if ip == "" {
+		detectedIP, err := getOutboundIP()
+		if err != nil {
+			return nil, fmt.Errorf("error detecting the address to advertise: %w", err)
+		}
+		ip = detectedIP
+	}
+
+	port := configuration.EmulatedHue.Port
+	if port == 0 {
+		port = emulatedHueDefaultPort
+	}
+
+	mac := getInterfaceMAC(ip)
+
+	return &EmulatedHueBridge{
+		configuration: configuration,
+		dispatcher:    dispatcher,
+		ip:            ip,
+		port:          port,
+		mac:           mac,
+		bridgeID:      strings.ToUpper(mac[:6] + "fffe" + mac[6:]),
+		lights:        newEmulatedHueLightIDs(configuration),
+		users:         make(map[string]string),
+	}, nil
+}
+
+// Start advertises the bridge through SSDP and serves the API until the context is cancelled
+func (b *EmulatedHueBridge) Start(ctx context.Context) error {
+	b.ctx = ctx
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := b.serveSSDP(ctx); err != nil {
+			log.Err(err).Msgf("Emulated Hue bridge SSDP error: %s", err)
+		}
+	}()
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", b.port),
+		Handler: b.newMux(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Info().Msgf("Emulated Hue bridge %s listening on http://%s:%d", b.bridgeID, b.ip, b.port)
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("error serving emulated Hue bridge on port %d (ports below 1024 may require elevated privileges): %w", b.port, err)
+	}
+	return nil
+}
+
+func (b *EmulatedHueBridge) newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /description.xml", b.handleDescription)
+	mux.HandleFunc("POST /api", b.handleCreateUser)
+	mux.HandleFunc("POST /api/{$}", b.handleCreateUser)
+	mux.HandleFunc("GET /api/config", b.handleConfig)
+	mux.HandleFunc("GET /api/{user}", b.handleFullState)
+	mux.HandleFunc("GET /api/{user}/config", b.handleConfig)
+	mux.HandleFunc("GET /api/{user}/lights", b.handleLights)
+	mux.HandleFunc("GET /api/{user}/lights/{id}", b.handleLight)
+	mux.HandleFunc("PUT /api/{user}/lights/{id}/state", b.handleSetLightState)
+	mux.HandleFunc("GET /api/{user}/groups", b.handleEmptyResource)
+	mux.HandleFunc("GET /api/{user}/scenes", b.handleEmptyResource)
+	mux.HandleFunc("GET /api/{user}/sensors", b.handleEmptyResource)
+
+	return mux
+}
+
+func (b *EmulatedHueBridge) handleDescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, emulatedHueDescriptionTemplate, b.ip, b.port, b.configuration.AppName, b.ip, b.mac, b.mac)
+}
+
+func (b *EmulatedHueBridge) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var request EmulatedHueCreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeEmulatedHueJSON(w, []EmulatedHueError{newEmulatedHueError(2, "/", "body contains invalid json")})
+		return
+	}
+
+	username, err := generateEmulatedHueUsername()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b.usersMutex.Lock()
+	b.users[username] = request.DeviceType
+	b.usersMutex.Unlock()
+
+	log.Info().Msgf("Emulated Hue bridge paired with [%s]", request.DeviceType)
+
+	writeEmulatedHueJSON(w, []EmulatedHueSuccess{{Success: map[string]any{"username": username}}})
+}
+
+func (b *EmulatedHueBridge) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeEmulatedHueJSON(w, b.getConfig())
+}
+
+func (b *EmulatedHueBridge) handleFullState(w http.ResponseWriter, r *http.Request) {
+	empty := map[string]any{}
+	writeEmulatedHueJSON(w, EmulatedHueFullState{
+		Lights:        b.getLights(),
+		Groups:        empty,
+		Config:        b.getConfig(),
+		Schedules:     empty,
+		Scenes:        empty,
+		Rules:         empty,
+		Sensors:       empty,
+		ResourceLinks: empty,
+	})
+}
+
+func (b *EmulatedHueBridge) handleLights(w http.ResponseWriter, r *http.Request) {
+	writeEmulatedHueJSON(w, b.getLights())
+}
+
+func (b *EmulatedHueBridge) handleLight(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	light, ok := b.getLights()[id]
+	if !ok {
+		writeEmulatedHueJSON(w, []EmulatedHueError{newEmulatedHueError(3, "/lights/"+id, fmt.Sprintf("resource, /lights/%s, not available", id))})
+		return
+	}
+	writeEmulatedHueJSON(w, light)
+}
+
+func (b *EmulatedHueBridge) handleEmptyResource(w http.ResponseWriter, r *http.Request) {
+	writeEmulatedHueJSON(w, map[string]any{})
+}
+
+func (b *EmulatedHueBridge) handleSetLightState(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	device, ok := b.lights[id]
+	if !ok {
+		writeEmulatedHueJSON(w, []EmulatedHueError{newEmulatedHueError(3, "/lights/"+id, fmt.Sprintf("resource, /lights/%s, not available", id))})
+		return
+	}
+
+	var request EmulatedHueLightStateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeEmulatedHueJSON(w, []EmulatedHueError{newEmulatedHueError(2, "/lights/"+id+"/state", "body contains invalid json")})
+		return
+	}
+
+	provider, _ := b.configuration.GetDeviceProvider(device)
+	colorSupported := provider != ProviderTwinkly
+
+	var state DeviceState
+	var results []EmulatedHueSuccess
+	addResult := func(attribute string, value any) {
+		results = append(results, EmulatedHueSuccess{Success: map[string]any{fmt.Sprintf("/lights/%s/state/%s", id, attribute): value}})
+	}
+
+	if request.On != nil {
+		state.On = request.On
+		addResult("on", *request.On)
+	}
+	if request.Bri != nil && colorSupported {
+		state.Brightness = valToPtr(mapBrightness(*request.Bri, []int{0, 254}, []int{0, 100}))
+		addResult("bri", *request.Bri)
+	}
+	if request.Xy != nil && len(*request.Xy) == 2 && colorSupported {
+		red, green, blue := xyToRGB((*request.Xy)[0], (*request.Xy)[1], 255)
+		state.Color = &deviceColor{Red: int(red), Green: int(green), Blue: int(blue)}
+		addResult("xy", *request.Xy)
+	} else if request.Ct != nil && *request.Ct > 0 && colorSupported {
+		red, green, blue := kelvinToRGB(1_000_000 / *request.Ct)
+		state.Color = &deviceColor{Red: int(red), Green: int(green), Blue: int(blue)}
+		addResult("ct", *request.Ct)
+	}
+
+	var transition time.Duration
+	if request.TransitionTime != nil {
+		transition = time.Duration(*request.TransitionTime) * 100 * time.Millisecond
+		addResult("transitiontime", *request.TransitionTime)
+	}
+
+	goveeMessages, twinklyMessages, switchbotMessages, wledMessages := b.configuration.GetMessagesToApplyDeviceState(device, state, transition)
+	if !state.IsEmpty() && len(goveeMessages)+len(twinklyMessages)+len(switchbotMessages)+len(wledMessages) == 0 {
+		writeEmulatedHueJSON(w, []EmulatedHueError{newEmulatedHueError(901, "/lights/"+id+"/state", fmt.Sprintf("Internal error, %s cannot be set to the requested state", device))})
+		return
+	}
+	b.dispatcher.Dispatch(b.ctx, fmt.Sprintf("emulated hue light %s", device), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+
+	writeEmulatedHueJSON(w, results)
+}
+
+func (b *EmulatedHueBridge) getConfig() EmulatedHueConfig {
+	b.usersMutex.RLock()
+	whitelist := make(map[string]EmulatedHueWhitelistEntry, len(b.users))
+	for username, deviceType := range b.users {
+		whitelist[username] = EmulatedHueWhitelistEntry{Name: deviceType}
+	}
+	b.usersMutex.RUnlock()
+
+	var macParts []string
+	for i := 0; i < len(b.mac); i += 2 {
+		macParts = append(macParts, b.mac[i:i+2])
+	}
+
+	return EmulatedHueConfig{
+		Name:             b.configuration.AppName,
+		DatastoreVersion: "98",
+		SwVersion:        "1941132080",
+		APIVersion:       "1.41.0",
+		Mac:              strings.Join(macParts, ":"),
+		BridgeID:         b.bridgeID,
+		ModelID:          "BSB002",
+		IPAddress:        b.ip,
+		LinkButton:       true,
+		Whitelist:        whitelist,
+	}
+}
+
+// newEmulatedHueLightIDs assigns an ID to each configured device, derived from its name:
+// clients remember the lights by ID, which must not change when devices are added or removed
+func newEmulatedHueLightIDs(configuration Configuration) map[string]string {
+	var devices []string
+	for _, aliases := range [][]string{
+		configuration.GetAllGoveeDeviceAliases(),
+		configuration.GetAllSwitchbotDeviceAliases(),
+		configuration.GetAllWledDeviceAliases(),
+		configuration.GetAllTwinklyDeviceAliases(),
+	} {
+		devices = append(devices, aliases...)
+	}
+	slices.Sort(devices)
+
+	ids := make(map[string]string, len(devices))
+	for _, device := range devices {
+		hash := fnv.New32a()
+		hash.Write([]byte(device))
+		id := hash.Sum32() & 0x7fffffff // Some clients parse the ID as a signed 32 bits integer
+		// On the unlikely collision, the first device in alphabetical order keeps the ID
+		for {
+			key := strconv.FormatUint(uint64(id), 10)
+			if _, taken := ids[key]; !taken {
+				ids[key] = device
+				break
+			}
+			id = (id + 1) & 0x7fffffff
+		}
+	}
+	return ids
+}
+
+func (b *EmulatedHueBridge) getLights() map[string]EmulatedHueLight {
+	lights := make(map[string]EmulatedHueLight, len(b.lights))
+	for id, device := range b.lights {
+		status, ok := Status.Get(device)
+		if !ok {
+			status = getDefaultDeviceStatus()
+			status.Provider, _ = b.configuration.GetDeviceProvider(device)
+		}
+		lights[id] = newEmulatedHueLight(device, status)
+	}
+	return lights
+}
+
+func newEmulatedHueLight(device string, status deviceStatus) EmulatedHueLight {
+	hash := fnv.New32a()
+	hash.Write([]byte(device))
+	sum := hash.Sum32()
+
+	light := EmulatedHueLight{
+		Name:             device,
+		ManufacturerName: "Signify Netherlands B.V.",
+		UniqueID:         fmt.Sprintf("00:17:88:01:00:%02x:%02x:%02x-0b", byte(sum>>16), byte(sum>>8), byte(sum)),
+		SwVersion:        "1.50.2_r30933",
+		State: EmulatedHueLightState{
+			On:        status.On == 1,
+			Alert:     "none",
+			Mode:      "homeautomation",
+			Reachable: true,
+		},
+	}
+
+	// Twinkly only supports on/off for now
+	if status.Provider == ProviderTwinkly {
+		light.Type = "On/Off plug-in unit"
+		light.ModelID = "LOM001"
+		light.ProductName = "Hue Smart plug"
+		return light
+	}
+
+	light.Type = "Extended color light"
+	light.ModelID = "LCT015"
+	light.ProductName = "Hue color lamp"
+
+	bri := 254
+	if status.Brightness >= 0 {
+		bri = max(mapBrightness(status.Brightness, []int{0, 100}, []int{0, 254}), 1)
+	}
+	x, y := 0.3127, 0.3290 // White point, until the color is known
+	if status.Color.Red >= 0 && status.Color.Green >= 0 && status.Color.Blue >= 0 {
+		x, y = rgbToXY(uint8(status.Color.Red), uint8(status.Color.Green), uint8(status.Color.Blue))
+	}
+
+	light.State.Bri = &bri
+	light.State.Xy = []float64{x, y}
+	light.State.Ct = valToPtr(366)
+	light.State.Effect = "none"
+	light.State.ColorMode = "xy"
+	return light
+}
+
+// serveSSDP answers the M-SEARCH requests of the clients looking for a bridge, and periodically announces it
+func (b *EmulatedHueBridge) serveSSDP(ctx context.Context) error {
+	ssdpAddr := &net.UDPAddr{IP: net.ParseIP(multicastAddress), Port: ssdpPort}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, ssdpAddr)
+	if err != nil {
+		return fmt.Errorf("error listening to SSDP multicast: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(ssdpNotifyInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := conn.WriteToUDP([]byte(b.ssdpMessage("NOTIFY * HTTP/1.1", "upnp:rootdevice", "NTS: ssdp:alive\r\nHOST: 239.255.255.250:1900\r\n")), ssdpAddr); err != nil && ctx.Err() == nil {
+				log.Err(err).Msgf("error sending SSDP notification: %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	buffer := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Err(err).Msgf("Error reading SSDP request: %s", err)
+			continue
+		}
+
+		request := string(buffer[:n])
+		if !strings.HasPrefix(request, "M-SEARCH") {
+			continue
+		}
+
+		searchTarget := ""
+		for _, line := range strings.Split(request, "\r\n") {
+			if name, value, found := strings.Cut(line, ":"); found && strings.EqualFold(strings.TrimSpace(name), "ST") {
+				searchTarget = strings.TrimSpace(value)
+			}
+		}
+		if !slices.Contains(ssdpSearchTargets, strings.ToLower(searchTarget)) {
+			continue
+		}
+
+		log.Trace().Msgf("Answering SSDP search for [%s] from %s", searchTarget, from)
+
+		responseTarget := searchTarget
+		if responseTarget == "ssdp:all" {
+			responseTarget = "upnp:rootdevice"
+		}
+		if _, err := conn.WriteToUDP([]byte(b.ssdpMessage("HTTP/1.1 200 OK", responseTarget, "EXT:\r\n")), from); err != nil {
+			log.Err(err).Msgf("error answering SSDP search: %s", err)
+		}
+	}
+}
+
+func (b *EmulatedHueBridge) ssdpMessage(startLine, target, extraHeaders string) string {
+	targetHeader := "ST"
+	if strings.HasPrefix(startLine, "NOTIFY") {
+		targetHeader = "NT"
+	}
+	return startLine + "\r\n" +
+		"CACHE-CONTROL: max-age=100\r\n" +
+		extraHeaders +
+		fmt.Sprintf("LOCATION: http://%s:%d/description.xml\r\n", b.ip, b.port) +
+		"SERVER: Linux/3.14.0 UPnP/1.0 IpBridge/1.41.0\r\n" +
+		fmt.Sprintf("hue-bridgeid: %s\r\n", b.bridgeID) +
+		fmt.Sprintf("%s: %s\r\n", targetHeader, target) +
+		fmt.Sprintf("USN: uuid:2f402f80-da50-11e1-9b23-%s::%s\r\n", b.mac, target) +
+		"\r\n"
+}
+
+func writeEmulatedHueJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+func newEmulatedHueError(errorType int, address, description string) EmulatedHueError {
+	return EmulatedHueError{Error: EmulatedHueErrorBody{Type: errorType, Address: address, Description: description}}
+}
+
+func generateEmulatedHueUsername() (string, error) {
+	buffer := make([]byte, 20)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", fmt.Errorf("error generating username: %w", err)
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// getOutboundIP returns the local address used to reach the LAN: no packet is actually sent
+func getOutboundIP() (string, error) {
+	conn, err := net.Dial("udp4", "8.8.8.8:80")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// getInterfaceMAC returns the MAC of the interface holding the address, or one derived from the address
+// (locally administered) when it cannot be found
+func getInterfaceMAC(ip string) string {
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.String() == ip && len(iface.HardwareAddr) == 6 {
+				return hex.EncodeToString(iface.HardwareAddr)
+			}
+		}
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(ip))
+	sum := hash.Sum64()
+	return fmt.Sprintf("02%010x", sum&0xffffffffff)
+}
+
+const emulatedHueDescriptionTemplate = `<?xml version="1.0" encoding="UTF-8" ?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion>
+<major>1</major>
+<minor>0</minor>
+</specVersion>
+<URLBase>http://%s:%d/</URLBase>
+<device>
+<deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
+<friendlyName>%s (%s)</friendlyName>
+<manufacturer>Signify</manufacturer>
+<manufacturerURL>http://www.philips-hue.com</manufacturerURL>
+<modelDescription>Philips hue Personal Wireless Lighting</modelDescription>
+<modelName>Philips hue bridge 2015</modelName>
+<modelNumber>BSB002</modelNumber>
+<modelURL>http://www.philips-hue.com</modelURL>
+<serialNumber>%s</serialNumber>
+<UDN>uuid:2f402f80-da50-11e1-9b23-%s</UDN>
+<presentationURL>index.html</presentationURL>
+</device>
+</root>
+`
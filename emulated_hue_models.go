@@ -0,0 +1,84 @@
+package main
+
+// Hue API v1 models, as served by the emulated bridge
+
+type EmulatedHueLight struct {
+	State            EmulatedHueLightState `json:"state"`
+	Type             string                `json:"type"`
+	Name             string                `json:"name"`
+	ModelID          string                `json:"modelid"`
+	ManufacturerName string                `json:"manufacturername"`
+	ProductName      string                `json:"productname"`
+	UniqueID         string                `json:"uniqueid"`
+	SwVersion        string                `json:"swversion"`
+}
+
+type EmulatedHueLightState struct {
+	On        bool      `json:"on"`
+	Bri       *int      `json:"bri,omitempty"`
+	Xy        []float64 `json:"xy,omitempty"`
+	Ct        *int      `json:"ct,omitempty"`
+	Effect    string    `json:"effect,omitempty"`
+	Alert     string    `json:"alert"`
+	ColorMode string    `json:"colormode,omitempty"`
+	Mode      string    `json:"mode"`
+	Reachable bool      `json:"reachable"`
+}
+
+// EmulatedHueLightStateRequest is the body of PUT /api/<user>/lights/<id>/state: omitted fields are left untouched
+type EmulatedHueLightStateRequest struct {
+	On             *bool      `json:"on"`
+	Bri            *int       `json:"bri"`
+	Xy             *[]float64 `json:"xy"`
+	Ct             *int       `json:"ct"`
+	TransitionTime *int       `json:"transitiontime"` // Tenths of a second
+}
+
+type EmulatedHueConfig struct {
+	Name             string                               `json:"name"`
+	DatastoreVersion string                               `json:"datastoreversion"`
+	SwVersion        string                               `json:"swversion"`
+	APIVersion       string                               `json:"apiversion"`
+	Mac              string                               `json:"mac"`
+	BridgeID         string                               `json:"bridgeid"`
+	FactoryNew       bool                                 `json:"factorynew"`
+	ReplacesBridgeID *string                              `json:"replacesbridgeid"`
+	ModelID          string                               `json:"modelid"`
+	StarterKitID     string                               `json:"starterkitid"`
+	IPAddress        string                               `json:"ipaddress,omitempty"`
+	LinkButton       bool                                 `json:"linkbutton"`
+	Whitelist        map[string]EmulatedHueWhitelistEntry `json:"whitelist,omitempty"`
+}
+
+type EmulatedHueWhitelistEntry struct {
+	Name string `json:"name"`
+}
+
+type EmulatedHueFullState struct {
+	Lights        map[string]EmulatedHueLight `json:"lights"`
+	Groups        map[string]any              `json:"groups"`
+	Config        EmulatedHueConfig           `json:"config"`
+	Schedules     map[string]any              `json:"schedules"`
+	Scenes        map[string]any              `json:"scenes"`
+	Rules         map[string]any              `json:"rules"`
+	Sensors       map[string]any              `json:"sensors"`
+	ResourceLinks map[string]any              `json:"resourcelinks"`
+}
+
+type EmulatedHueCreateUserRequest struct {
+	DeviceType string `json:"devicetype"`
+}
+
+type EmulatedHueSuccess struct {
+	Success map[string]any `json:"success"`
+}
+
+type EmulatedHueError struct {
+	Error EmulatedHueErrorBody `json:"error"`
+}
+
+type EmulatedHueErrorBody struct {
+	Type        int    `json:"type"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestEmulatedHueBridge exposes the WLED devices "hue strip" and "hue bulb" and the Twinkly "hue tree"
+func newTestEmulatedHueBridge(t *testing.T) (*EmulatedHueBridge, *httptest.Server, *testWledDevices) {
+	t.Helper()
+	fake, wled := startTestWledDevices(t, nil, "hue strip", "hue bulb")
+	configuration := Configuration{
+		Wled:        wled.devices,
+		Twinkly:     map[string]TwinklyDeviceConfiguration{"hue tree": {}},
+		EmulatedHue: EmulatedHueConfiguration{IP: "127.0.0.1"},
+	}
+	Status.Register("hue strip", ProviderWled)
+	Status.Register("hue unconfigured", ProviderWled)
+
+	bridge, err := NewEmulatedHueBridge(configuration, NewDispatcher(nil, nil, nil, wled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge.ctx = t.Context()
+	server := httptest.NewServer(bridge.newMux())
+	t.Cleanup(server.Close)
+	return bridge, server, fake
+}
+
+func testEmulatedHueRequest(t *testing.T, server *httptest.Server, method, path, body string, response any) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatalf("%s %s: invalid JSON response: %s", method, path, err)
+	}
+}
+
+func TestEmulatedHueLightIDs(t *testing.T) {
+	bridge, server, _ := newTestEmulatedHueBridge(t)
+
+	var lights map[string]EmulatedHueLight
+	testEmulatedHueRequest(t, server, "GET", "/api/user/lights", "", &lights)
+	var names []string
+	for id, light := range lights {
+		if bridge.lights[id] != light.Name {
+			t.Errorf("light %s served as %s, mapped to %s", id, light.Name, bridge.lights[id])
+		}
+		names = append(names, light.Name)
+	}
+	// Only the configured devices, whether their status is known yet or not
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"hue bulb", "hue strip", "hue tree"}) {
+		t.Errorf("unexpected lights: %v", names)
+	}
+
+	// Adding a device keeps the IDs of the others
+	configuration := bridge.configuration
+	configuration.Govee = map[string]GoveeDeviceConfiguration{"hue added": {MAC: "AA:BB"}}
+	ids := newEmulatedHueLightIDs(configuration)
+	if len(ids) != 4 {
+		t.Fatalf("expected 4 lights, got %v", ids)
+	}
+	for id, device := range bridge.lights {
+		if ids[id] != device {
+			t.Errorf("ID %s of %s reassigned to %s", id, device, ids[id])
+		}
+	}
+}
+
+func TestEmulatedHueSetLightState(t *testing.T) {
+	bridge, server, fake := newTestEmulatedHueBridge(t)
+	results, unsubscribe := Dispatches.Subscribe()
+	defer unsubscribe()
+	stripID := bridge.lightID(t, "hue strip")
+
+	x, y := rgbToXY(255, 0, 0)
+	body, _ := json.Marshal(map[string]any{"on": true, "bri": 127, "xy": []float64{x, y}})
+	var response []EmulatedHueSuccess
+	testEmulatedHueRequest(t, server, "PUT", "/api/user/lights/"+stripID+"/state", string(body), &response)
+	if len(response) != 3 {
+		t.Fatalf("expected a success for each attribute, got %+v", response)
+	}
+	if result := waitDispatchResult(t, results, "emulated hue light hue strip"); len(result.Failed) > 0 {
+		t.Fatalf("dispatch failed: %v", result.Failed)
+	}
+
+	// bri goes from 0-254 to the 0-100 of the registry, then to the 0-255 of WLED
+	received := fake.Received("hue strip")
+	if len(received) != 1 {
+		t.Fatalf("expected a single request to the strip, got %v", received)
+	}
+	var request WledStateRequest
+	if err := json.Unmarshal([]byte(received[0]), &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Bri == nil || *request.Bri != 128 {
+		t.Errorf("expected bri 128, got %s", received[0])
+	}
+	if len(request.Seg) != 1 || len(request.Seg[0].Col) != 1 {
+		t.Fatalf("expected a color, got %s", received[0])
+	}
+	for i, expected := range []int{255, 0, 0} {
+		if math.Abs(float64(request.Seg[0].Col[0][i]-expected)) > echoColorTolerance {
+			t.Errorf("expected red, got %s", received[0])
+			break
+		}
+	}
+	if status, _ := Status.Get("hue strip"); status.Brightness != 50 {
+		t.Errorf("expected brightness 50 in the registry, got %d", status.Brightness)
+	}
+}
+
+func TestEmulatedHueSetLightStateErrors(t *testing.T) {
+	bridge, server, _ := newTestEmulatedHueBridge(t)
+	// A light whose device is no longer in the configuration
+	bridge.lights["1"] = "hue removed"
+
+	for _, test := range []struct {
+		id, body  string
+		errorType int
+	}{
+		{"999", `{"on": true}`, 3},
+		{bridge.lightID(t, "hue strip"), `{"on": tru`, 2},
+		{"1", `{"on": true}`, 901},
+	} {
+		var response []EmulatedHueError
+		testEmulatedHueRequest(t, server, "PUT", "/api/user/lights/"+test.id+"/state", test.body, &response)
+		if len(response) != 1 || response[0].Error.Type != test.errorType {
+			t.Errorf("light %s %s: expected error type %d, got %+v", test.id, test.body, test.errorType, response)
+		}
+	}
+}
+
+func (b *EmulatedHueBridge) lightID(t *testing.T, device string) string {
+	t.Helper()
+	for id, name := range b.lights {
+		if name == device {
+			return id
+		}
+	}
+	t.Fatalf("no light for %s", device)
+	return ""
+}
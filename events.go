@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventTypeButtonPressed   EventType = "button pressed"
+	EventTypeDialRotated     EventType = "dial rotated"
+	EventTypePresenceChanged EventType = "presence changed"
+	EventTypeSceneRecalled   EventType = "scene recalled"
+	EventTypeLightChanged    EventType = "light changed"
+)
+
+// Event is something that happened on the Hue side, as reported to the external interfaces (MQTT, HTTP)
+type Event struct {
+	Type   EventType `json:"type"`
+	Source string    `json:"source"` // Dial, sensor, light or group name
+	Time   time.Time `json:"time"`
+	Data   any       `json:"data,omitempty"`
+}
+
+type eventBus struct {
+	mtx              struct{ sync.Mutex }
+	subscribers      map[int]chan Event
+	nextSubscriberID int
+}
+
+var Events = &eventBus{
+	subscribers: make(map[int]chan Event),
+}
+
+// Publish never blocks: subscribers too slow to keep up lose events
+func (b *eventBus) Publish(eventType EventType, source string, data any) {
+	event := Event{
+		Type:   eventType,
+		Source: source,
+		Time:   time.Now(),
+		Data:   data,
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for _, subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the channel receiving the events, and the function to call to stop receiving them
+func (b *eventBus) Subscribe() (<-chan Event, func()) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	id := b.nextSubscriberID
+	b.nextSubscriberID++
+	subscriber := make(chan Event, 100)
+	b.subscribers[id] = subscriber
+	return subscriber, func() {
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+		delete(b.subscribers, id)
+	}
+}
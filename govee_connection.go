@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,7 +14,7 @@ import (
 )
 
 type GoveeCommandSender interface {
-	SendMsg(device string, data []byte) error
+	SendMsg(ctx context.Context, device string, data []byte) error
 }
 
 type GoveeConnection struct {
@@ -23,9 +25,15 @@ type GoveeConnection struct {
 
 	goveeDevicesStatus      map[string]*GoveeDeviceStatus
 	goveeDevicesStatusMutex struct{ sync.Mutex }
+
+	unconfiguredDevices      map[string]*UnconfiguredGoveeDevice // Key is the MAC address
+	unconfiguredDevicesMutex struct{ sync.Mutex }
+
+	workers sync.WaitGroup // UDP reader and senders to the devices, waited for by Start
 }
 
 var _ GoveeCommandSender = (*GoveeConnection)(nil)
+var _ DeviceStateReader = (*GoveeConnection)(nil)
 
 func NewGoveeConnection(
 	configuration Configuration,
@@ -34,48 +42,196 @@ func NewGoveeConnection(
 	goveeDevicesOfInterest := make(map[string]*FoundGoveeDevice)
 
 	return &GoveeConnection{
-		goveeDevices:       goveeDevicesOfInterest,
-		goveeDevicesStatus: make(map[string]*GoveeDeviceStatus),
-		configuration:      configuration,
+		goveeDevices:        goveeDevicesOfInterest,
+		goveeDevicesStatus:  make(map[string]*GoveeDeviceStatus),
+		unconfiguredDevices: make(map[string]*UnconfiguredGoveeDevice),
+		configuration:       configuration,
 	}
 }
 
-func (c *GoveeConnection) SendMsg(device string, data []byte) error {
+// SendMsg enqueues the message for the device without blocking: ctx is only checked beforehand
+func (c *GoveeConnection) SendMsg(ctx context.Context, device string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	c.goveeDevicesOfInterestMutex.Lock()
 	defer c.goveeDevicesOfInterestMutex.Unlock()
 	deviceRegistered, ok := c.goveeDevices[device]
 	if !ok || deviceRegistered == nil {
-		return fmt.Errorf("device not found")
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, device)
 	}
 	return deviceRegistered.Send(data)
 }
 
-func (c *GoveeConnection) Start(ctx context.Context) error {
-	resp := make(chan GoveeGenericResponse, 20)
+func (c *GoveeConnection) getDeviceAliasByIP(ip string) (string, bool) {
+	c.goveeDevicesOfInterestMutex.Lock()
+	defer c.goveeDevicesOfInterestMutex.Unlock()
+	for alias, device := range c.goveeDevices {
+		if device != nil && device.IP == ip {
+			return alias, true
+		}
+	}
+	return "", false
+}
 
-	serverAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", listenPort))
-	if err != nil {
-		return fmt.Errorf("error resolving server UDP address: %w", err)
+// GetUnconfiguredDevices returns the devices that answered the scan but are not in the configuration
+func (c *GoveeConnection) GetUnconfiguredDevices() []UnconfiguredGoveeDevice {
+	c.unconfiguredDevicesMutex.Lock()
+	defer c.unconfiguredDevicesMutex.Unlock()
+	devices := make([]UnconfiguredGoveeDevice, 0, len(c.unconfiguredDevices))
+	for _, device := range c.unconfiguredDevices {
+		devices = append(devices, *device)
 	}
+	slices.SortFunc(devices, func(a, b UnconfiguredGoveeDevice) int {
+		return strings.Compare(a.MAC, b.MAC)
+	})
+	return devices
+}
 
-	serverConn, err := net.ListenUDP("udp", serverAddr)
-	if err != nil {
-		return fmt.Errorf("error starting UDP server: %w", err)
+func (c *GoveeConnection) trackUnconfiguredDevice(data GoveeScanResponseMsgData) {
+	c.unconfiguredDevicesMutex.Lock()
+	defer c.unconfiguredDevicesMutex.Unlock()
+	now := time.Now()
+	// Keyed in uppercase, so that the MAC can be given in any case when adopting the device
+	device, ok := c.unconfiguredDevices[strings.ToUpper(data.Device)]
+	if !ok {
+		// Scans are periodic: log only the first time
+		log.Info().Msgf("Found unconfigured Govee device [%s - %s - %s]: adopt it to control it", data.SKU, data.IP, data.Device)
+		device = &UnconfiguredGoveeDevice{MAC: data.Device, FirstSeen: now}
+		c.unconfiguredDevices[strings.ToUpper(data.Device)] = device
+	}
+	device.SKU = data.SKU
+	device.IP = data.IP
+	device.BleVersionHard = data.BleVersionHard
+	device.BleVersionSoft = data.BleVersionSoft
+	device.WifiVersionHard = data.WifiVersionHard
+	device.WifiVersionSoft = data.WifiVersionSoft
+	device.LastSeen = now
+}
+
+// AdoptDevice adds an unconfigured device to configuration.json under the given alias.
+// The running configuration does not change: the device can be controlled after a restart.
+func (c *GoveeConnection) AdoptDevice(mac, alias string) (UnconfiguredGoveeDevice, error) {
+	alias = strings.TrimSpace(alias)
+	if alias == "" {
+		return UnconfiguredGoveeDevice{}, fmt.Errorf("the alias cannot be empty")
+	}
+
+	c.unconfiguredDevicesMutex.Lock()
+	defer c.unconfiguredDevicesMutex.Unlock()
+	device, ok := c.unconfiguredDevices[strings.ToUpper(mac)]
+	if !ok {
+		return UnconfiguredGoveeDevice{}, fmt.Errorf("%w: no unconfigured Govee device with MAC %s", ErrDeviceNotFound, mac)
+	}
+	if device.AdoptedAs != "" {
+		return *device, fmt.Errorf("%w: %s was already adopted as %q", ErrAlreadyConfigured, mac, device.AdoptedAs)
+	}
+	if _, ok := c.configuration.GetDeviceProvider(alias); ok {
+		return *device, fmt.Errorf("%w: a device is named %q", ErrAlreadyConfigured, alias)
+	}
+	if _, ok := c.configuration.Groups[alias]; ok {
+		return *device, fmt.Errorf("%w: a group is named %q", ErrAlreadyConfigured, alias)
+	}
+	for _, other := range c.unconfiguredDevices {
+		if other.AdoptedAs == alias {
+			return *device, fmt.Errorf("%w: %s was already adopted as %q", ErrAlreadyConfigured, other.MAC, alias)
+		}
+	}
+
+	// Written as reported by the device, like the configured MACs are compared
+	if err := AddGoveeDeviceToConfigurationFile(alias, device.MAC); err != nil {
+		return *device, err
+	}
+	device.AdoptedAs = alias
+	log.Info().Msgf("Adopted Govee device [%s - %s - %s] as %q: restart to control it", device.SKU, device.IP, device.MAC, alias)
+	return *device, nil
+}
+
+// waitForDevice sends scan requests until the device answers and its connection is open
+func (c *GoveeConnection) waitForDevice(ctx context.Context, multicastConns []*net.UDPConn, device string) error {
+	for {
+		if err := sendScanRequests(multicastConns); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("device %s did not answer the scan: %w", device, ctx.Err())
+		case <-time.After(time.Second):
+		}
+
+		c.goveeDevicesOfInterestMutex.Lock()
+		found, ok := c.goveeDevices[device]
+		c.goveeDevicesOfInterestMutex.Unlock()
+		if ok && found != nil {
+			found.connMutex.Lock()
+			open := found.channelOpen
+			found.connMutex.Unlock()
+			if open {
+				return nil
+			}
+		}
+	}
+}
+
+// ReadState asks the device for its status and waits for the answer, which comes through the UDP listener
+func (c *GoveeConnection) ReadState(ctx context.Context, device string) (DeviceState, error) {
+	requestedAt := time.Now()
+	if err := c.SendMsg(ctx, device, NewGoveeStatusRequestMessage(device).Data); err != nil {
+		return DeviceState{}, err
+	}
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		c.goveeDevicesStatusMutex.Lock()
+		status, ok := c.goveeDevicesStatus[device]
+		c.goveeDevicesStatusMutex.Unlock()
+		if ok && status.UpdatedAt.After(requestedAt) {
+			return DeviceState{
+				On:         valToPtr(status.On),
+				Brightness: valToPtr(int(status.Brightness)),
+				Color:      valToPtr(status.Color),
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return DeviceState{}, fmt.Errorf("no status received from %s: %w", device, ctx.Err())
+		case <-ticker.C:
+		}
 	}
+}
+
+// Start listens to the devices on the given sockets until ctx is cancelled, then closes them.
+// The connections to the devices are closed too, after sending the messages still buffered,
+// and Start returns once they all are.
+func (c *GoveeConnection) Start(ctx context.Context, listeners []*net.UDPConn) {
+	resp := make(chan goveeReceivedMessage, 20)
+
+	defer c.workers.Wait()
 
+	c.workers.Add(1)
 	go func() {
-		// Buffer to hold received data
-		buffer := make([]byte, 1024)
-
-		// Infinite loop to listen for responses
-		for {
-			select {
-			case <-ctx.Done():
-				serverConn.Close()
-				return
-			default:
-				n, _, err := serverConn.ReadFromUDP(buffer)
+		defer c.workers.Done()
+		// Closing the sockets is the only way to interrupt ReadFromUDP
+		<-ctx.Done()
+		closeUDPConnections(listeners)
+	}()
+
+	for _, serverConn := range listeners {
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			// Buffer to hold received data
+			buffer := make([]byte, 1024)
+
+			// Infinite loop to listen for responses
+			for {
+				n, from, err := serverConn.ReadFromUDP(buffer)
 				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
 					log.Err(err).Msgf("Error reading UDP response: %s", err)
 					continue
 				}
@@ -88,39 +244,54 @@ func (c *GoveeConnection) Start(ctx context.Context) error {
 					continue
 				}
 
-				resp <- response
+				select {
+				case resp <- goveeReceivedMessage{response: response, ip: from.IP.String()}:
+				case <-ctx.Done():
+					return
+				}
 			}
-
-		}
-	}()
+		}()
+	}
 
 	c.listenToUDPMessages(ctx, resp)
+}
 
-	return nil
+// goveeReceivedMessage carries the sender address too: devStatus responses do not tell which device they come from
+type goveeReceivedMessage struct {
+	response GoveeGenericResponse
+	ip       string
 }
 
-func (c *GoveeConnection) listenToUDPMessages(ctx context.Context, receiveFromGovee <-chan GoveeGenericResponse) {
+func (c *GoveeConnection) listenToUDPMessages(ctx context.Context, receiveFromGovee <-chan goveeReceivedMessage) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case response, ok := <-receiveFromGovee:
+		case message, ok := <-receiveFromGovee:
 			if !ok {
 				return
 			}
+			response := message.response
 			switch response.Msg.Cmd {
 			case "scan":
-				data := response.Msg.Data.(map[string]interface{})
-				ip := data["ip"].(string)
-				device := data["device"].(string)
-				sku := data["sku"].(string)
+				rawData, err := json.Marshal(response.Msg.Data)
+				if err != nil {
+					continue
+				}
+				var data GoveeScanResponseMsgData
+				if err := json.Unmarshal(rawData, &data); err != nil {
+					log.Err(err).Msgf("Error decoding Govee scan response: %s", err)
+					continue
+				}
+				ip, device, sku := data.IP, data.Device, data.SKU
 
 				alias, found := c.configuration.GetGoveeDeviceAliasByMAC(device)
 				if !found {
-					log.Info().Msgf("Ignoring unregistered Govee device [%s - %s - %s]", sku, ip, device)
-					c.goveeDevicesOfInterestMutex.Unlock()
+					c.trackUnconfiguredDevice(data)
 					continue
 				}
+				Status.SetReachable(alias, true)
+				Status.SetModel(alias, sku)
 
 				c.goveeDevicesOfInterestMutex.Lock()
 				previousGoveeDeviceRegistered, ok := c.goveeDevices[alias]
@@ -159,30 +330,42 @@ func (c *GoveeConnection) listenToUDPMessages(ctx context.Context, receiveFromGo
 					c.goveeDevices[alias] = deviceRegistered
 
 					// Try to dial the device
-					go tryDialGoveeDevice(ctx, deviceRegistered)
+					c.dialDevice(ctx, deviceRegistered)
 				}
 				c.goveeDevicesOfInterestMutex.Unlock()
 			case "devStatus":
-				data := response.Msg.Data.(map[string]float64)
-				brightness := data["brightness"]
-				on := data["onOff"] == 1
+				alias, found := c.getDeviceAliasByIP(message.ip)
+				if !found {
+					continue
+				}
+				Status.SetReachable(alias, true)
+
+				rawData, err := json.Marshal(response.Msg.Data)
+				if err != nil {
+					continue
+				}
+				var data GoveeDevStatusResponseMsgData
+				if err := json.Unmarshal(rawData, &data); err != nil {
+					log.Err(err).Msgf("Error decoding Govee device status: %s", err)
+					continue
+				}
 
 				// Update the status
 				c.goveeDevicesStatusMutex.Lock()
-				status, ok := c.goveeDevicesStatus[response.Msg.Cmd]
-				if !ok {
-					status = &GoveeDeviceStatus{}
+				c.goveeDevicesStatus[alias] = &GoveeDeviceStatus{
+					Brightness: float64(data.Brightness),
+					On:         data.OnOff == 1,
+					Color:      deviceColor{Red: data.Color.R, Green: data.Color.G, Blue: data.Color.B},
+					UpdatedAt:  time.Now(),
 				}
-				status.Brightness = brightness
-				status.On = on
-				c.goveeDevicesStatus[response.Msg.Cmd] = status
 				c.goveeDevicesStatusMutex.Unlock()
 			}
 		}
 	}
 }
 
-func tryDialGoveeDevice(ctx context.Context, device *FoundGoveeDevice) {
+// dialDevice opens the connection to the device and starts forwarding it the messages
+func (c *GoveeConnection) dialDevice(ctx context.Context, device *FoundGoveeDevice) {
 	device.connMutex.Lock()
 	defer device.connMutex.Unlock()
 
@@ -201,13 +384,18 @@ func tryDialGoveeDevice(ctx context.Context, device *FoundGoveeDevice) {
 	device.conn = conn
 	device.channelOpen = true
 
-	go startSendingMessages(ctx, device)
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		startSendingMessages(ctx, device)
+	}()
 }
 
 func startSendingMessages(ctx context.Context, device *FoundGoveeDevice) {
 	for {
 		select {
 		case <-ctx.Done():
+			closeGoveeDevice(device)
 			return
 		case data, ok := <-device.sendChan:
 			if !ok {
@@ -235,3 +423,28 @@ func startSendingMessages(ctx context.Context, device *FoundGoveeDevice) {
 		}
 	}
 }
+
+// closeGoveeDevice sends the messages still buffered, then closes the connection: Send fails from now on
+func closeGoveeDevice(device *FoundGoveeDevice) {
+	device.connMutex.Lock()
+	defer device.connMutex.Unlock()
+	if !device.channelOpen {
+		return
+	}
+
+	for {
+		select {
+		case data := <-device.sendChan:
+			if _, err := device.conn.Write(data); err != nil {
+				err = fmt.Errorf("error writing to connection: %w", err)
+				log.Err(err).Msgf("Error flushing message to Govee device [%s - %s - %s]", device.SKU, device.IP, device.Device)
+			}
+		default:
+			device.channelOpen = false
+			if err := device.conn.Close(); err != nil {
+				log.Err(err).Msgf("Error closing connection to Govee device [%s - %s - %s]: %s", device.SKU, device.IP, device.Device, err)
+			}
+			return
+		}
+	}
+}
@@ -18,9 +18,25 @@ type FoundGoveeDevice struct {
 	sendChan     chan []byte
 }
 
+// UnconfiguredGoveeDevice answered the scan request but is not listed in the configuration
+type UnconfiguredGoveeDevice struct {
+	MAC             string    `json:"mac"`
+	SKU             string    `json:"sku"`
+	IP              string    `json:"ip"`
+	BleVersionHard  string    `json:"ble_version_hard"`
+	BleVersionSoft  string    `json:"ble_version_soft"`
+	WifiVersionHard string    `json:"wifi_version_hard"`
+	WifiVersionSoft string    `json:"wifi_version_soft"`
+	FirstSeen       time.Time `json:"first_seen"`
+	LastSeen        time.Time `json:"last_seen"`
+	AdoptedAs       string    `json:"adopted_as,omitempty"` // Added to configuration.json, controllable after a restart
+}
+
 type GoveeDeviceStatus struct {
 	Brightness float64
 	On         bool
+	Color      deviceColor
+	UpdatedAt  time.Time
 }
 
 func (d *FoundGoveeDevice) Send(data []byte) error {
@@ -29,6 +45,11 @@ func (d *FoundGoveeDevice) Send(data []byte) error {
 	if !d.channelOpen {
 		return fmt.Errorf("channel not open")
 	}
-	d.sendChan <- data
-	return nil
+	// Never block while holding the lock: the sending goroutine needs it to drain the channel
+	select {
+	case d.sendChan <- data:
+		return nil
+	default:
+		return fmt.Errorf("send queue full")
+	}
 }
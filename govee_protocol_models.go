@@ -66,6 +66,13 @@ type GoveeStatusRequestMsg struct {
 type GoveeStatusRequestMsgData struct {
 }
 
+type GoveeDevStatusResponseMsgData struct {
+	OnOff            int                           `json:"onOff"`
+	Brightness       int                           `json:"brightness"`
+	Color            GoveeColorRequestMsgDataColor `json:"color"`
+	ColorTemInKelvin int                           `json:"colorTemInKelvin"`
+}
+
 type GoveeBrightnessRequest struct {
 	Msg GoveeBrightnessRequestMsg `json:"msg"`
 }
@@ -98,3 +105,31 @@ type GoveeColorRequestMsgDataColor struct {
 	G int `json:"g"`
 	B int `json:"b"`
 }
+
+// GoveePtReal carries raw BLE packets, encoded in base64, that the device runs as if sent over Bluetooth
+type GoveePtReal struct {
+	Msg GoveePtRealMsg `json:"msg"`
+}
+
+type GoveePtRealMsg struct {
+	Cmd  string             `json:"cmd"`
+	Data GoveePtRealMsgData `json:"data"`
+}
+
+type GoveePtRealMsgData struct {
+	Command []string `json:"command"`
+}
+
+// GoveeRazer carries a packet of the streaming protocol (DreamView), encoded in base64
+type GoveeRazer struct {
+	Msg GoveeRazerMsg `json:"msg"`
+}
+
+type GoveeRazerMsg struct {
+	Cmd  string            `json:"cmd"`
+	Data GoveeRazerMsgData `json:"data"`
+}
+
+type GoveeRazerMsgData struct {
+	Pt string `json:"pt"`
+}
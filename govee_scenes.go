@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultGoveeScenesFile is looked for next to configuration.json when govee_scenes_file is not set
+const defaultGoveeScenesFile = "govee_scenes.json"
+
+type GoveeSceneKind string
+
+const (
+	GoveeSceneKindScene GoveeSceneKind = "scene" // Default: a built-in dynamic scene, e.g. Sunset
+	GoveeSceneKindDIY   GoveeSceneKind = "diy"   // An effect created in the Govee app
+)
+
+// goveeSceneModes are the modes of the BLE "set mode" packet (0x33 0x05) activating each kind of scene
+var goveeSceneModes = map[GoveeSceneKind]byte{
+	GoveeSceneKindScene: 0x04,
+	GoveeSceneKindDIY:   0x0A,
+}
+
+// GoveeSceneDefinition is a scene of a Govee model, with the code of the Govee app effect library of the model
+type GoveeSceneDefinition struct {
+	Name     string         `json:"name"`
+	Kind     GoveeSceneKind `json:"kind"`
+	Code     int            `json:"code"`
+	Commands []string       `json:"commands"` // BLE packets (base64) sent before the activation, for the scenes with parameters
+}
+
+type goveeSceneCatalog struct {
+	mtx    struct{ sync.RWMutex }
+	scenes map[string][]GoveeSceneDefinition // Key is the SKU
+	cycles map[string]int                    // Key is the device and its scene list: index of the next scene
+}
+
+// GoveeScenes is the catalog of the scenes each Govee model supports, loaded from the scenes file
+var GoveeScenes = &goveeSceneCatalog{
+	scenes: make(map[string][]GoveeSceneDefinition),
+	cycles: make(map[string]int),
+}
+
+// LoadFile replaces the catalog with the content of the file, a JSON object whose keys are the SKUs
+// (see govee_scenes.example.json). The codes differ between models and firmwares: they are the sceneCode
+// values of the effect library the Govee app downloads for the SKU, from
+// https://app2.govee.com/appsku/v1/light-effect-libraries?sku=<SKU>.
+// When no file is given the default one is loaded, if present.
+func (c *goveeSceneCatalog) LoadFile(fileName string) error {
+	optional := fileName == ""
+	if optional {
+		fileName = defaultGoveeScenesFile
+	}
+	configurationPath, err := configurationFilePath()
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(filepath.Dir(configurationPath), fileName)
+	}
+
+	rawScenes, err := os.ReadFile(fileName)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("error reading Govee scenes file: %w", err)
+	}
+	var scenes map[string][]GoveeSceneDefinition
+	if err := json.Unmarshal(rawScenes, &scenes); err != nil {
+		return fmt.Errorf("error unmarshalling Govee scenes file %s: %w", fileName, err)
+	}
+	for sku, skuScenes := range scenes {
+		for i, scene := range skuScenes {
+			if err := scene.validate(); err != nil {
+				return fmt.Errorf("invalid Govee scene %q of %s: %w", scene.Name, sku, err)
+			}
+			if scene.Kind == "" {
+				skuScenes[i].Kind = GoveeSceneKindScene
+			}
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.scenes = scenes
+	return nil
+}
+
+func (s GoveeSceneDefinition) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if _, ok := goveeSceneModes[s.Kind]; !ok && s.Kind != "" {
+		return fmt.Errorf("unknown kind %q", s.Kind)
+	}
+	if s.Code < 0 || s.Code > 0xFFFF {
+		return fmt.Errorf("code %d out of range", s.Code)
+	}
+	for _, command := range s.Commands {
+		packet, err := base64.StdEncoding.DecodeString(command)
+		if err != nil {
+			return fmt.Errorf("invalid command %q: %w", command, err)
+		}
+		if len(packet) != goveeBLEPacketLength {
+			return fmt.Errorf("invalid command %q: %d bytes instead of %d", command, len(packet), goveeBLEPacketLength)
+		}
+	}
+	return nil
+}
+
+// Get finds a scene of the model by name, ignoring the case
+func (c *goveeSceneCatalog) Get(sku, name string) (GoveeSceneDefinition, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for _, scene := range c.scenes[sku] {
+		if strings.EqualFold(scene.Name, name) {
+			return scene, true
+		}
+	}
+	return GoveeSceneDefinition{}, false
+}
+
+// Next returns the scene to apply to the device among the given ones: each call moves to the following one,
+// so that pressing the same button cycles through them
+func (c *goveeSceneCatalog) Next(device string, names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	key := device + "\x00" + strings.Join(names, "\x00")
+	index := c.cycles[key] % len(names)
+	c.cycles[key] = index + 1
+	return names[index]
+}
+
+// NewGoveeSceneMessage activates the scene through a ptReal command, after its parameter packets if any
+func NewGoveeSceneMessage(device string, scene GoveeSceneDefinition) GoveeMessage {
+	kind := scene.Kind
+	if kind == "" {
+		kind = GoveeSceneKindScene
+	}
+	commands := append([]string{}, scene.Commands...)
+	// The code is sent least significant byte first
+	activation := goveeBLEPacket(0x33, 0x05, goveeSceneModes[kind], byte(scene.Code), byte(scene.Code>>8))
+	commands = append(commands, base64.StdEncoding.EncodeToString(activation))
+
+	return GoveeMessage{
+		Device:  device,
+		Cancels: []TransitionAttribute{TransitionAttributeColor},
+		Razer:   GoveeRazerModeOff,
+		Data: mustMarshal(GoveePtReal{
+			Msg: GoveePtRealMsg{
+				Cmd:  "ptReal",
+				Data: GoveePtRealMsgData{Command: commands},
+			},
+		}),
+	}
+}
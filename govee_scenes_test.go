@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestGoveeScenesCatalog(t *testing.T) {
+	t.Chdir(t.TempDir())
+	catalog := &goveeSceneCatalog{cycles: make(map[string]int)}
+
+	// Without a file given, the default one is optional
+	if err := catalog.LoadFile(""); err != nil {
+		t.Fatalf("loading without a scenes file: %s", err)
+	}
+	if err := catalog.LoadFile("missing.json"); err == nil {
+		t.Fatalf("expected an error for a missing scenes file")
+	}
+
+	scenes := `{
+    "H6159": [
+        {"name": "Sunset", "code": 10},
+        {"name": "Aurora", "code": 300},
+        {"name": "Party", "kind": "diy", "code": 7}
+    ]
+}`
+	if err := os.WriteFile(defaultGoveeScenesFile, []byte(scenes), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.LoadFile(""); err != nil {
+		t.Fatalf("loading the scenes file: %s", err)
+	}
+
+	aurora, ok := catalog.Get("H6159", "aurora")
+	if !ok {
+		t.Fatalf("scene not found")
+	}
+	var ptReal GoveePtReal
+	if err := json.Unmarshal(NewGoveeSceneMessage("lamp", aurora).Data, &ptReal); err != nil {
+		t.Fatal(err)
+	}
+	packet, err := base64.StdEncoding.DecodeString(ptReal.Msg.Data.Command[len(ptReal.Msg.Data.Command)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := goveeBLEPacket(0x33, 0x05, 0x04, 0x2C, 0x01)
+	if string(packet) != string(expected) {
+		t.Errorf("unexpected activation packet: % X, expected % X", packet, expected)
+	}
+
+	party, _ := catalog.Get("H6159", "Party")
+	if party.Kind != GoveeSceneKindDIY {
+		t.Errorf("expected a DIY effect, got %q", party.Kind)
+	}
+
+	names := []string{"Sunset", "Aurora"}
+	for _, expected := range []string{"Sunset", "Aurora", "Sunset"} {
+		if name := catalog.Next("lamp", names); name != expected {
+			t.Errorf("expected %s, got %s", expected, name)
+		}
+	}
+
+	if err := os.WriteFile(defaultGoveeScenesFile, []byte(`{"H6159": [{"name": "Broken", "commands": ["AAE="]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.LoadFile(""); err == nil {
+		t.Errorf("expected an error for a command that is not a BLE packet")
+	}
+}
+
+func TestGoveeScenesExampleFile(t *testing.T) {
+	// Resolved next to configuration.json, like the configured scenes file
+	catalog := &goveeSceneCatalog{cycles: make(map[string]int)}
+	if err := catalog.LoadFile("govee_scenes.example.json"); err != nil {
+		t.Fatalf("loading the example scenes file: %s", err)
+	}
+	for _, sku := range []string{"H6076", "H619A"} {
+		for _, name := range []string{"Sunset", "Aurora", "Candlelight"} {
+			if scene, ok := catalog.Get(sku, name); !ok || scene.Kind != GoveeSceneKindScene {
+				t.Errorf("%s of %s not loaded: %+v", name, sku, scene)
+			}
+		}
+	}
+}
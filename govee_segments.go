@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	// goveeBLEPacketLength is the length of the BLE packets, the last byte being their checksum
+	goveeBLEPacketLength = 20
+	// goveeMaxSegments is the number of segments the mask of the segment color packet can address:
+	// it takes the 7 bytes left before the checksum
+	goveeMaxSegments = 56
+	// goveeMaxGradientColors is the number of colors a razer packet can carry in its length byte
+	goveeMaxGradientColors = 84
+)
+
+// GoveeSegmentColor paints some segments of a device, numbered from 0
+type GoveeSegmentColor struct {
+	Segments []int       `json:"segments"`
+	Color    deviceColor `json:"color"`
+}
+
+// goveeBLEPacket pads the payload to a BLE packet and appends its checksum
+func goveeBLEPacket(payload ...byte) []byte {
+	packet := make([]byte, goveeBLEPacketLength)
+	copy(packet, payload)
+	packet[goveeBLEPacketLength-1] = xorChecksum(packet[:goveeBLEPacketLength-1])
+	return packet
+}
+
+// goveeRazerPacket frames the payload of a razer command: header, length, command and checksum
+func goveeRazerPacket(command byte, payload ...byte) []byte {
+	packet := append([]byte{0xBB, 0x00, byte(len(payload)), command}, payload...)
+	return append(packet, xorChecksum(packet))
+}
+
+func xorChecksum(data []byte) byte {
+	var checksum byte
+	for _, b := range data {
+		checksum ^= b
+	}
+	return checksum
+}
+
+// validateGoveeSegments checks the segment numbers, since out of range ones would silently paint nothing
+func validateGoveeSegments(segments []int) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("no segment given")
+	}
+	for _, segment := range segments {
+		if segment < 0 || segment >= goveeMaxSegments {
+			return fmt.Errorf("segment %d out of range, must be between 0 and %d", segment, goveeMaxSegments-1)
+		}
+	}
+	return nil
+}
+
+// NewGoveeSegmentColorMessage paints the segments through a ptReal command: one BLE packet per color
+func NewGoveeSegmentColorMessage(device string, segmentColors []GoveeSegmentColor) (GoveeMessage, error) {
+	var commands []string
+	for _, segmentColor := range segmentColors {
+		if err := validateGoveeSegments(segmentColor.Segments); err != nil {
+			return GoveeMessage{}, err
+		}
+		// 0x33 0x05 0x15 0x01 sets the color of the segments whose bits are set in the mask, least significant byte first
+		payload := []byte{0x33, 0x05, 0x15, 0x01,
+			byte(segmentColor.Color.Red), byte(segmentColor.Color.Green), byte(segmentColor.Color.Blue),
+			0x00, 0x00, 0x00, 0x00, 0x00}
+		mask := make([]byte, goveeMaxSegments/8)
+		for _, segment := range segmentColor.Segments {
+			mask[segment/8] |= 1 << (segment % 8)
+		}
+		payload = append(payload, mask...)
+		commands = append(commands, base64.StdEncoding.EncodeToString(goveeBLEPacket(payload...)))
+	}
+	if len(commands) == 0 {
+		return GoveeMessage{}, fmt.Errorf("no segment color given")
+	}
+
+	return GoveeMessage{
+		Device:  device,
+		Cancels: []TransitionAttribute{TransitionAttributeColor},
+		Razer:   GoveeRazerModeOff,
+		Data: mustMarshal(GoveePtReal{
+			Msg: GoveePtRealMsg{
+				Cmd:  "ptReal",
+				Data: GoveePtRealMsgData{Command: commands},
+			},
+		}),
+	}, nil
+}
+
+// NewGoveeGradientMessages spreads the colors over the device through the razer streaming commands:
+// the first message enables the streaming mode, the second one sends the colors, blended by the device
+func NewGoveeGradientMessages(device string, colors []deviceColor) ([]GoveeMessage, error) {
+	if len(colors) == 0 || len(colors) > goveeMaxGradientColors {
+		return nil, fmt.Errorf("a gradient needs between 1 and %d colors, got %d", goveeMaxGradientColors, len(colors))
+	}
+
+	// 0xB0: gradient flag, number of colors, then each color
+	payload := []byte{0x01, byte(len(colors))}
+	for _, color := range colors {
+		payload = append(payload, byte(color.Red), byte(color.Green), byte(color.Blue))
+	}
+
+	return []GoveeMessage{
+		newGoveeRazerMessage(device, goveeRazerPacket(0xB1, 0x01)),
+		newGoveeRazerMessage(device, goveeRazerPacket(0xB0, payload...)),
+	}, nil
+}
+
+// NewGoveeRazerDisableMessage leaves the razer streaming mode, for the device to follow the other color commands again
+func NewGoveeRazerDisableMessage(device string) GoveeMessage {
+	message := newGoveeRazerMessage(device, goveeRazerPacket(0xB1, 0x00))
+	message.Razer = GoveeRazerModeOff
+	return message
+}
+
+func newGoveeRazerMessage(device string, packet []byte) GoveeMessage {
+	return GoveeMessage{
+		Device:  device,
+		Cancels: []TransitionAttribute{TransitionAttributeColor},
+		Razer:   GoveeRazerModeOn,
+		Data: mustMarshal(GoveeRazer{
+			Msg: GoveeRazerMsg{
+				Cmd:  "razer",
+				Data: GoveeRazerMsgData{Pt: base64.StdEncoding.EncodeToString(packet)},
+			},
+		}),
+	}
+}
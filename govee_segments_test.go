@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestNewGoveeGradientMessages(t *testing.T) {
+	messages, err := NewGoveeGradientMessages("strip", []deviceColor{{Red: 255}, {Blue: 255}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected the enable and the colors messages, got %d", len(messages))
+	}
+
+	var enable GoveeRazer
+	if err := json.Unmarshal(messages[0].Data, &enable); err != nil {
+		t.Fatal(err)
+	}
+	// The packet sent by the Govee app to enable the streaming mode
+	if enable.Msg.Cmd != "razer" || enable.Msg.Data.Pt != "uwABsQEK" {
+		t.Errorf("unexpected enable message: %s", messages[0].Data)
+	}
+
+	var colors GoveeRazer
+	if err := json.Unmarshal(messages[1].Data, &colors); err != nil {
+		t.Fatal(err)
+	}
+	packet, err := base64.StdEncoding.DecodeString(colors.Msg.Data.Pt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0xBB, 0x00, 0x08, 0xB0, 0x01, 0x02, 0xFF, 0x00, 0x00, 0x00, 0x00, 0xFF}
+	expected = append(expected, xorChecksum(expected))
+	if string(packet) != string(expected) {
+		t.Errorf("unexpected colors packet: % X, expected % X", packet, expected)
+	}
+
+	if _, err := NewGoveeGradientMessages("strip", nil); err == nil {
+		t.Errorf("expected an error without colors")
+	}
+}
+
+func TestNewGoveeSegmentColorMessage(t *testing.T) {
+	message, err := NewGoveeSegmentColorMessage("strip", []GoveeSegmentColor{
+		{Segments: []int{0, 1, 9}, Color: deviceColor{Red: 0x10, Green: 0x20, Blue: 0x30}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ptReal GoveePtReal
+	if err := json.Unmarshal(message.Data, &ptReal); err != nil {
+		t.Fatal(err)
+	}
+	if ptReal.Msg.Cmd != "ptReal" || len(ptReal.Msg.Data.Command) != 1 {
+		t.Fatalf("unexpected message: %s", message.Data)
+	}
+	packet, err := base64.StdEncoding.DecodeString(ptReal.Msg.Data.Command[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x33, 0x05, 0x15, 0x01, 0x10, 0x20, 0x30, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00}
+	expected = append(expected, xorChecksum(expected))
+	if string(packet) != string(expected) {
+		t.Errorf("unexpected packet: % X, expected % X", packet, expected)
+	}
+
+	if _, err := NewGoveeSegmentColorMessage("strip", []GoveeSegmentColor{{Segments: []int{goveeMaxSegments}}}); err == nil {
+		t.Errorf("expected an error for a segment out of range")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type groupTableRow struct {
+	Name   string
+	Status groupStatus
+}
+
+// GroupTableModel is a reusable component that displays the aggregated status of the configured groups
+type GroupTableModel struct {
+	rows   []groupTableRow
+	cursor int
+	offset int // First row shown when the rows do not fit the height
+
+	width  int
+	height int
+}
+
+// NewGroupTableModel creates a new group table, loaded with the current status of the groups
+func NewGroupTableModel() *GroupTableModel {
+	m := &GroupTableModel{}
+	m.Refresh()
+	return m
+}
+
+func (m *GroupTableModel) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.scrollToCursor()
+}
+
+// Refresh reloads the rows from Status, keeping the cursor on the selected group
+func (m *GroupTableModel) Refresh() {
+	selected, _ := m.Selected()
+
+	groups := Status.GetAllGroups()
+	m.rows = make([]groupTableRow, 0, len(groups))
+	for name, status := range groups {
+		m.rows = append(m.rows, groupTableRow{Name: name, Status: status})
+	}
+	slices.SortFunc(m.rows, func(a, b groupTableRow) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	m.cursor = max(0, min(m.cursor, len(m.rows)-1))
+	for i, row := range m.rows {
+		if row.Name == selected {
+			m.cursor = i
+			break
+		}
+	}
+	m.scrollToCursor()
+}
+
+// Selected returns the name of the group under the cursor
+func (m *GroupTableModel) Selected() (string, bool) {
+	if m.cursor < 0 || m.cursor >= len(m.rows) {
+		return "", false
+	}
+	return m.rows[m.cursor].Name, true
+}
+
+// visibleRows is the number of rows fitting the height, excluding header and footer
+func (m *GroupTableModel) visibleRows() int {
+	if m.height <= 0 {
+		return len(m.rows)
+	}
+	return max(1, m.height-2)
+}
+
+func (m *GroupTableModel) scrollToCursor() {
+	visible := m.visibleRows()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+visible {
+		m.offset = m.cursor - visible + 1
+	}
+	m.offset = max(0, min(m.offset, len(m.rows)-visible))
+}
+
+// Init implements tea.Model
+func (m *GroupTableModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model
+func (m *GroupTableModel) Update(msg tea.Msg) (*GroupTableModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			m.cursor = max(0, m.cursor-1)
+		case "down", "j":
+			m.cursor = min(len(m.rows)-1, m.cursor+1)
+		case "home", "g":
+			m.cursor = 0
+		case "end", "G":
+			m.cursor = len(m.rows) - 1
+		}
+		m.scrollToCursor()
+	case tuiUpdateModel:
+		m.Refresh()
+	}
+	return m, nil
+}
+
+// View implements tea.Model
+func (m *GroupTableModel) View() string {
+	const (
+		nameWidth       = 24
+		onWidth         = 10
+		brightnessWidth = 10
+	)
+
+	var b strings.Builder
+
+	b.WriteString(strings.Join([]string{
+		deviceTableCell(deviceTableHeaderStyle, nameWidth, "Group"),
+		deviceTableCell(deviceTableHeaderStyle, onWidth, "Power"),
+		deviceTableCell(deviceTableHeaderStyle, brightnessWidth, "Brightness"),
+		deviceTableHeaderStyle.Render("Members"),
+	}, " "))
+
+	if len(m.rows) == 0 {
+		b.WriteString("\n")
+		b.WriteString(deviceTableMutedStyle.Render("No groups configured"))
+	}
+
+	end := min(len(m.rows), m.offset+m.visibleRows())
+	for i := m.offset; i < end; i++ {
+		row := m.rows[i]
+		base := lipgloss.NewStyle()
+		if i == m.cursor {
+			base = deviceTableSelectedStyle
+		}
+
+		brightness := "?"
+		if row.Status.Brightness >= 0 {
+			brightness = fmt.Sprintf("%d%%", row.Status.Brightness)
+		}
+		membersWidth := max(10, m.width-nameWidth-onWidth-brightnessWidth-3)
+
+		b.WriteString("\n")
+		b.WriteString(strings.Join([]string{
+			deviceTableCell(base, nameWidth, row.Name),
+			deviceTableCell(base, onWidth, string(row.Status.On)),
+			deviceTableCell(base, brightnessWidth, brightness),
+			deviceTableCell(base, membersWidth, strings.Join(row.Status.Members, ", ")),
+		}, base.Render(" ")))
+	}
+
+	b.WriteString("\n")
+	b.WriteString(deviceTableMutedStyle.Render(fmt.Sprintf(
+		"%d/%d · ↑/↓ select", min(m.cursor+1, len(m.rows)), len(m.rows))))
+
+	return b.String()
+}
@@ -1,22 +1,534 @@
 package main
 
 import (
+	"context"
+	_ "embed"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
-func StartHTTPServer() error {
+const defaultHTTPAddress = ":8080"
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// HTTPServer serves the REST API under /api/v1 (described by /api/v1/openapi.json).
+// Commands go through the dispatcher, like the ones coming from the Hue bridge:
+// they are answered with 202 and their outcome is recorded in /api/v1/dispatches.
+// Changes are pushed live on /api/v1/stream (see StreamHub).
+type HTTPServer struct {
+	configuration           Configuration
+	dispatcher              *Dispatcher
+	goveeConnection         *GoveeConnection
+	wledBrightnessRetriever BrightnessRetriever
+	stream                  *StreamHub
+	devServerURL            string // When set, the dashboard is proxied from the Vite dev server instead of being embedded
+
+	ctx context.Context // Lifetime of the server, used for dispatching: requests contexts end too early
+}
+
+func NewHTTPServer(
+	configuration Configuration,
+	dispatcher *Dispatcher,
+	goveeConnection *GoveeConnection,
+	wledBrightnessRetriever BrightnessRetriever,
+	opts ...httpServerOption,
+) *HTTPServer {
+	s := &HTTPServer{
+		configuration:           configuration,
+		dispatcher:              dispatcher,
+		goveeConnection:         goveeConnection,
+		wledBrightnessRetriever: wledBrightnessRetriever,
+		stream:                  NewStreamHub(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type httpServerOption func(*HTTPServer)
+
+// WithDevServer proxies the dashboard from the Vite dev server, for hot reloading
+func WithDevServer(url string) httpServerOption {
+	return func(s *HTTPServer) {
+		s.devServerURL = url
+	}
+}
+
+// Start serves the API until the context is cancelled
+func (s *HTTPServer) Start(ctx context.Context) error {
+	s.ctx = ctx
+
+	httpConfiguration := s.configuration.HTTP
+
+	authenticator, err := newHTTPAuthenticator(httpConfiguration)
+	if err != nil {
+		return fmt.Errorf("error configuring HTTP API authentication: %w", err)
+	}
+
+	address := httpConfiguration.Address
+	if address == "" {
+		address = defaultHTTPAddress
+	}
+	if len(httpConfiguration.Tokens) == 0 {
+		log.Warn().Msgf("No HTTP API token configured: anyone reaching %s can control the devices", address)
+	}
+
+	mux := s.newMux()
+	if s.devServerURL != "" {
+		devServerProxy, err := newDevServerProxy(s.devServerURL)
+		if err != nil {
+			return err
+		}
+		mux.Handle("/", devServerProxy)
+	} else {
+		mux.Handle("/", newDashboardHandler())
+	}
+
+	certFile, keyFile := "", ""
+	if httpConfiguration.TLS.Enabled {
+		certFile = httpConfiguration.TLS.CertFile
+		if certFile == "" {
+			certFile = defaultTLSCertFile
+		}
+		keyFile = httpConfiguration.TLS.KeyFile
+		if keyFile == "" {
+			keyFile = defaultTLSKeyFile
+		}
+		if err := ensureTLSCertificate(certFile, keyFile); err != nil {
+			return fmt.Errorf("error preparing TLS certificate: %w", err)
+		}
+	}
+
+	// Listening first reports a busy address right away, instead of once the stream hub stops on shutdown
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("error serving HTTP API on %s: %w", address, err)
+	}
+
+	// The stream hub stops along with the server, even when serving fails
+	streamCtx, cancelStream := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancelStream()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.stream.Start(streamCtx)
+	}()
+
+	server := &http.Server{
+		Addr:    address,
+		Handler: authenticator.Wrap(mux),
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if httpConfiguration.TLS.Enabled {
+		log.Info().Msgf("HTTP API listening on https://%s", address)
+		err = server.ServeTLS(listener, certFile, keyFile)
+	} else {
+		log.Info().Msgf("HTTP API listening on http://%s", address)
+		err = server.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("error serving HTTP API on %s: %w", address, err)
+	}
+	return nil
+}
+
+// httpRoute is an endpoint of the API: every route but the OpenAPI document itself is described in openapi.json
+type httpRoute struct {
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+func (s *HTTPServer) routes() []httpRoute {
+	return []httpRoute{
+		{"GET /api/v1/{$}", s.handleAllStatus},
+		{"GET /api/v1/openapi.json", s.handleOpenAPI},
+
+		{"GET /api/v1/devices", s.handleDevices},
+		{"GET /api/v1/devices/{name}", s.handleDevice},
+		{"GET /api/v1/devices/{name}/status", s.handleDeviceStatus},
+		{"POST /api/v1/devices/{name}/commands", s.handleDeviceCommand},
+
+		{"GET /api/v1/groups", s.handleGroups},
+		{"GET /api/v1/groups/{name}", s.handleGroup},
+		{"POST /api/v1/groups/{name}/commands", s.handleGroupCommand},
+
+		{"GET /api/v1/actions", s.handleActions},
+		{"POST /api/v1/actions/{name}/trigger", s.handleTriggerAction},
+
+		{"GET /api/v1/scenes", s.handleScenes},
+		{"POST /api/v1/scenes/{name}/apply", s.handleApplyScene},
+
+		{"GET /api/v1/discovered", s.handleDiscovered},
+		{"POST /api/v1/discovered/{mac}/adopt", s.handleAdoptDiscovered},
+		{"GET /api/v1/dispatches", s.handleDispatches},
+		{"GET /api/v1/stream", s.handleStream},
+	}
+}
+
+func (s *HTTPServer) newMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /api/v1/", func(w http.ResponseWriter, r *http.Request) {
-		allDevicesStatus := Status.GetAll()
+	for _, route := range s.routes() {
+		mux.HandleFunc(route.Pattern, route.Handler)
+	}
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, fmt.Sprintf("no endpoint for %s %s", r.Method, r.URL.Path))
+	})
+
+	return mux
+}
+
+// handleAllStatus returns the status of every device, keyed by name
+func (s *HTTPServer) handleAllStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Status.GetAll())
+}
+
+func (s *HTTPServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIDocument)
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "    ")
-		enc.Encode(allDevicesStatus)
+func (s *HTTPServer) handleDevices(w http.ResponseWriter, r *http.Request) {
+	allDevicesStatus := Status.GetAll()
+	devices := make([]HTTPDevice, 0, len(allDevicesStatus))
+	for name, status := range allDevicesStatus {
+		devices = append(devices, newHTTPDevice(name, status))
+	}
+	slices.SortFunc(devices, func(a, b HTTPDevice) int {
+		return strings.Compare(a.Name, b.Name)
 	})
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (s *HTTPServer) handleDevice(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	status, ok := Status.Get(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, fmt.Sprintf("device %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, newHTTPDevice(name, status))
+}
+
+func (s *HTTPServer) handleDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	status, ok := Status.Get(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, fmt.Sprintf("device %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *HTTPServer) handleDeviceCommand(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	status, ok := Status.Get(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, fmt.Sprintf("device %s not found", name))
+		return
+	}
+
+	command, ok := decodeCommand(w, r)
+	if !ok {
+		return
+	}
+
+	capabilities := ProviderCapabilities[status.Provider]
+	for _, capability := range command.requiredCapabilities() {
+		if !slices.Contains(capabilities, capability) {
+			writeError(w, http.StatusUnprocessableEntity, HTTPErrorCodeUnsupportedCapability,
+				fmt.Sprintf("device %s (%s) does not support %s", name, status.Provider, capability))
+			return
+		}
+	}
+
+	s.dispatchCommand(w, fmt.Sprintf("api command to %s", name), []string{name}, command)
+}
+
+func (s *HTTPServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Status.GetAllGroups())
+}
+
+func (s *HTTPServer) handleGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	groupStatus, ok := Status.GetGroup(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, fmt.Sprintf("group %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, groupStatus)
+}
+
+// handleGroupCommand sends the command to every member: attributes a member does not support are ignored
+func (s *HTTPServer) handleGroupCommand(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	devices, ok := s.configuration.GetGroupDevices(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, fmt.Sprintf("group %s not found", name))
+		return
+	}
+
+	command, ok := decodeCommand(w, r)
+	if !ok {
+		return
+	}
+
+	s.dispatchCommand(w, fmt.Sprintf("api command to group %s", name), devices, command)
+}
+
+func (s *HTTPServer) handleActions(w http.ResponseWriter, r *http.Request) {
+	actions := make([]HTTPAction, 0, len(s.configuration.Actions))
+	for _, action := range s.configuration.Actions {
+		actions = append(actions, HTTPAction{
+			Name:        action.Name,
+			Trigger:     action.Trigger,
+			Triggerable: action.Name != "" && isActionTriggerable(action),
+			Devices:     action.GetDevices(),
+		})
+	}
+	writeJSON(w, http.StatusOK, actions)
+}
+
+// handleTriggerAction runs the action as if its dial button were pressed, whatever its trigger
+func (s *HTTPServer) handleTriggerAction(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	action, ok := s.configuration.GetActionByName(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, fmt.Sprintf("action %s not found", name))
+		return
+	}
+	if !isActionTriggerable(action) {
+		writeError(w, http.StatusConflict, HTTPErrorCodeNotTriggerable,
+			fmt.Sprintf("action %s is a %s rule: it mirrors a Hue light and cannot be triggered", name, action.Trigger))
+		return
+	}
+
+	dispatchName := fmt.Sprintf("api trigger of action %s", name)
+	goveeMessages, twinklyMessages, switchbotMessages, wledMessages := s.configuration.GetMessagesToDispatchOnAction(action, s.wledBrightnessRetriever)
+	s.dispatcher.Dispatch(s.ctx, dispatchName, goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+
+	writeJSON(w, http.StatusAccepted, HTTPAccepted{Dispatch: dispatchName, Devices: action.GetDevices()})
+}
+
+func (s *HTTPServer) handleScenes(w http.ResponseWriter, r *http.Request) {
+	allScenes := Scenes.GetAll()
+	scenes := make([]HTTPScene, 0, len(allScenes))
+	for name, scene := range allScenes {
+		scenes = append(scenes, HTTPScene{Name: name, Devices: scene.Devices, TransitionMs: scene.TransitionMs})
+	}
+	slices.SortFunc(scenes, func(a, b HTTPScene) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	writeJSON(w, http.StatusOK, scenes)
+}
+
+func (s *HTTPServer) handleApplyScene(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	scene, ok := Scenes.Get(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, fmt.Sprintf("scene %s not found", name))
+		return
+	}
+
+	var devices []string
+	for deviceOrGroup := range scene.Devices {
+		devices = append(devices, deviceOrGroup)
+	}
+	devices = s.configuration.ResolveDevices(devices)
+	slices.Sort(devices)
+
+	dispatchName := fmt.Sprintf("api apply of scene %s", name)
+	goveeMessages, twinklyMessages, switchbotMessages, wledMessages := s.configuration.GetMessagesToApplyScene(scene)
+	s.dispatcher.Dispatch(s.ctx, dispatchName, goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+
+	writeJSON(w, http.StatusAccepted, HTTPAccepted{Dispatch: dispatchName, Devices: devices})
+}
+
+// handleDiscovered lists the devices found on the network that are not in the configuration
+func (s *HTTPServer) handleDiscovered(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.goveeConnection.GetUnconfiguredDevices())
+}
+
+// handleAdoptDiscovered adds a discovered device to configuration.json, to be controlled after a restart
+func (s *HTTPServer) handleAdoptDiscovered(w http.ResponseWriter, r *http.Request) {
+	var adoption HTTPAdoption
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&adoption); err != nil {
+		writeError(w, http.StatusBadRequest, HTTPErrorCodeInvalidRequest, fmt.Sprintf("invalid JSON body: %s", err))
+		return
+	}
+	if strings.TrimSpace(adoption.Alias) == "" {
+		writeError(w, http.StatusBadRequest, HTTPErrorCodeInvalidRequest, "alias is required")
+		return
+	}
+
+	device, err := s.goveeConnection.AdoptDevice(r.PathValue("mac"), adoption.Alias)
+	switch {
+	case errors.Is(err, ErrDeviceNotFound):
+		writeError(w, http.StatusNotFound, HTTPErrorCodeNotFound, err.Error())
+	case errors.Is(err, ErrAlreadyConfigured):
+		writeError(w, http.StatusConflict, HTTPErrorCodeConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, HTTPErrorCodeInternal, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, device)
+	}
+}
+
+func (s *HTTPServer) handleDispatches(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Dispatches.GetAll())
+}
+
+func (s *HTTPServer) dispatchCommand(w http.ResponseWriter, dispatchName string, devices []string, command HTTPCommand) {
+	state := command.toDeviceState()
+	transition := time.Duration(command.TransitionMs) * time.Millisecond
+
+	var goveeMessages []GoveeMessage
+	var twinklyMessages []TwinklyMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
+	for _, device := range devices {
+		deviceGoveeMessages, deviceTwinklyMessages, deviceSwitchbotMessages, deviceWledMessages := s.configuration.GetMessagesToApplyDeviceState(device, state, transition)
+		goveeMessages = append(goveeMessages, deviceGoveeMessages...)
+		twinklyMessages = append(twinklyMessages, deviceTwinklyMessages...)
+		switchbotMessages = append(switchbotMessages, deviceSwitchbotMessages...)
+		wledMessages = append(wledMessages, deviceWledMessages...)
+	}
+	s.dispatcher.Dispatch(s.ctx, dispatchName, goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+
+	writeJSON(w, http.StatusAccepted, HTTPAccepted{Dispatch: dispatchName, Devices: devices})
+}
+
+// isActionTriggerable tells whether an action has something to run on its own: sync rules only react to a Hue light
+func isActionTriggerable(action ConfigurationAction) bool {
+	return action.Trigger != ActionTriggerHueLightSync && action.Trigger != ActionTriggerHueGroupSync
+}
+
+func newHTTPDevice(name string, status deviceStatus) HTTPDevice {
+	capabilities := ProviderCapabilities[status.Provider]
+	if capabilities == nil {
+		capabilities = []DeviceCapability{}
+	}
+	return HTTPDevice{
+		Name:         name,
+		Provider:     status.Provider,
+		Capabilities: capabilities,
+		Status:       status,
+	}
+}
+
+// decodeCommand reads and validates the command, writing the error response when it is not valid
+func decodeCommand(w http.ResponseWriter, r *http.Request) (HTTPCommand, bool) {
+	var command HTTPCommand
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&command); err != nil {
+		message := fmt.Sprintf("invalid JSON body: %s", err)
+		if errors.Is(err, io.EOF) {
+			message = "empty body"
+		}
+		writeError(w, http.StatusBadRequest, HTTPErrorCodeInvalidRequest, message)
+		return HTTPCommand{}, false
+	}
+	if err := command.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, HTTPErrorCodeInvalidRequest, err.Error())
+		return HTTPCommand{}, false
+	}
+	return command, true
+}
+
+func (c HTTPCommand) validate() error {
+	if c.On == nil && c.Brightness == nil && c.Color == nil && c.ColorTemperature == nil && c.Effect == nil {
+		return fmt.Errorf("the command changes nothing: set at least one of on, brightness, color, color_temperature, effect")
+	}
+	if c.Brightness != nil && (*c.Brightness < 0 || *c.Brightness > 100) {
+		return fmt.Errorf("brightness must be between 0 and 100, got %d", *c.Brightness)
+	}
+	if c.Color != nil {
+		for _, component := range []int{c.Color.Red, c.Color.Green, c.Color.Blue} {
+			if component < 0 || component > 255 {
+				return fmt.Errorf("color components must be between 0 and 255, got %d", component)
+			}
+		}
+	}
+	if c.ColorTemperature != nil && (*c.ColorTemperature < 1000 || *c.ColorTemperature > 40000) {
+		return fmt.Errorf("color_temperature must be between 1000 and 40000 kelvin, got %d", *c.ColorTemperature)
+	}
+	if c.Effect != nil && *c.Effect < 0 {
+		return fmt.Errorf("effect must not be negative, got %d", *c.Effect)
+	}
+	if c.TransitionMs < 0 {
+		return fmt.Errorf("transition_ms must not be negative, got %d", c.TransitionMs)
+	}
+	return nil
+}
+
+func (c HTTPCommand) requiredCapabilities() []DeviceCapability {
+	var capabilities []DeviceCapability
+	if c.On != nil {
+		capabilities = append(capabilities, DeviceCapabilityOnOff)
+	}
+	if c.Brightness != nil {
+		capabilities = append(capabilities, DeviceCapabilityBrightness)
+	}
+	if c.Color != nil {
+		capabilities = append(capabilities, DeviceCapabilityColor)
+	}
+	if c.ColorTemperature != nil {
+		capabilities = append(capabilities, DeviceCapabilityColorTemperature)
+	}
+	if c.Effect != nil {
+		capabilities = append(capabilities, DeviceCapabilityEffect)
+	}
+	return capabilities
+}
+
+func (c HTTPCommand) toDeviceState() DeviceState {
+	state := DeviceState{
+		On:         c.On,
+		Brightness: c.Brightness,
+		Color:      c.Color,
+		Effect:     c.Effect,
+	}
+	if state.Color == nil && c.ColorTemperature != nil {
+		red, green, blue := kelvinToRGB(*c.ColorTemperature)
+		state.Color = &deviceColor{Red: int(red), Green: int(green), Blue: int(blue)}
+	}
+	return state
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(v); err != nil {
+		log.Err(err).Msgf("error encoding HTTP response: %s", err)
+	}
+}
 
-	return http.ListenAndServe(":8080", mux)
+func writeError(w http.ResponseWriter, statusCode int, code HTTPErrorCode, message string) {
+	writeJSON(w, statusCode, HTTPError{Error: HTTPErrorBody{Code: code, Message: message}})
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const auditMaxBodySize = 4096
+
+// httpAuthenticator checks the API tokens and writes the audit log of the control requests
+type httpAuthenticator struct {
+	tokens      []HTTPTokenConfiguration
+	auditLogger *zerolog.Logger // Nil when no audit log file is configured
+}
+
+func newHTTPAuthenticator(configuration HTTPConfiguration) (*httpAuthenticator, error) {
+	for _, token := range configuration.Tokens {
+		if token.Token == "" {
+			return nil, fmt.Errorf("empty token for %s", token.Name)
+		}
+		if token.Scope != HTTPTokenScopeRead && token.Scope != HTTPTokenScopeControl {
+			return nil, fmt.Errorf("unknown scope %q for token %s: expected %q or %q", token.Scope, token.Name, HTTPTokenScopeRead, HTTPTokenScopeControl)
+		}
+	}
+
+	a := &httpAuthenticator{tokens: configuration.Tokens}
+
+	if configuration.AuditLogFile != "" {
+		file, err := os.OpenFile(configuration.AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("error opening audit log file: %w", err)
+		}
+		auditLogger := zerolog.New(file).With().Timestamp().Logger()
+		a.auditLogger = &auditLogger
+	}
+
+	return a, nil
+}
+
+// Wrap rejects the API requests without a token allowed to perform them. Read requests (GET) need a token
+// of any scope, the others a "control" one. When no token is configured, every request is allowed.
+// The dashboard files are public: the dashboard asks for the token itself.
+func (a *httpAuthenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+		caller := r.RemoteAddr
+		if len(a.tokens) > 0 {
+			token, ok := a.authenticate(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				writeError(w, http.StatusUnauthorized, HTTPErrorCodeUnauthorized, "missing or invalid API token")
+				return
+			}
+			if !readOnly && token.Scope != HTTPTokenScopeControl {
+				writeError(w, http.StatusForbidden, HTTPErrorCodeForbidden, fmt.Sprintf("token %s is read-only", token.Name))
+				return
+			}
+			caller = fmt.Sprintf("%s (%s)", token.Name, r.RemoteAddr)
+		}
+
+		if readOnly {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, auditMaxBodySize))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		startedAt := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		a.audit(caller, r, body, recorder.status, time.Since(startedAt))
+	})
+}
+
+// authenticate looks for the token in the Authorization header ("Bearer <token>") or, for the clients
+// that cannot set headers (EventSource, WebSocket in browsers), in the "access_token" query parameter
+func (a *httpAuthenticator) authenticate(r *http.Request) (HTTPTokenConfiguration, bool) {
+	provided := r.URL.Query().Get("access_token")
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, value, _ := strings.Cut(authorization, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return HTTPTokenConfiguration{}, false
+		}
+		provided = strings.TrimSpace(value)
+	}
+	if provided == "" {
+		return HTTPTokenConfiguration{}, false
+	}
+
+	for _, token := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token.Token)) == 1 {
+			return token, true
+		}
+	}
+	return HTTPTokenConfiguration{}, false
+}
+
+func (a *httpAuthenticator) audit(caller string, r *http.Request, body []byte, status int, duration time.Duration) {
+	log.Info().Msgf("API %s %s by %s: %d %s", r.Method, r.URL.Path, caller, status, strings.TrimSpace(string(body)))
+
+	if a.auditLogger == nil {
+		return
+	}
+	a.auditLogger.Log().
+		Str("caller", caller).
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Bytes("body", body).
+		Int("status", status).
+		Dur("duration", duration).
+		Send()
+}
+
+// statusRecorder remembers the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPAuthenticator(t *testing.T) {
+	auditLogFile := filepath.Join(t.TempDir(), "audit.log")
+	authenticator, err := newHTTPAuthenticator(HTTPConfiguration{
+		Tokens: []HTTPTokenConfiguration{
+			{Name: "dashboard", Token: "read-token", Scope: HTTPTokenScopeRead},
+			{Name: "automation", Token: "control-token", Scope: HTTPTokenScopeControl},
+		},
+		AuditLogFile: auditLogFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := authenticator.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+
+	for _, test := range []struct {
+		name          string
+		method        string
+		target        string
+		authorization string
+		statusCode    int
+	}{
+		{"dashboard without token", "GET", "/index.html", "", http.StatusOK},
+		{"read without token", "GET", "/api/v1/devices", "", http.StatusUnauthorized},
+		{"control without token", "POST", "/api/v1/devices/lamp/commands", "", http.StatusUnauthorized},
+		{"unknown token", "GET", "/api/v1/devices", "Bearer other-token", http.StatusUnauthorized},
+		{"other scheme", "GET", "/api/v1/devices", "Basic cmVhZC10b2tlbg==", http.StatusUnauthorized},
+		{"read with read token", "GET", "/api/v1/devices", "Bearer read-token", http.StatusOK},
+		{"read with lowercase scheme", "GET", "/api/v1/devices", "bearer read-token", http.StatusOK},
+		{"read with control token", "GET", "/api/v1/devices", "Bearer control-token", http.StatusOK},
+		{"control with read token", "POST", "/api/v1/devices/lamp/commands", "Bearer read-token", http.StatusForbidden},
+		{"control with control token", "POST", "/api/v1/devices/lamp/commands", "Bearer control-token", http.StatusAccepted},
+		{"query token", "GET", "/api/v1/stream?access_token=read-token", "", http.StatusOK},
+		{"query token with control", "POST", "/api/v1/actions/night/trigger?access_token=control-token", "", http.StatusAccepted},
+		{"header takes precedence over query", "GET", "/api/v1/stream?access_token=read-token", "Bearer other-token", http.StatusUnauthorized},
+		{"empty query token", "GET", "/api/v1/stream?access_token=", "", http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.target, strings.NewReader(`{"on": true}`))
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.statusCode {
+				t.Errorf("expected %d, got %d: %s", test.statusCode, w.Code, w.Body)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("missing WWW-Authenticate header")
+			}
+		})
+	}
+
+	// Only the control requests that got through are audited, with the status written by the handler
+	file, err := os.Open(auditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	type auditEntry struct {
+		Caller string `json:"caller"`
+		Method string `json:"method"`
+		Path   string `json:"path"`
+		Body   string `json:"body"`
+		Status int    `json:"status"`
+	}
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid audit entry %s: %s", scanner.Bytes(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries, got %+v", entries)
+	}
+	for i, path := range []string{"/api/v1/devices/lamp/commands", "/api/v1/actions/night/trigger"} {
+		entry := entries[i]
+		if entry.Path != path || entry.Method != "POST" || entry.Status != http.StatusAccepted ||
+			entry.Body != `{"on": true}` || !strings.HasPrefix(entry.Caller, "automation (") {
+			t.Errorf("unexpected audit entry: %+v", entry)
+		}
+	}
+}
+
+func TestHTTPAuthenticatorWithoutTokens(t *testing.T) {
+	authenticator, err := newHTTPAuthenticator(HTTPConfiguration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := authenticator.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/devices/lamp/commands", strings.NewReader(`{"on": true}`)))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected the request to be allowed, got %d", w.Code)
+	}
+}
+
+func TestHTTPAuthenticatorConfiguration(t *testing.T) {
+	for _, token := range []HTTPTokenConfiguration{
+		{Name: "empty", Token: "", Scope: HTTPTokenScopeRead},
+		{Name: "no scope", Token: "token"},
+		{Name: "unknown scope", Token: "token", Scope: "admin"},
+	} {
+		if _, err := newHTTPAuthenticator(HTTPConfiguration{Tokens: []HTTPTokenConfiguration{token}}); err == nil {
+			t.Errorf("expected an error for token %s", token.Name)
+		}
+	}
+}
+
+func TestEmulatedHueRequiresOptInWithTokens(t *testing.T) {
+	configuration := Configuration{
+		HTTP:        HTTPConfiguration{Tokens: []HTTPTokenConfiguration{{Name: "automation", Token: "token", Scope: HTTPTokenScopeControl}}},
+		EmulatedHue: EmulatedHueConfiguration{Enabled: true, IP: "192.0.2.10"},
+	}
+	if _, err := NewEmulatedHueBridge(configuration, nil); !errors.Is(err, ErrEmulatedHueUnauthenticated) {
+		t.Errorf("expected ErrEmulatedHueUnauthenticated, got %v", err)
+	}
+
+	configuration.EmulatedHue.AllowUnauthenticated = true
+	if _, err := NewEmulatedHueBridge(configuration, nil); err != nil {
+		t.Errorf("expected the opt-in to allow the bridge, got %s", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+// HTTPErrorCode is the machine readable part of an API error
+type HTTPErrorCode string
+
+const (
+	HTTPErrorCodeNotFound              HTTPErrorCode = "not_found"
+	HTTPErrorCodeInvalidRequest        HTTPErrorCode = "invalid_request"
+	HTTPErrorCodeUnsupportedCapability HTTPErrorCode = "unsupported_capability"
+	HTTPErrorCodeNotTriggerable        HTTPErrorCode = "not_triggerable"
+	HTTPErrorCodeInternal              HTTPErrorCode = "internal_error"
+	HTTPErrorCodeUnauthorized          HTTPErrorCode = "unauthorized"
+	HTTPErrorCodeForbidden             HTTPErrorCode = "forbidden"
+	HTTPErrorCodeConflict              HTTPErrorCode = "conflict"
+)
+
+type HTTPError struct {
+	Error HTTPErrorBody `json:"error"`
+}
+
+type HTTPErrorBody struct {
+	Code    HTTPErrorCode `json:"code"`
+	Message string        `json:"message"`
+}
+
+type HTTPDevice struct {
+	Name         string             `json:"name"`
+	Provider     string             `json:"provider"`
+	Capabilities []DeviceCapability `json:"capabilities"`
+	Status       deviceStatus       `json:"status"`
+}
+
+// HTTPCommand is the body of the device and group commands: omitted attributes are left untouched
+type HTTPCommand struct {
+	On               *bool        `json:"on"`
+	Brightness       *int         `json:"brightness"`        // 0-100
+	Color            *deviceColor `json:"color"`             // 0-255 each
+	ColorTemperature *int         `json:"color_temperature"` // Kelvin, 1000-40000, ignored when color is set
+	Effect           *int         `json:"effect"`            // WLED effect ID
+	TransitionMs     int          `json:"transition_ms"`
+}
+
+type HTTPAction struct {
+	Name        string        `json:"name"`
+	Trigger     ActionTrigger `json:"trigger"`
+	Triggerable bool          `json:"triggerable"` // Sync rules mirror a Hue light: there is nothing to trigger
+	Devices     []string      `json:"devices"`
+}
+
+type HTTPScene struct {
+	Name         string                 `json:"name"`
+	Devices      map[string]DeviceState `json:"devices"`
+	TransitionMs int                    `json:"transition_ms"`
+}
+
+// HTTPAccepted is returned when messages are handed to the dispatcher: the outcome shows up in /api/v1/dispatches
+type HTTPAccepted struct {
+	Dispatch string   `json:"dispatch"`
+	Devices  []string `json:"devices"`
+}
+
+// HTTPAdoption is the body of the adoption of a discovered device
+type HTTPAdoption struct {
+	Alias string `json:"alias"`
+}
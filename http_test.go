@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHTTPServer serves the API for the WLED devices "api strip" and "api bulb", grouped as "api group"
+func newTestHTTPServer(t *testing.T) (*httptest.Server, *testWledDevices) {
+	t.Helper()
+	fake, wled := startTestWledDevices(t, nil, "api strip", "api bulb")
+	configuration := Configuration{
+		Wled:   wled.devices,
+		Groups: map[string]ConfigurationGroup{"api group": {Devices: []string{"api strip", "api bulb"}}},
+		Actions: []ConfigurationAction{
+			{
+				Name:        "api strip on",
+				Trigger:     ActionTriggerHueTapDialButtonPress,
+				WledActions: []ConfigurationActionWledAction{{Device: "api strip", Action: WledActionTurnOn}},
+			},
+			{Name: "api mirror", Trigger: ActionTriggerHueLightSync, LightName: "Desk"},
+		},
+	}
+	Status.Register("api strip", ProviderWled)
+	Status.Register("api bulb", ProviderWled)
+	Status.Register("api tree", ProviderTwinkly)
+
+	dispatcher := NewDispatcher(nil, nil, nil, wled)
+	s := NewHTTPServer(configuration, dispatcher, NewGoveeConnection(configuration), nil)
+	s.ctx = t.Context()
+	server := httptest.NewServer(s.newMux())
+	t.Cleanup(server.Close)
+	return server, fake
+}
+
+func testHTTPRequest(t *testing.T, server *httptest.Server, method, path, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		t.Fatalf("%s %s: invalid JSON response: %s", method, path, err)
+	}
+	return resp.StatusCode, raw
+}
+
+func TestHTTPCommands(t *testing.T) {
+	server, fake := newTestHTTPServer(t)
+	results, unsubscribe := Dispatches.Subscribe()
+	defer unsubscribe()
+
+	for _, test := range []struct {
+		method, path, body string
+		devices            []string
+	}{
+		{"POST", "/api/v1/devices/api%20strip/commands", `{"on": true, "brightness": 40}`, []string{"api strip"}},
+		{"POST", "/api/v1/groups/api%20group/commands", `{"color": {"r": 255, "g": 0, "b": 0}}`, []string{"api strip", "api bulb"}},
+		{"POST", "/api/v1/actions/api%20strip%20on/trigger", ``, []string{"api strip"}},
+	} {
+		statusCode, body := testHTTPRequest(t, server, test.method, test.path, test.body)
+		if statusCode != http.StatusAccepted {
+			t.Errorf("%s %s: expected 202, got %d: %s", test.method, test.path, statusCode, body)
+			continue
+		}
+		var accepted HTTPAccepted
+		if err := json.Unmarshal(body, &accepted); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(accepted.Devices, test.devices) {
+			t.Errorf("%s %s: expected devices %v, got %v", test.method, test.path, test.devices, accepted.Devices)
+		}
+		result := waitDispatchResult(t, results, accepted.Dispatch)
+		if len(result.Failed) > 0 {
+			t.Errorf("%s %s: dispatch failed: %v", test.method, test.path, result.Failed)
+		}
+	}
+
+	if received := fake.Received("api strip"); len(received) != 3 {
+		t.Errorf("expected 3 messages to the strip, got %v", received)
+	}
+	if received := fake.Received("api bulb"); len(received) != 1 {
+		t.Errorf("expected 1 message to the bulb, got %v", received)
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	server, _ := newTestHTTPServer(t)
+
+	for _, test := range []struct {
+		method, path, body string
+		statusCode         int
+		code               HTTPErrorCode
+	}{
+		{"GET", "/api/v1/devices/missing", ``, http.StatusNotFound, HTTPErrorCodeNotFound},
+		{"GET", "/api/v1/devices/missing/status", ``, http.StatusNotFound, HTTPErrorCodeNotFound},
+		{"POST", "/api/v1/devices/missing/commands", `{"on": true}`, http.StatusNotFound, HTTPErrorCodeNotFound},
+		{"GET", "/api/v1/groups/missing", ``, http.StatusNotFound, HTTPErrorCodeNotFound},
+		{"POST", "/api/v1/groups/missing/commands", `{"on": true}`, http.StatusNotFound, HTTPErrorCodeNotFound},
+		{"POST", "/api/v1/actions/missing/trigger", ``, http.StatusNotFound, HTTPErrorCodeNotFound},
+		{"POST", "/api/v1/scenes/missing/apply", ``, http.StatusNotFound, HTTPErrorCodeNotFound},
+		{"GET", "/api/v1/missing", ``, http.StatusNotFound, HTTPErrorCodeNotFound},
+		{"POST", "/api/v1/devices/api%20strip/commands", ``, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/devices/api%20strip/commands", `{"on": tru`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/devices/api%20strip/commands", `{"power": true}`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/devices/api%20strip/commands", `{}`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/devices/api%20strip/commands", `{"brightness": 101}`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/devices/api%20strip/commands", `{"color": {"r": 256, "g": 0, "b": 0}}`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/devices/api%20strip/commands", `{"color_temperature": 500}`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/devices/api%20strip/commands", `{"on": true, "transition_ms": -1}`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/groups/api%20group/commands", `{"brightness": -1}`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/devices/api%20tree/commands", `{"brightness": 50}`, http.StatusUnprocessableEntity, HTTPErrorCodeUnsupportedCapability},
+		{"POST", "/api/v1/actions/api%20mirror/trigger", ``, http.StatusConflict, HTTPErrorCodeNotTriggerable},
+		{"POST", "/api/v1/discovered/AA:BB/adopt", `{}`, http.StatusBadRequest, HTTPErrorCodeInvalidRequest},
+		{"POST", "/api/v1/discovered/AA:BB/adopt", `{"alias": "Lamp"}`, http.StatusNotFound, HTTPErrorCodeNotFound},
+	} {
+		statusCode, body := testHTTPRequest(t, server, test.method, test.path, test.body)
+		var httpError HTTPError
+		if err := json.Unmarshal(body, &httpError); err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != test.statusCode || httpError.Error.Code != test.code {
+			t.Errorf("%s %s %s: expected %d %s, got %d %s", test.method, test.path, test.body, test.statusCode, test.code, statusCode, body)
+		}
+	}
+}
+
+func TestHTTPAdoptDiscovered(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("configuration.json", []byte(`{"govee": {}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configuration, err := LoadConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	goveeConnection := NewGoveeConnection(configuration)
+	goveeConnection.trackUnconfiguredDevice(GoveeScanResponseMsgData{Device: "0C:D6:C8:6B:82:C6:2A:7B", SKU: "H6159", IP: "192.168.1.20"})
+	server := httptest.NewServer(NewHTTPServer(configuration, nil, goveeConnection, nil).newMux())
+	defer server.Close()
+
+	// The MAC in the URL may be in lowercase: the one reported by the device is written
+	statusCode, body := testHTTPRequest(t, server, "POST", "/api/v1/discovered/0c:d6:c8:6b:82:c6:2a:7b/adopt", `{"alias": "Strip"}`)
+	if statusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", statusCode, body)
+	}
+	adopted, err := LoadConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias, ok := adopted.GetGoveeDeviceAliasByMAC("0C:D6:C8:6B:82:C6:2A:7B"); !ok || alias != "Strip" {
+		t.Errorf("adopted device not found by its MAC: %q %v", alias, ok)
+	}
+
+	statusCode, body = testHTTPRequest(t, server, "POST", "/api/v1/discovered/0C:D6:C8:6B:82:C6:2A:7B/adopt", `{"alias": "Other"}`)
+	if statusCode != http.StatusConflict {
+		t.Errorf("expected 409 adopting twice, got %d: %s", statusCode, body)
+	}
+
+	// And the device may report it in lowercase
+	goveeConnection.trackUnconfiguredDevice(GoveeScanResponseMsgData{Device: "0c:d6:c8:6b:82:c6:2a:7c", SKU: "H6159", IP: "192.168.1.21"})
+	statusCode, body = testHTTPRequest(t, server, "POST", "/api/v1/discovered/0C:D6:C8:6B:82:C6:2A:7C/adopt", `{"alias": "Other"}`)
+	if statusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", statusCode, body)
+	}
+	if adopted, err = LoadConfiguration(); err != nil {
+		t.Fatal(err)
+	}
+	if alias, ok := adopted.GetGoveeDeviceAliasByMAC("0c:d6:c8:6b:82:c6:2a:7c"); !ok || alias != "Other" {
+		t.Errorf("adopted device not found by its MAC: %q %v", alias, ok)
+	}
+}
+
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	var document struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &document); err != nil {
+		t.Fatalf("invalid openapi.json: %s", err)
+	}
+	if len(document.Servers) != 1 {
+		t.Fatalf("expected a single server in openapi.json, got %v", document.Servers)
+	}
+
+	var documented []string
+	for path, operations := range document.Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+strings.TrimSuffix(document.Servers[0].URL+path, "/"))
+		}
+	}
+	var registered []string
+	for _, route := range (&HTTPServer{}).routes() {
+		if route.Pattern == "GET /api/v1/openapi.json" {
+			continue
+		}
+		registered = append(registered, strings.TrimSuffix(strings.TrimSuffix(route.Pattern, "{$}"), "/"))
+	}
+	slices.Sort(documented)
+	slices.Sort(registered)
+	if !slices.Equal(documented, registered) {
+		t.Errorf("openapi.json paths do not match the routes:\ndocumented: %v\nregistered: %v", documented, registered)
+	}
+}
+
+func TestHTTPServerReportsBusyAddress(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	s := NewHTTPServer(Configuration{HTTP: HTTPConfiguration{Address: busy.Addr().String()}}, nil, nil, nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Start(t.Context())
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected an error listening on a busy address")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("busy address not reported before shutdown")
+	}
+}
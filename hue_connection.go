@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,15 +32,18 @@ type HueConnection struct {
 	dialSensorsStatuses     sync.Map
 
 	lightsStatuses map[string]LightStatus
+	groupsStatuses map[string]LightStatus
 
-	govee     *GoveeConnection
-	twinkly   *TwinklyConnection
-	switchbot *SwitchbotConnection
-	wled      *WledConnection
+	resourceIDsMutex struct{ sync.RWMutex }
+	resourceIDs      map[HueSyncSource]int // v1 API IDs of lights and groups, needed to write their state
+
+	dispatcher *Dispatcher
+	wled       *WledConnection // Used to retrieve the current brightness
 
 	buttonPressedEventQueue  chan ButtonPressedEvent
 	presenceSensorEventQueue chan PresenceSensorEvent
 	lightChangeEventQueue    chan LightChangeEvent
+	sceneRecallEventQueue    chan SceneRecallEvent
 }
 
 type ButtonPressedEvent struct {
@@ -51,10 +56,12 @@ type PresenceSensorEvent struct {
 	Presence   bool
 }
 
+// LightChangeEvent is emitted only when something actually changed: Status carries every attribute,
+// Changes tells which of them have to be synced
 type LightChangeEvent struct {
-	DeviceName    string
-	LastStatus    LightStatus
-	CurrentStatus LightStatus
+	Source  HueSyncSource
+	Status  LightStatus
+	Changes LightChanges
 }
 
 func NewHueConnection(
@@ -69,6 +76,8 @@ func NewHueConnection(
 		dialRotariesStatuses: make(map[string]DialRotaryStatus),
 
 		lightsStatuses: make(map[string]LightStatus),
+		groupsStatuses: make(map[string]LightStatus),
+		resourceIDs:    make(map[HueSyncSource]int),
 
 		bridgeIP:       bridgeIP,
 		bridgeUsername: bridgeUsername,
@@ -76,154 +85,125 @@ func NewHueConnection(
 		buttonPressedEventQueue:  make(chan ButtonPressedEvent, 100),
 		presenceSensorEventQueue: make(chan PresenceSensorEvent, 100),
 		lightChangeEventQueue:    make(chan LightChangeEvent, 100),
+		sceneRecallEventQueue:    make(chan SceneRecallEvent, 100),
 	}
 }
 
+// Start polls the bridge and dispatches the resulting messages until ctx is cancelled.
+// It returns once every goroutine it spawned has stopped.
 func (h *HueConnection) Start(
 	ctx context.Context,
 	configuration Configuration,
-	govee *GoveeConnection,
-	twinkly *TwinklyConnection,
-	switchbot *SwitchbotConnection,
+	dispatcher *Dispatcher,
 	wled *WledConnection,
 ) {
 	// TODO: Implement action in case the bridge IP is empty
 	// TODO: Implement action in case the bridge username is empty
 
-	bridge := huego.New(h.bridgeIP, h.bridgeUsername)
+	// huego adds the scheme to the host on the first request, which is a data race once the bridge is shared
+	host := h.bridgeIP
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	bridge := huego.New(host, h.bridgeUsername)
 	h.bridge = bridge
 
-	h.govee = govee
-	h.twinkly = twinkly
-	h.switchbot = switchbot
+	h.dispatcher = dispatcher
 	h.wled = wled
 
-	go h.periodicallyPollSensors(ctx, configuration)
+	var wg sync.WaitGroup
+	defer wg.Wait()
 
+	wg.Add(1)
 	go func() {
-		for event := range h.buttonPressedEventQueue {
-
-			goveeMessages, twinklyMessages, switchbotMessages, wledMessages := configuration.GetMessagesToDispatchOnHueTapDialButtonPressed(event.DeviceName, event.Button, h.wled)
-
-			for _, message := range goveeMessages {
-				if err := h.govee.SendMsg(message.Device, message.Data); err != nil {
-					log.Err(err).Msg("error sending message")
-				}
-			}
+		defer wg.Done()
+		h.periodicallyPollSensors(ctx, configuration)
+	}()
 
-			for _, message := range twinklyMessages {
-				if err := h.twinkly.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending twinkly message")
-				}
-			}
+	if len(configuration.GetRequiredHueScenes()) > 0 {
+		eventStream := NewHueEventStream(h.bridgeIP, h.bridgeUsername)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			eventStream.Listen(ctx, func(event SceneRecallEvent) {
+				Events.Publish(EventTypeSceneRecalled, event.SceneName, map[string]string{"group": event.GroupName})
+				enqueueHueEvent(ctx, h.sceneRecallEventQueue, event)
+			})
+		}()
+	}
 
-			for _, message := range switchbotMessages {
-				if err := h.switchbot.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending switchbot message")
-				}
-			}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		consumeHueEvents(ctx, h.sceneRecallEventQueue, func(event SceneRecallEvent) {
+			goveeMessages, twinklyMessages, switchbotMessages, wledMessages := configuration.GetMessagesToDispatchOnHueSceneRecall(event.SceneName, event.GroupName, h.wled)
 
-			for _, message := range wledMessages {
-				if err := h.wled.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending wled message")
-				}
-			}
-		}
+			h.dispatcher.Dispatch(ctx, fmt.Sprintf("scene %s recalled in %s", event.SceneName, event.GroupName), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+		})
 	}()
 
+	wg.Add(1)
 	go func() {
-		for event := range h.presenceSensorEventQueue {
-			goveeMessages, twinklyMessages, switchbotMessages, wledMessages := configuration.GetMessagesToDispatchOnHuePresenceSensorChange(event.DeviceName, event.Presence)
-
-			for _, message := range goveeMessages {
-				if err := h.govee.SendMsg(message.Device, message.Data); err != nil {
-					log.Err(err).Msg("error sending message")
-				}
-			}
+		defer wg.Done()
+		consumeHueEvents(ctx, h.buttonPressedEventQueue, func(event ButtonPressedEvent) {
 
-			for _, message := range twinklyMessages {
-				if err := h.twinkly.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending twinkly message")
-				}
-			}
-
-			for _, message := range switchbotMessages {
-				if err := h.switchbot.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending switchbot message")
-				}
-			}
+			goveeMessages, twinklyMessages, switchbotMessages, wledMessages := configuration.GetMessagesToDispatchOnHueTapDialButtonPressed(event.DeviceName, event.Button, h.wled)
 
-			for _, message := range wledMessages {
-				if err := h.wled.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending wled message")
-				}
-			}
-		}
+			h.dispatcher.Dispatch(ctx, fmt.Sprintf("button %d on dial %s", event.Button, event.DeviceName), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+		})
 	}()
 
+	wg.Add(1)
 	go func() {
-		for event := range h.lightChangeEventQueue {
-
-			lastStatus := event.LastStatus
-			currentStatus := event.CurrentStatus
-
-			var goveeMessages []GoveeMessage
-			var twinklyMessages []TwinklyMessage
-			var switchbotMessages []SwitchbotMessage
-			var wledMessages []WledMessage
-
-			if lastStatus.lastUpdate == nil {
-
-				log.Debug().Msgf("Light [%s] state changed to [on: %v, bri: %d, rgb:<%d,%d,%d>]", event.DeviceName, currentStatus.on, currentStatus.brightness, currentStatus.r, currentStatus.g, currentStatus.b)
-
-				goveeMessages, twinklyMessages, switchbotMessages, wledMessages = configuration.GetMessagesToDispatchOnHueLightOnOffChange(event.DeviceName, currentStatus.on)
-				goveeMessages = append(goveeMessages, configuration.GetMessagesToDispatchOnHueLightColorChange(event.DeviceName, currentStatus.r, currentStatus.g, currentStatus.b)...)
-
-			} else if !lastStatus.EqualsOn(currentStatus) {
-
-				log.Debug().Msgf("Light [%s] state changed to [on: %v]", event.DeviceName, currentStatus.on)
-				goveeMessages, twinklyMessages, switchbotMessages, wledMessages = configuration.GetMessagesToDispatchOnHueLightOnOffChange(event.DeviceName, currentStatus.on)
+		defer wg.Done()
+		consumeHueEvents(ctx, h.presenceSensorEventQueue, func(event PresenceSensorEvent) {
+			goveeMessages, twinklyMessages, switchbotMessages, wledMessages := configuration.GetMessagesToDispatchOnHuePresenceSensorChange(event.DeviceName, event.Presence)
 
-			} else if !lastStatus.EqualsBrightness(currentStatus) {
+			h.dispatcher.Dispatch(ctx, fmt.Sprintf("presence sensor %s", event.DeviceName), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+		})
+	}()
 
-				log.Debug().Msgf("Light [%s] state changed to [bri: %d]", event.DeviceName, currentStatus.brightness)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		consumeHueEvents(ctx, h.lightChangeEventQueue, func(event LightChangeEvent) {
 
-				goveeMessages, switchbotMessages, wledMessages = configuration.GetMessagesToDispatchOnHueLightBrightnessChange(event.DeviceName, currentStatus.brightness)
+			status := event.Status
 
-			} else if !lastStatus.EqualsColor(currentStatus) {
+			log.Debug().Msgf("Light [%s] state changed to [on: %v, bri: %d, rgb:<%d,%d,%d>] (changed on: %v, bri: %v, color: %v)",
+				event.Source, status.on, status.brightness, status.r, status.g, status.b,
+				event.Changes.On, event.Changes.Brightness, event.Changes.Color)
 
-				log.Debug().Msgf("Light [%s] state changed to [rgb:<%d,%d,%d>]", event.DeviceName, currentStatus.r, currentStatus.g, currentStatus.b)
+			Events.Publish(EventTypeLightChanged, event.Source.String(), status.ChangedState(event.Changes))
 
-				goveeMessages = configuration.GetMessagesToDispatchOnHueLightColorChange(event.DeviceName, currentStatus.r, currentStatus.g, currentStatus.b)
-			}
+			goveeMessages, twinklyMessages, switchbotMessages, wledMessages := configuration.GetMessagesToDispatchOnHueLightChange(event.Source, status, event.Changes)
 
-			for _, message := range goveeMessages {
-				if err := h.govee.SendMsg(message.Device, message.Data); err != nil {
-					log.Err(err).Msgf("error sending govee message to device %s", message.Device)
-				}
-			}
+			h.dispatcher.Dispatch(ctx, fmt.Sprintf("light %s sync", event.Source), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+		})
+	}()
 
-			for _, message := range twinklyMessages {
-				if err := h.twinkly.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending twinkly message")
-				}
-			}
+	h.pollState(ctx, configuration)
+}
 
-			for _, message := range switchbotMessages {
-				if err := h.switchbot.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending switchbot message")
-				}
-			}
+// enqueueHueEvent hands the event over to its consumer, unless ctx is done first: the consumers stop with ctx too
+func enqueueHueEvent[T any](ctx context.Context, queue chan<- T, event T) {
+	select {
+	case queue <- event:
+	case <-ctx.Done():
+	}
+}
 
-			for _, message := range wledMessages {
-				if err := h.wled.SendMsg(message); err != nil {
-					log.Err(err).Msg("error sending wled message")
-				}
-			}
+// consumeHueEvents handles the events of the queue until ctx is done. The queues are never closed:
+// their producers may still be running when ctx gets cancelled.
+func consumeHueEvents[T any](ctx context.Context, queue <-chan T, handle func(T)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-queue:
+			handle(event)
 		}
-	}()
-
-	h.pollState(ctx, configuration)
+	}
 }
 
 func (h *HueConnection) pollState(
@@ -231,14 +211,15 @@ func (h *HueConnection) pollState(
 	configuration Configuration,
 ) {
 	for {
-		time.Sleep(200 * time.Millisecond)
-
 		select {
 		case <-ctx.Done():
 			return
-		default:
+		case <-time.After(pollingDuration):
 			fullBridgeState, err := h.bridge.GetFullStateContext(ctx)
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				err = fmt.Errorf("error getting full state context: %w", err)
 				log.Err(err).Msg(err.Error())
 				continue
@@ -295,10 +276,12 @@ func (h *HueConnection) pollState(
 
 						buttonPressed := int(dialStatus.buttonEvent)
 
-						h.buttonPressedEventQueue <- ButtonPressedEvent{
+						Events.Publish(EventTypeButtonPressed, dial.Name, map[string]int{"button": buttonPressed})
+
+						enqueueHueEvent(ctx, h.buttonPressedEventQueue, ButtonPressedEvent{
 							DeviceName: dial.Name,
 							Button:     buttonPressed,
-						}
+						})
 					}
 				}
 				func() {
@@ -341,10 +324,12 @@ func (h *HueConnection) pollState(
 
 						log.Debug().Msgf("Presence sensor [%s] changed to [%v]", name, presence)
 
-						h.presenceSensorEventQueue <- PresenceSensorEvent{
+						Events.Publish(EventTypePresenceChanged, name, map[string]bool{"presence": presence})
+
+						enqueueHueEvent(ctx, h.presenceSensorEventQueue, PresenceSensorEvent{
 							DeviceName: name,
 							Presence:   presence,
-						}
+						})
 					} else if err != nil {
 						log.Err(err).Msgf("error checking presence sensor [%s] requirement", name)
 					}
@@ -388,6 +373,8 @@ func (h *HueConnection) pollState(
 							dialStatus.expectedRotation = expectedRotation
 
 							log.Info().Msgf("Dial rotary [%s] expected rotation changed to [%d]", name, expectedRotation)
+
+							Events.Publish(EventTypeDialRotated, name, map[string]int{"expected_rotation": expectedRotation})
 						}
 
 						dialStatus.lastUpdated = &lastUpdated
@@ -398,12 +385,14 @@ func (h *HueConnection) pollState(
 
 			lights := fullBridgeState["lights"].(map[string]interface{})
 
-			for _, rawLightValue := range lights {
+			for lightID, rawLightValue := range lights {
 				light := rawLightValue.(map[string]interface{})
 
 				rawDeviceName := light["name"]
 				deviceName := rawDeviceName.(string)
 
+				h.setResourceID(HueSyncSource{Name: deviceName}, lightID)
+
 				required := configuration.IsLightRequired(deviceName)
 
 				if required {
@@ -411,67 +400,198 @@ func (h *HueConnection) pollState(
 
 					lightState := light["state"].(map[string]interface{})
 
-					on := lightState["on"].(bool)
-
-					var rawBrightness float64 = 255
-					var brightness int = 100
-					brightnessAvailable := lightState["bri"] != nil
+					currentStatus := parseHueLightState(lightState)
+					currentStatus.allOn = currentStatus.on
+					if lightState["bri"] != nil {
+						Brightness.SetForDevice(deviceName, currentStatus.brightness)
+					}
 
-					if brightnessAvailable {
-						rawBrightness = lightState["bri"].(float64)
-						brightness = int(math.Max(math.Min((rawBrightness/255)*100, 100), 0))
-						Brightness.SetForDevice(deviceName, brightness)
+					changes := lightStatus.DetectChanges(currentStatus)
+					if !changes.Any() {
+						continue
 					}
 
-					var r, g, b uint8
-					colorAvailable := lightState["xy"] != nil
+					h.lightsStatuses[deviceName] = lightStatus.Merge(currentStatus, changes)
 
-					if colorAvailable {
-						xy := lightState["xy"].([]interface{})
-						x := xy[0].(float64)
-						y := xy[1].(float64)
-						r, g, b = xyToRGB(x, y, rawBrightness)
+					source := HueSyncSource{Name: deviceName}
+					if isHueEcho(source, currentStatus, changes) {
+						continue
 					}
 
-					now := time.Now()
-					currentStatus := LightStatus{
-						lastUpdate: &now,
-						on:         on,
-						brightness: brightness,
-						r:          r,
-						g:          g,
-						b:          b,
-					}
+					enqueueHueEvent(ctx, h.lightChangeEventQueue, LightChangeEvent{
+						Source:  source,
+						Status:  currentStatus,
+						Changes: changes,
+					})
+				}
+			}
 
-					h.lightsStatuses[deviceName] = currentStatus
+			groups, _ := fullBridgeState["groups"].(map[string]interface{})
 
-					h.lightChangeEventQueue <- LightChangeEvent{
-						DeviceName:    deviceName,
-						LastStatus:    lightStatus,
-						CurrentStatus: currentStatus,
-					}
+			for groupID, rawGroupValue := range groups {
+				group := rawGroupValue.(map[string]interface{})
+
+				groupName, _ := group["name"].(string)
+
+				h.setResourceID(HueSyncSource{Name: groupName, Group: true}, groupID)
+
+				if !configuration.IsHueGroupRequired(groupName) {
+					continue
+				}
+
+				groupStatus := h.groupsStatuses[groupName]
+
+				// The group "action" holds the last command sent to the whole group (bri, xy),
+				// while "state" tells whether any or all of its lights are on
+				groupAction, _ := group["action"].(map[string]interface{})
+				groupState, _ := group["state"].(map[string]interface{})
+
+				currentStatus := parseHueLightState(groupAction)
+				currentStatus.on, _ = groupState["any_on"].(bool)
+				currentStatus.allOn, _ = groupState["all_on"].(bool)
+
+				changes := groupStatus.DetectChanges(currentStatus)
+				if !changes.Any() {
+					continue
+				}
+
+				h.groupsStatuses[groupName] = groupStatus.Merge(currentStatus, changes)
+
+				source := HueSyncSource{Name: groupName, Group: true}
+				if isHueEcho(source, currentStatus, changes) {
+					continue
 				}
+
+				enqueueHueEvent(ctx, h.lightChangeEventQueue, LightChangeEvent{
+					Source:  source,
+					Status:  currentStatus,
+					Changes: changes,
+				})
 			}
 		}
 	}
 }
 
+func (h *HueConnection) setResourceID(source HueSyncSource, rawID string) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		return
+	}
+	h.resourceIDsMutex.Lock()
+	defer h.resourceIDsMutex.Unlock()
+	h.resourceIDs[source] = id
+}
+
+// SetHueState writes the state to a Hue light or group: the transition is performed natively by the bridge.
+// The write is recorded in SyncGuard before being sent, so that the next poll does not mistake it for a new change.
+func (h *HueConnection) SetHueState(ctx context.Context, source HueSyncSource, state DeviceState, transition time.Duration) error {
+	h.resourceIDsMutex.RLock()
+	id, ok := h.resourceIDs[source]
+	h.resourceIDsMutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: hue %s", ErrDeviceNotFound, source)
+	}
+
+	// "on" is always sent by huego: brightness and color are only written while the light is on
+	hueState := huego.State{
+		On:             true,
+		TransitionTime: hueTransitionTime(transition),
+	}
+	if state.On != nil {
+		hueState.On = *state.On
+	}
+	if hueState.On {
+		if state.Brightness != nil {
+			hueState.Bri = uint8(mapBrightness(*state.Brightness, []int{0, 100}, []int{1, 254}))
+		}
+		if state.Color != nil {
+			x, y := rgbToXY(uint8(state.Color.Red), uint8(state.Color.Green), uint8(state.Color.Blue))
+			hueState.Xy = []float32{float32(x), float32(y)}
+		}
+	}
+
+	SyncGuard.RecordWrite(hueLightGuardTarget(source), state)
+
+	log.Debug().Msgf("Setting Hue [%s] state to [on: %v, bri: %d, xy: %v]", source, hueState.On, hueState.Bri, hueState.Xy)
+
+	var err error
+	if source.Group {
+		_, err = h.bridge.SetGroupStateContext(ctx, id, hueState)
+	} else {
+		_, err = h.bridge.SetLightStateContext(ctx, id, hueState)
+	}
+	if err != nil {
+		return fmt.Errorf("error setting Hue %s state: %w", source, err)
+	}
+	return nil
+}
+
+// isHueEcho tells whether the change has just been written by us (see SyncGuard), in which case it must not trigger
+// the sync again. Otherwise the change is recorded as originated on the Hue side.
+func isHueEcho(source HueSyncSource, status LightStatus, changes LightChanges) bool {
+	target := hueLightGuardTarget(source)
+	if SyncGuard.IsEcho(target, status.ChangedState(changes)) {
+		log.Trace().Msgf("Ignoring echo of our own change on Hue [%s]", source)
+		return true
+	}
+	SyncGuard.RecordChange(target, ChangeOriginHue)
+	return false
+}
+
+// parseHueLightState reads on, brightness and color from a v1 light "state" or group "action" object
+func parseHueLightState(lightState map[string]interface{}) LightStatus {
+	on, _ := lightState["on"].(bool)
+
+	var rawBrightness float64 = 255
+	var brightness int = 100
+
+	if bri, ok := lightState["bri"].(float64); ok {
+		rawBrightness = bri
+		brightness = int(math.Max(math.Min((rawBrightness/255)*100, 100), 0))
+	}
+
+	var r, g, b uint8
+
+	var x, y float64
+
+	if xy, ok := lightState["xy"].([]interface{}); ok && len(xy) == 2 {
+		x = xy[0].(float64)
+		y = xy[1].(float64)
+		r, g, b = xyToRGB(x, y, rawBrightness)
+	}
+
+	now := time.Now()
+	return LightStatus{
+		lastUpdate: &now,
+		on:         on,
+		brightness: brightness,
+		x:          x,
+		y:          y,
+		r:          r,
+		g:          g,
+		b:          b,
+	}
+}
+
 func (h *HueConnection) periodicallyPollSensors(ctx context.Context, configuration Configuration) {
-	h.retrieveSensors(true, configuration)
+	h.retrieveSensors(ctx, true, configuration)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-time.After(5 * time.Second):
-			h.retrieveSensors(false, configuration)
+			h.retrieveSensors(ctx, false, configuration)
 		}
 	}
 }
 
-func (h *HueConnection) retrieveSensors(firstLook bool, configuration Configuration) {
-	sensors, err := h.bridge.GetSensors()
+func (h *HueConnection) retrieveSensors(ctx context.Context, firstLook bool, configuration Configuration) {
+	sensors, err := h.bridge.GetSensorsContext(ctx)
 	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
 		log.Error().Err(err).Msg("error retrieving sensors")
 		return
 	}
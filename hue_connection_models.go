@@ -1,6 +1,16 @@
 package main
 
-import "time"
+import (
+	"math"
+	"time"
+)
+
+const (
+	// Changes within the dead-bands are considered noise reported by the bridge (e.g. xy jittering
+	// while a light fades) and do not produce any event
+	lightBrightnessDeadBand = 1     // Percentage points
+	lightXYDeadBand         = 0.002 // CIE xy distance
+)
 
 type DialStatus struct {
 	lastUpdate  *time.Time
@@ -15,22 +25,76 @@ type DialRotaryStatus struct {
 type LightStatus struct {
 	lastUpdate *time.Time
 	on         bool
+	allOn      bool // Only meaningful for groups: for lights it matches "on"
 	brightness int
+	x          float64
+	y          float64
 	r          uint8
 	g          uint8
 	b          uint8
 }
 
 func (s LightStatus) EqualsOn(other LightStatus) bool {
-	return s.on == other.on
+	return s.on == other.on && s.allOn == other.allOn
 }
 
 func (s LightStatus) EqualsBrightness(other LightStatus) bool {
-	return s.brightness == other.brightness
+	return int(math.Abs(float64(s.brightness-other.brightness))) <= lightBrightnessDeadBand
 }
 
 func (s LightStatus) EqualsColor(other LightStatus) bool {
-	return s.r == other.r && s.g == other.g && s.b == other.b
+	return math.Hypot(s.x-other.x, s.y-other.y) <= lightXYDeadBand
+}
+
+// LightChanges tells which attributes of a light (or group) changed since the last event
+type LightChanges struct {
+	On         bool
+	Brightness bool
+	Color      bool
+}
+
+func (c LightChanges) Any() bool {
+	return c.On || c.Brightness || c.Color
+}
+
+// DetectChanges compares the last reported status with the current one. The first status ever observed
+// reports every attribute as changed, so that the synced devices get aligned at startup.
+// Brightness and color changes are held back while the light is off: they get reported once it turns back on.
+func (s LightStatus) DetectChanges(current LightStatus) LightChanges {
+	var changes LightChanges
+	if s.lastUpdate == nil {
+		changes = LightChanges{On: true, Brightness: true, Color: true}
+	} else {
+		changes = LightChanges{
+			On:         !s.EqualsOn(current),
+			Brightness: !s.EqualsBrightness(current),
+			Color:      !s.EqualsColor(current),
+		}
+	}
+	if !current.on {
+		changes.Brightness = false
+		changes.Color = false
+	}
+	return changes
+}
+
+// Merge returns the status to compare the next observations with: attributes whose change has been
+// swallowed by the dead-band keep their previous value, so that slow drifts still get reported eventually
+func (s LightStatus) Merge(current LightStatus, changes LightChanges) LightStatus {
+	merged := s
+	merged.lastUpdate = current.lastUpdate
+	if changes.On {
+		merged.on = current.on
+		merged.allOn = current.allOn
+	}
+	if changes.Brightness {
+		merged.brightness = current.brightness
+	}
+	if changes.Color {
+		merged.x, merged.y = current.x, current.y
+		merged.r, merged.g, merged.b = current.r, current.g, current.b
+	}
+	return merged
 }
 
 type PresenceSensorStatus struct {
@@ -44,3 +108,18 @@ type DialSensorStatus struct {
 	expectedEventDuration int
 	lastUpdated           *time.Time
 }
+
+// ChangedState returns only the changed attributes, in the same format used to write devices
+func (s LightStatus) ChangedState(changes LightChanges) DeviceState {
+	var state DeviceState
+	if changes.On {
+		state.On = valToPtr(s.on)
+	}
+	if changes.Brightness {
+		state.Brightness = valToPtr(s.brightness)
+	}
+	if changes.Color {
+		state.Color = &deviceColor{Red: int(s.r), Green: int(s.g), Blue: int(s.b)}
+	}
+	return state
+}
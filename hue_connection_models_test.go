@@ -0,0 +1,91 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLightStatusDetectChanges(t *testing.T) {
+	now := time.Now()
+	previous := LightStatus{lastUpdate: &now, on: true, allOn: true, brightness: 50, x: 0.3, y: 0.3}
+	with := func(update func(*LightStatus)) LightStatus {
+		status := previous
+		update(&status)
+		return status
+	}
+
+	for _, test := range []struct {
+		name     string
+		previous LightStatus
+		current  LightStatus
+		expected LightChanges
+	}{
+		{"first observation", LightStatus{}, previous, LightChanges{On: true, Brightness: true, Color: true}},
+		{"first observation while off", LightStatus{}, with(func(s *LightStatus) { s.on, s.allOn = false, false }), LightChanges{On: true}},
+		{"unchanged", previous, previous, LightChanges{}},
+		{"brightness noise", previous, with(func(s *LightStatus) { s.brightness = 51 }), LightChanges{}},
+		{"brightness over the dead-band", previous, with(func(s *LightStatus) { s.brightness = 52 }), LightChanges{Brightness: true}},
+		{"xy noise", previous, with(func(s *LightStatus) { s.x, s.y = 0.301, 0.301 }), LightChanges{}},
+		{"xy over the dead-band", previous, with(func(s *LightStatus) { s.x = 0.303 }), LightChanges{Color: true}},
+		{"turned off", previous, with(func(s *LightStatus) { s.on, s.allOn = false, false }), LightChanges{On: true}},
+		{"turned off while dimming", previous, with(func(s *LightStatus) { s.on, s.allOn, s.brightness = false, false, 10 }), LightChanges{On: true}},
+		{"group partially on", previous, with(func(s *LightStatus) { s.allOn = false }), LightChanges{On: true}},
+		{
+			"turned on with a new color",
+			with(func(s *LightStatus) { s.on, s.allOn = false, false }),
+			with(func(s *LightStatus) { s.x = 0.5 }),
+			LightChanges{On: true, Color: true},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if changes := test.previous.DetectChanges(test.current); changes != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, changes)
+			}
+		})
+	}
+}
+
+func TestLightStatusMerge(t *testing.T) {
+	before := time.Now()
+	after := before.Add(time.Second)
+	previous := LightStatus{lastUpdate: &before, on: true, allOn: true, brightness: 50, x: 0.3, y: 0.3, r: 10, g: 20, b: 30}
+	current := LightStatus{lastUpdate: &after, on: false, allOn: false, brightness: 51, x: 0.4, y: 0.2, r: 40, g: 50, b: 60}
+
+	for _, test := range []struct {
+		name     string
+		changes  LightChanges
+		expected LightStatus
+	}{
+		{"nothing changed", LightChanges{}, LightStatus{lastUpdate: &after, on: true, allOn: true, brightness: 50, x: 0.3, y: 0.3, r: 10, g: 20, b: 30}},
+		{"on changed", LightChanges{On: true}, LightStatus{lastUpdate: &after, on: false, allOn: false, brightness: 50, x: 0.3, y: 0.3, r: 10, g: 20, b: 30}},
+		{"brightness changed", LightChanges{Brightness: true}, LightStatus{lastUpdate: &after, on: true, allOn: true, brightness: 51, x: 0.3, y: 0.3, r: 10, g: 20, b: 30}},
+		{"color changed", LightChanges{Color: true}, LightStatus{lastUpdate: &after, on: true, allOn: true, brightness: 50, x: 0.4, y: 0.2, r: 40, g: 50, b: 60}},
+		{"everything changed", LightChanges{On: true, Brightness: true, Color: true}, current},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if merged := previous.Merge(current, test.changes); merged != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, merged)
+			}
+		})
+	}
+}
+
+func TestLightStatusSlowDrift(t *testing.T) {
+	// Steps within the dead-band are not reported one by one, but add up until they cross it
+	now := time.Now()
+	status := LightStatus{lastUpdate: &now, on: true, allOn: true, brightness: 50}
+	var reported []int
+	for brightness := 51; brightness <= 56; brightness++ {
+		current := status
+		current.brightness = brightness
+		changes := status.DetectChanges(current)
+		if changes.Brightness {
+			reported = append(reported, brightness)
+		}
+		status = status.Merge(current, changes)
+	}
+	if !slices.Equal(reported, []int{52, 54, 56}) {
+		t.Errorf("unexpected reported brightness: %v", reported)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HueEventStream follows the Hue API v2 eventstream, which (unlike the v1 API polled by HueConnection)
+// reports when a scene gets recalled
+type HueEventStream struct {
+	bridgeIP       string
+	bridgeUsername string
+
+	client *http.Client
+
+	scenesMutex struct{ sync.RWMutex }
+	scenes      map[string]HueV2Scene // Key is the v2 scene ID
+	groupNames  map[string]string     // Key is the v2 room/zone ID
+}
+
+type SceneRecallEvent struct {
+	SceneName string
+	GroupName string
+}
+
+func NewHueEventStream(bridgeIP, bridgeUsername string) *HueEventStream {
+	return &HueEventStream{
+		bridgeIP:       bridgeIP,
+		bridgeUsername: bridgeUsername,
+		client: &http.Client{
+			Transport: &http.Transport{
+				// The bridge uses a self-signed certificate
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+		scenes:     make(map[string]HueV2Scene),
+		groupNames: make(map[string]string),
+	}
+}
+
+// Listen keeps the eventstream open, reconnecting on failures, until the context is cancelled
+func (s *HueEventStream) Listen(ctx context.Context, onSceneRecalled func(SceneRecallEvent)) {
+	for ctx.Err() == nil {
+		if err := s.refreshResources(ctx); err != nil {
+			log.Err(err).Msgf("error retrieving Hue scenes: %s", err)
+		}
+
+		if err := s.stream(ctx, onSceneRecalled); err != nil && ctx.Err() == nil {
+			log.Err(err).Msgf("Hue eventstream interrupted: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (s *HueEventStream) newRequest(ctx context.Context, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s%s", s.bridgeIP, path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("hue-application-key", s.bridgeUsername)
+	return req, nil
+}
+
+func getHueV2Resources[T any](ctx context.Context, s *HueEventStream, resourceType string) ([]T, error) {
+	req, err := s.newRequest(ctx, "/clip/v2/resource/"+resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request for %s failed with status code %d", resourceType, resp.StatusCode)
+	}
+
+	var response HueV2ResourceResponse[T]
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding %s response: %w", resourceType, err)
+	}
+	if len(response.Errors) > 0 {
+		return nil, fmt.Errorf("error retrieving %s: %s", resourceType, response.Errors[0].Description)
+	}
+
+	return response.Data, nil
+}
+
+func (s *HueEventStream) refreshResources(ctx context.Context) error {
+	scenes, err := getHueV2Resources[HueV2Scene](ctx, s, "scene")
+	if err != nil {
+		return err
+	}
+
+	// Scenes deleted while the eventstream was down are dropped along with the rest
+	scenesByID := make(map[string]HueV2Scene, len(scenes))
+	for _, scene := range scenes {
+		scenesByID[scene.ID] = scene
+	}
+
+	groupNames := make(map[string]string)
+	for _, resourceType := range []string{"room", "zone"} {
+		groups, err := getHueV2Resources[HueV2Group](ctx, s, resourceType)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			groupNames[group.ID] = group.Metadata.Name
+		}
+	}
+
+	s.scenesMutex.Lock()
+	defer s.scenesMutex.Unlock()
+	s.scenes = scenesByID
+	s.groupNames = groupNames
+
+	return nil
+}
+
+func (s *HueEventStream) stream(ctx context.Context, onSceneRecalled func(SceneRecallEvent)) error {
+	req, err := s.newRequest(ctx, "/eventstream/clip/v2")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error opening eventstream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("eventstream failed with status code %d", resp.StatusCode)
+	}
+
+	log.Debug().Msgf("Listening to Hue eventstream")
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		data, found := strings.CutPrefix(line, "data:")
+		if !found {
+			continue
+		}
+
+		var events []HueV2Event
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &events); err != nil {
+			log.Err(err).Msgf("error decoding Hue event: %s", err)
+			continue
+		}
+
+		for _, event := range events {
+			for _, element := range event.Data {
+				if element.Type != "scene" {
+					continue
+				}
+				if recall, ok := s.handleSceneEvent(event.Type, element); ok {
+					log.Debug().Msgf("Hue scene [%s] recalled in [%s]", recall.SceneName, recall.GroupName)
+					onSceneRecalled(recall)
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading eventstream: %w", err)
+	}
+	return fmt.Errorf("eventstream closed by the bridge")
+}
+
+// handleSceneEvent keeps the scene cache up to date and tells whether the event is a recall
+func (s *HueEventStream) handleSceneEvent(eventType string, element HueV2EventElement) (SceneRecallEvent, bool) {
+	s.scenesMutex.Lock()
+	defer s.scenesMutex.Unlock()
+
+	if eventType == "delete" {
+		delete(s.scenes, element.ID)
+		return SceneRecallEvent{}, false
+	}
+
+	scene := s.scenes[element.ID]
+	scene.ID = element.ID
+	if element.Metadata != nil {
+		scene.Metadata = *element.Metadata
+	}
+	if element.Group != nil {
+		scene.Group = *element.Group
+	}
+
+	var status HueV2SceneStatus
+	if len(element.Status) > 0 {
+		if err := json.Unmarshal(element.Status, &status); err != nil {
+			log.Err(err).Msgf("error decoding Hue scene status: %s", err)
+		}
+	}
+	previousStatus := scene.Status
+	if len(element.Status) > 0 {
+		scene.Status = &status
+	}
+	s.scenes[element.ID] = scene
+
+	if eventType != "update" || len(element.Status) == 0 || scene.Metadata.Name == "" {
+		return SceneRecallEvent{}, false
+	}
+
+	recalled := status.Active != "" && status.Active != "inactive"
+	if status.LastRecall != "" {
+		recalled = previousStatus == nil || previousStatus.LastRecall != status.LastRecall
+	}
+	if !recalled {
+		return SceneRecallEvent{}, false
+	}
+
+	return SceneRecallEvent{
+		SceneName: scene.Metadata.Name,
+		GroupName: s.groupNames[scene.Group.RID],
+	}, true
+}
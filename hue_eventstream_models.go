@@ -0,0 +1,57 @@
+package main
+
+import "encoding/json"
+
+// Models of the Hue API v2 (CLIP v2), only the fields needed to follow scene recalls
+
+type HueV2ResourceResponse[T any] struct {
+	Errors []HueV2Error `json:"errors"`
+	Data   []T          `json:"data"`
+}
+
+type HueV2Error struct {
+	Description string `json:"description"`
+}
+
+type HueV2ResourceIdentifier struct {
+	RID   string `json:"rid"`
+	RType string `json:"rtype"`
+}
+
+type HueV2Metadata struct {
+	Name string `json:"name"`
+}
+
+type HueV2Scene struct {
+	ID       string                  `json:"id"`
+	Metadata HueV2Metadata           `json:"metadata"`
+	Group    HueV2ResourceIdentifier `json:"group"`
+	Status   *HueV2SceneStatus       `json:"status,omitempty"`
+}
+
+type HueV2SceneStatus struct {
+	Active     string `json:"active"` // "inactive", "static" or "dynamic_palette"
+	LastRecall string `json:"last_recall,omitempty"`
+}
+
+type HueV2Group struct {
+	ID       string        `json:"id"`
+	Metadata HueV2Metadata `json:"metadata"`
+}
+
+// HueV2Event is a single entry of the "data: [...]" payload sent on the eventstream
+type HueV2Event struct {
+	ID           string              `json:"id"`
+	Type         string              `json:"type"` // "add", "update", "delete", "error"
+	CreationTime string              `json:"creationtime"`
+	Data         []HueV2EventElement `json:"data"`
+}
+
+type HueV2EventElement struct {
+	ID       string                   `json:"id"`
+	IDV1     string                   `json:"id_v1"`
+	Type     string                   `json:"type"`
+	Metadata *HueV2Metadata           `json:"metadata,omitempty"`
+	Group    *HueV2ResourceIdentifier `json:"group,omitempty"`
+	Status   json.RawMessage          `json:"status,omitempty"` // Shape depends on the resource type
+}
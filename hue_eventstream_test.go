@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestHueEventStream knows "Relax", reporting last_recall, and "Read", from a bridge reporting only active
+func newTestHueEventStream() *HueEventStream {
+	s := NewHueEventStream("", "")
+	s.scenes["relax"] = HueV2Scene{
+		ID:       "relax",
+		Metadata: HueV2Metadata{Name: "Relax"},
+		Group:    HueV2ResourceIdentifier{RID: "living", RType: "room"},
+		Status:   &HueV2SceneStatus{Active: "inactive", LastRecall: "2024-01-01T10:00:00.000Z"},
+	}
+	s.scenes["read"] = HueV2Scene{
+		ID:       "read",
+		Metadata: HueV2Metadata{Name: "Read"},
+		Group:    HueV2ResourceIdentifier{RID: "living", RType: "room"},
+		Status:   &HueV2SceneStatus{Active: "inactive"},
+	}
+	s.groupNames["living"] = "Living room"
+	return s
+}
+
+func TestHueEventStreamHandleSceneEvent(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		eventType string
+		element   string
+		recalled  bool
+		cached    string // Name of the scene in the cache after the event, empty when removed
+	}{
+		{"new last_recall", "update", `{"id": "relax", "status": {"active": "static", "last_recall": "2024-01-01T11:00:00.000Z"}}`, true, "Relax"},
+		{"same last_recall", "update", `{"id": "relax", "status": {"active": "static", "last_recall": "2024-01-01T10:00:00.000Z"}}`, false, "Relax"},
+		{"inactive with a new last_recall", "update", `{"id": "relax", "status": {"active": "inactive", "last_recall": "2024-01-01T11:00:00.000Z"}}`, true, "Relax"},
+		{"active without last_recall", "update", `{"id": "read", "status": {"active": "dynamic_palette"}}`, true, "Read"},
+		{"inactive without last_recall", "update", `{"id": "read", "status": {"active": "inactive"}}`, false, "Read"},
+		{"renamed", "update", `{"id": "relax", "metadata": {"name": "Chill"}}`, false, "Chill"},
+		{"unknown scene", "update", `{"id": "other", "status": {"active": "static", "last_recall": "2024-01-01T11:00:00.000Z"}}`, false, ""},
+		{"added", "add", `{"id": "other", "metadata": {"name": "Other"}, "status": {"active": "static", "last_recall": "2024-01-01T11:00:00.000Z"}}`, false, "Other"},
+		{"deleted", "delete", `{"id": "relax"}`, false, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestHueEventStream()
+			var element HueV2EventElement
+			if err := json.Unmarshal([]byte(test.element), &element); err != nil {
+				t.Fatal(err)
+			}
+
+			recall, recalled := s.handleSceneEvent(test.eventType, element)
+			if recalled != test.recalled {
+				t.Errorf("expected recalled %v, got %v", test.recalled, recalled)
+			}
+			if recalled && (recall.SceneName != test.cached || recall.GroupName != "Living room") {
+				t.Errorf("unexpected recall: %+v", recall)
+			}
+			if scene := s.scenes[element.ID]; scene.Metadata.Name != test.cached {
+				t.Errorf("expected %q in the cache, got %+v", test.cached, scene)
+			}
+		})
+	}
+}
+
+func TestHueEventStreamRefreshReplacesScenes(t *testing.T) {
+	bridge := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/clip/v2/resource/scene":
+			w.Write([]byte(`{"errors": [], "data": [{"id": "read", "metadata": {"name": "Read"}, "group": {"rid": "office", "rtype": "room"}}]}`))
+		case "/clip/v2/resource/room":
+			w.Write([]byte(`{"errors": [], "data": [{"id": "office", "metadata": {"name": "Office"}}]}`))
+		default:
+			w.Write([]byte(`{"errors": [], "data": []}`))
+		}
+	}))
+	defer bridge.Close()
+
+	s := newTestHueEventStream()
+	s.bridgeIP = strings.TrimPrefix(bridge.URL, "https://")
+	if err := s.refreshResources(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.scenes) != 1 || s.scenes["read"].Group.RID != "office" {
+		t.Errorf("scenes not replaced: %+v", s.scenes)
+	}
+	if len(s.groupNames) != 1 || s.groupNames["office"] != "Office" {
+		t.Errorf("groups not replaced: %+v", s.groupNames)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rs/zerolog"
+)
+
+const logPaneMaxEntries = 5000
+
+// logPaneTrimBatch entries beyond the maximum are dropped at once: dropping them rebuilds the content
+const logPaneTrimBatch = logPaneMaxEntries / 10
+
+// logPaneLevels are the minimum levels the pane cycles through
+var logPaneLevels = []zerolog.Level{
+	zerolog.TraceLevel,
+	zerolog.DebugLevel,
+	zerolog.InfoLevel,
+	zerolog.WarnLevel,
+	zerolog.ErrorLevel,
+}
+
+// logPaneEntry is rendered the first time it is needed: most entries (e.g. trace ones) are never shown
+type logPaneEntry struct {
+	Level    zerolog.Level
+	Line     string // As received
+	Rendered bool
+	Text     string // Rendered with colors
+	Plain    string // Rendered without colors, for saving
+	Lower    string // Plain in lowercase, for the filters
+}
+
+// LogPaneModel is a reusable component that displays the logs, filtered by level, text and device.
+// Every entry is kept whatever the filters, so that changing them shows the past logs too.
+// The shown lines are appended as they come: the whole content is rebuilt only when the filters change.
+type LogPaneModel struct {
+	viewport viewport.Model
+	entries  []logPaneEntry
+	content  strings.Builder // Text of the entries matching the filters
+	shown    int             // Lines in content
+
+	level         zerolog.Level
+	filter        textinput.Model
+	editingFilter bool
+	device        string // Only the entries mentioning it are shown, all of them when empty
+	paused        bool
+	missed        int    // Entries received while paused
+	feedback      string // Outcome of the last save
+	focused       bool
+
+	colored zerolog.ConsoleWriter
+	plain   zerolog.ConsoleWriter
+	buffer  *bytes.Buffer
+
+	width  int
+	height int
+}
+
+// NewLogPaneModel creates a new log pane showing the entries from the info level
+func NewLogPaneModel() *LogPaneModel {
+	filter := textinput.New()
+	filter.Prompt = "/"
+	filter.Placeholder = "filter"
+
+	buffer := &bytes.Buffer{}
+	return &LogPaneModel{
+		viewport: viewport.New(0, 0),
+		level:    zerolog.InfoLevel,
+		filter:   filter,
+		colored:  zerolog.ConsoleWriter{Out: buffer, TimeFormat: time.TimeOnly},
+		plain:    zerolog.ConsoleWriter{Out: buffer, TimeFormat: time.TimeOnly, NoColor: true},
+		buffer:   buffer,
+	}
+}
+
+func (m *LogPaneModel) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	// Border, title and footer take 4 columns and 4 rows
+	m.viewport.Width = max(0, width-4)
+	m.viewport.Height = max(1, height-4)
+	m.refresh(true)
+}
+
+// SetFocused highlights the pane when the keys go to it
+func (m *LogPaneModel) SetFocused(focused bool) {
+	m.focused = focused
+}
+
+// Editing tells whether the filter is being typed: the keys must all go to the pane
+func (m *LogPaneModel) Editing() bool {
+	return m.editingFilter
+}
+
+// Add stores a zerolog JSON entry. Lines that are not JSON (e.g. from a child process) are kept as they are.
+func (m *LogPaneModel) Add(level zerolog.Level, line string) {
+	m.entries = append(m.entries, logPaneEntry{Level: level, Line: line})
+	trimmed := len(m.entries) > logPaneMaxEntries+logPaneTrimBatch
+	if trimmed {
+		m.entries = slices.Delete(m.entries, 0, len(m.entries)-logPaneMaxEntries)
+	}
+	entry := &m.entries[len(m.entries)-1]
+
+	if m.paused {
+		if m.matches(entry) {
+			m.missed++
+		}
+		return
+	}
+	if trimmed {
+		m.refresh(m.viewport.AtBottom())
+		return
+	}
+	if m.matches(entry) {
+		gotoBottom := m.viewport.AtBottom()
+		m.appendLine(entry.Text)
+		m.viewport.SetContent(m.content.String())
+		if gotoBottom {
+			m.viewport.GotoBottom()
+		}
+	}
+}
+
+// render fills the rendered fields of the entry, if not done yet
+func (m *LogPaneModel) render(entry *logPaneEntry) {
+	if entry.Rendered {
+		return
+	}
+	entry.Rendered = true
+	if json.Valid([]byte(entry.Line)) {
+		entry.Text = m.renderWith(m.colored, entry.Line)
+		entry.Plain = m.renderWith(m.plain, entry.Line)
+	} else {
+		entry.Text = strings.TrimRight(entry.Line, "\n")
+		entry.Plain = entry.Text
+	}
+	entry.Lower = strings.ToLower(entry.Plain)
+}
+
+func (m *LogPaneModel) renderWith(writer zerolog.ConsoleWriter, line string) string {
+	m.buffer.Reset()
+	if _, err := writer.Write([]byte(line)); err != nil {
+		return strings.TrimRight(line, "\n")
+	}
+	return strings.TrimRight(m.buffer.String(), "\n")
+}
+
+func (m *LogPaneModel) matches(entry *logPaneEntry) bool {
+	// Lines without a level (child processes output) are shown at every level
+	if entry.Level != zerolog.NoLevel && entry.Level < m.level {
+		return false
+	}
+	m.render(entry)
+	if m.device != "" && !strings.Contains(entry.Lower, strings.ToLower(m.device)) {
+		return false
+	}
+	if query := strings.ToLower(strings.TrimSpace(m.filter.Value())); query != "" && !strings.Contains(entry.Lower, query) {
+		return false
+	}
+	return true
+}
+
+func (m *LogPaneModel) appendLine(text string) {
+	if m.shown > 0 {
+		m.content.WriteByte('\n')
+	}
+	m.content.WriteString(text)
+	m.shown++
+}
+
+// refresh rebuilds the content of the viewport from the entries matching the filters
+func (m *LogPaneModel) refresh(gotoBottom bool) {
+	m.content.Reset()
+	m.shown = 0
+	for i := range m.entries {
+		if m.matches(&m.entries[i]) {
+			m.appendLine(m.entries[i].Text)
+		}
+	}
+	m.viewport.SetContent(m.content.String())
+	if gotoBottom {
+		m.viewport.GotoBottom()
+	}
+}
+
+// save writes every entry of the buffer, whatever the filters, to a new file in the working directory
+func (m *LogPaneModel) save() {
+	filename := fmt.Sprintf("logs-%s.log", time.Now().Format("20060102-150405"))
+
+	var b strings.Builder
+	for i := range m.entries {
+		entry := &m.entries[i]
+		m.render(entry)
+		b.WriteString(entry.Plain)
+		b.WriteString("\n")
+	}
+	if err := os.WriteFile(filename, []byte(b.String()), 0600); err != nil {
+		m.feedback = fmt.Sprintf("error saving the logs: %s", err)
+		return
+	}
+	m.feedback = fmt.Sprintf("%d entries saved to %s", len(m.entries), filename)
+}
+
+// Init implements tea.Model
+func (m *LogPaneModel) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model
+func (m *LogPaneModel) Update(msg tea.Msg) (*LogPaneModel, tea.Cmd) {
+	var cmd tea.Cmd
+
+	if m.editingFilter {
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch msg.String() {
+			case "enter":
+				m.editingFilter = false
+				m.filter.Blur()
+				return m, nil
+			case "esc":
+				m.editingFilter = false
+				m.filter.Blur()
+				m.filter.SetValue("")
+				m.refresh(true)
+				return m, nil
+			}
+		}
+		m.filter, cmd = m.filter.Update(msg)
+		m.refresh(true)
+		return m, cmd
+	}
+
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	switch keyMsg.String() {
+	case "/":
+		m.editingFilter = true
+		return m, m.filter.Focus()
+	case "v":
+		index := slices.Index(logPaneLevels, m.level)
+		m.level = logPaneLevels[(index+1)%len(logPaneLevels)]
+		m.refresh(true)
+		return m, nil
+	case "d":
+		m.device = m.nextDevice()
+		m.refresh(true)
+		return m, nil
+	case "c":
+		m.device = ""
+		m.filter.SetValue("")
+		m.refresh(true)
+		return m, nil
+	case " ":
+		m.paused = !m.paused
+		if !m.paused {
+			m.missed = 0
+			m.refresh(true)
+		}
+		return m, nil
+	case "w":
+		m.save()
+		return m, nil
+	case "g", "home":
+		m.viewport.GotoTop()
+		return m, nil
+	case "G", "end":
+		m.viewport.GotoBottom()
+		return m, nil
+	}
+
+	m.viewport, cmd = m.viewport.Update(keyMsg)
+	return m, cmd
+}
+
+// nextDevice cycles through the registered devices, then back to all of them
+func (m *LogPaneModel) nextDevice() string {
+	devices := make([]string, 0)
+	for device := range Status.GetAll() {
+		devices = append(devices, device)
+	}
+	slices.Sort(devices)
+
+	index := slices.Index(devices, m.device)
+	if index+1 >= len(devices) {
+		return ""
+	}
+	return devices[index+1]
+}
+
+// View implements tea.Model
+func (m *LogPaneModel) View() string {
+	borderColor := lipgloss.Color("241")
+	if m.focused {
+		borderColor = lipgloss.Color("86")
+	}
+
+	title := []string{
+		lipgloss.NewStyle().Bold(true).Foreground(borderColor).Render("Logs"),
+		"level ≥ " + m.level.String(),
+	}
+	if m.device != "" {
+		title = append(title, "device "+m.device)
+	}
+	if m.paused {
+		title = append(title, warnTextStyle.Render(fmt.Sprintf("PAUSED (%d new)", m.missed)))
+	}
+
+	footer := deviceTableCell(deviceTableMutedStyle, max(1, m.width-4), "space pause · v level · / filter · d device · c clear · w save · ↑/↓ scroll")
+	switch {
+	case m.editingFilter || m.filter.Value() != "":
+		footer = m.filter.View()
+	case m.feedback != "":
+		footer = blueTextStyle.Render("→ " + m.feedback)
+	}
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(borderColor).
+		Padding(0, 1).
+		Width(max(0, m.width-2)).
+		Render(strings.Join(title, " · ") + "\n" + m.viewport.View() + "\n" + footer)
+}
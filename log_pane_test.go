@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func testLogLine(level zerolog.Level, message string) string {
+	return fmt.Sprintf(`{"level":%q,"time":"2024-01-01T10:00:00Z","message":%q}`, level, message)
+}
+
+func TestLogPaneAppendsIncrementally(t *testing.T) {
+	m := NewLogPaneModel()
+	m.SetSize(120, 40)
+
+	levels := []zerolog.Level{zerolog.TraceLevel, zerolog.DebugLevel, zerolog.InfoLevel, zerolog.WarnLevel}
+	for i := range 100 {
+		m.Add(levels[i%len(levels)], testLogLine(levels[i%len(levels)], fmt.Sprintf("message %d for lamp%d", i, i%3)))
+	}
+	m.Add(zerolog.NoLevel, "child process output\n")
+
+	// Info and above, and the lines without a level
+	if m.shown != 51 {
+		t.Errorf("expected 51 shown lines, got %d", m.shown)
+	}
+	for _, entry := range m.entries {
+		if entry.Level < zerolog.InfoLevel && entry.Level != zerolog.NoLevel && entry.Rendered {
+			t.Fatalf("hidden entry rendered: %s", entry.Line)
+		}
+	}
+
+	incremental := m.content.String()
+	m.refresh(true)
+	if rebuilt := m.content.String(); rebuilt != incremental {
+		t.Errorf("incremental content differs from the rebuilt one:\n%s\nrebuilt:\n%s", incremental, rebuilt)
+	}
+
+	// Changing a filter rebuilds the content, and the following lines are appended with the new filter
+	m.device = "lamp1"
+	m.refresh(true)
+	before := m.shown
+	m.Add(zerolog.InfoLevel, testLogLine(zerolog.InfoLevel, "message for lamp2"))
+	m.Add(zerolog.InfoLevel, testLogLine(zerolog.InfoLevel, "message for lamp1"))
+	if m.shown != before+1 || !strings.HasSuffix(m.content.String(), "message for lamp1") {
+		t.Errorf("unexpected content after filtering by device:\n%s", m.content.String())
+	}
+}
+
+func TestLogPaneTrimsInBatches(t *testing.T) {
+	m := NewLogPaneModel()
+	m.SetSize(120, 40)
+
+	for i := range logPaneMaxEntries + logPaneTrimBatch + 1 {
+		m.Add(zerolog.InfoLevel, fmt.Sprintf("line %d", i))
+	}
+	if len(m.entries) != logPaneMaxEntries {
+		t.Fatalf("expected %d entries after trimming, got %d", logPaneMaxEntries, len(m.entries))
+	}
+	if m.shown != logPaneMaxEntries || !strings.HasPrefix(m.content.String(), fmt.Sprintf("line %d\n", logPaneTrimBatch+1)) {
+		t.Errorf("content not rebuilt after trimming: %d lines, starting with %.20q", m.shown, m.content.String())
+	}
+}
@@ -3,12 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"math"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
@@ -42,33 +43,109 @@ func main() {
 
 	// Get CLI param
 	listen := flag.Bool("listen", false, "listen to events from the Hue bridge")
-	dev := flag.Bool("dev", false, "development mode (start vite server)")
+	dev := flag.Bool("dev", false, "development mode (start vite server and proxy the dashboard to it)")
+	headless := flag.Bool("headless", false, "run without the TUI, logging JSON to stdout (for systemd and containers)")
+	flag.Usage = printUsage
 	flag.Parse()
 
 	if *listen {
 		listenToEvents = true
 	}
 
-	ctx := context.Background()
+	if *headless {
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	}
 
-	var wg sync.WaitGroup
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if flag.NArg() > 0 {
+		// Only the problems are logged: the commands print their outcome
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+		if err := runCommand(ctx, flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			if errors.Is(err, errUsage) {
+				printUsage()
+				os.Exit(2)
+			}
+			os.Exit(1)
+		}
+		return
+	}
 
 	configuration := NewConfiguration()
 
+	if err := run(ctx, configuration, runOptions{headless: *headless, dev: *dev}); err != nil {
+		log.Fatal().Err(err).Msgf("%s", err)
+	}
+}
+
+type runOptions struct {
+	headless bool // No TUI: the logs go to stdout and systemd gets notified
+	dev      bool // Proxy the dashboard to the Vite server, started too unless headless
+}
+
+// run starts every component and serves until ctx is cancelled, or the TUI is quit.
+// It returns once the components have stopped: see shutdown for the order.
+func run(ctx context.Context, configuration Configuration, options runOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The connections outlive the sources of the messages, to deliver the ones still queued when shutting down
+	connectionsCtx, closeConnections := context.WithCancel(context.WithoutCancel(ctx))
+	defer closeConnections()
+
+	var connections sync.WaitGroup // Deliver the messages
+	var sources sync.WaitGroup     // Produce the messages
+
 	for _, device := range configuration.GetAllGoveeDeviceAliases() {
-		Status.Register(device, "Govee")
+		Status.Register(device, ProviderGovee)
 	}
 
 	for _, device := range configuration.GetAllSwitchbotDeviceAliases() {
-		Status.Register(device, "Switchbot")
+		Status.Register(device, ProviderSwitchbot)
 	}
 
 	for _, device := range configuration.GetAllWledDeviceAliases() {
-		Status.Register(device, "WLED")
+		Status.Register(device, ProviderWled)
 	}
 
 	for _, device := range configuration.GetAllTwinklyDeviceAliases() {
-		Status.Register(device, "Twinkly")
+		Status.Register(device, ProviderTwinkly)
+	}
+
+	for name, group := range configuration.Groups {
+		Status.RegisterGroup(name, group.Devices)
+	}
+
+	for name, scene := range configuration.Scenes {
+		Scenes.Register(name, scene)
+	}
+
+	if err := GoveeScenes.LoadFile(configuration.GoveeScenesFile); err != nil {
+		return err
+	}
+
+	if configuration.SuppressionWindowMs > 0 {
+		SyncGuard.SetWindow(time.Duration(configuration.SuppressionWindowMs) * time.Millisecond)
+	}
+
+	lanInterfaces, err := resolveLANInterfaces(configuration.GoveeLAN.Interfaces)
+	if err != nil {
+		return err
+	}
+	multicastConns, err := openMulticastConnections(lanInterfaces)
+	if err != nil {
+		return err
+	}
+	defer closeUDPConnections(multicastConns)
+	// Opened before starting, for a busy port to stop the synchronizer instead of leaving it deaf
+	goveeListeners, err := listenGoveeResponses(lanInterfaces)
+	if err != nil {
+		return err
+	}
+	for _, lanInterface := range lanInterfaces {
+		log.Info().Msgf("Scanning for Govee devices on %s", lanInterface)
 	}
 
 	goveeConnection := NewGoveeConnection(configuration)
@@ -92,129 +169,160 @@ func main() {
 		if err := twinklyConnection.Login(ctx, twinklyIP); err != nil {
 			log.Err(err).Msgf("error logging in to Twinkly device: %s", err)
 			twinklyConnection = NewNoopTwinklyConnection()
+		} else {
+			log.Info().Msgf("Logged in to Twinkly device at %s", twinklyIP)
 		}
-		log.Info().Msgf("Logged in to Twinkly device at %s", twinklyIP)
 	} else {
 		twinklyConnection = NewNoopTwinklyConnection()
 	}
 
-	wg.Add(1)
+	connections.Add(1)
 	go func() {
-		defer wg.Done()
-		goveeConnection.Start(ctx)
+		defer connections.Done()
+		goveeConnection.Start(connectionsCtx, goveeListeners)
 	}()
 
-	multicastConn, err := openMulticastConnection()
-	if err != nil {
-		panic(err)
-	}
-	defer multicastConn.Close()
+	connections.Add(1)
+	go func() {
+		defer connections.Done()
+		twinklyConnection.Start(connectionsCtx)
+	}()
 
+	sources.Add(1)
 	go func() {
+		defer sources.Done()
 		for {
+			if err := sendScanRequests(multicastConns); err != nil {
+				log.Err(err).Msgf("Error sending scan request: %s", err)
+			}
+			// Wait 30 seconds before sending the next scan request
 			select {
 			case <-ctx.Done():
 				return
-			default:
-				if err := sendScanRequest(multicastConn); err != nil {
-					log.Err(err).Msgf("Error sending scan request: %s", err)
-				}
-				time.Sleep(30 * time.Second) // Wait 10 seconds before sending the next scan request
+			case <-time.After(30 * time.Second):
 			}
 		}
 	}()
 
+	dispatcher := NewDispatcher(goveeConnection, twinklyConnection, switchbotConnection, wledConnection)
+
 	hueConnection := NewHueConnection(configuration.Hue.Bridge.IP, configuration.Hue.Bridge.Username)
 
-	wg.Add(1)
+	sources.Add(1)
 	go func() {
-		defer wg.Done()
-		hueConnection.Start(ctx, configuration, goveeConnection, twinklyConnection, switchbotConnection, wledConnection)
+		defer sources.Done()
+		hueConnection.Start(ctx, configuration, dispatcher, wledConnection)
 	}()
 
-	wg.Add(1)
+	deviceObserver := NewDeviceObserver(goveeConnection, switchbotConnection, wledConnection, &hueConnection)
+	sources.Add(1)
 	go func() {
-		defer wg.Done()
-		if err := StartHTTPServer(); err != nil {
+		defer sources.Done()
+		deviceObserver.Start(ctx, configuration)
+	}()
+
+	if configuration.MQTT.Broker != "" {
+		mqttBridge := NewMQTTBridge(configuration, dispatcher)
+		sources.Add(1)
+		go func() {
+			defer sources.Done()
+			if err := mqttBridge.Start(ctx); err != nil {
+				log.Err(err).Msgf("MQTT bridge error: %s", err)
+			}
+		}()
+	}
+
+	if configuration.EmulatedHue.Enabled {
+		emulatedHueBridge, err := NewEmulatedHueBridge(configuration, dispatcher)
+		if err != nil {
+			log.Err(err).Msgf("Emulated Hue bridge error: %s", err)
+		} else {
+			sources.Add(1)
+			go func() {
+				defer sources.Done()
+				if err := emulatedHueBridge.Start(ctx); err != nil {
+					log.Err(err).Msgf("Emulated Hue bridge error: %s", err)
+				}
+			}()
+		}
+	}
+
+	var httpServerOptions []httpServerOption
+	if options.dev {
+		httpServerOptions = append(httpServerOptions, WithDevServer(viteDevServerURL))
+	}
+	httpServer := NewHTTPServer(configuration, dispatcher, goveeConnection, wledConnection, httpServerOptions...)
+	sources.Add(1)
+	go func() {
+		defer sources.Done()
+		if err := httpServer.Start(ctx); err != nil {
 			log.Err(err).Msgf("HTTP server error: %s", err)
 		}
 	}()
 
-	tui := NewTUI()
+	if options.headless {
+		if options.dev {
+			log.Warn().Msgf("The Vite server is not started in headless mode: the dashboard is proxied to %s", viteDevServerURL)
+		}
+		if err := sdNotify("READY=1"); err != nil {
+			log.Err(err).Msgf("Error notifying readiness: %s", err)
+		}
+		sources.Add(1)
+		go func() {
+			defer sources.Done()
+			runWatchdog(ctx)
+		}()
+		log.Info().Msgf("Running headless")
+	} else {
+		startTUI(ctx, cancel, configuration, dispatcher, wledConnection, goveeConnection, options.dev, &sources)
+	}
 
-	var tuiWriter io.Writer
-	tuiWriter = zerolog.ConsoleWriter{Out: tui}
-	tuiWriter = &zerolog.FilteredLevelWriter{Writer: zerolog.LevelWriterAdapter{Writer: tuiWriter}, Level: zerolog.InfoLevel}
+	<-ctx.Done()
 
-	log.Logger = log.Output(tuiWriter)
+	if options.headless {
+		if err := sdNotify("STOPPING=1"); err != nil {
+			log.Err(err).Msgf("Error notifying shutdown: %s", err)
+		}
+	}
+	return shutdown(&sources, dispatcher, closeConnections, &connections)
+}
+
+// startTUI runs the TUI, and the Vite server whose output it shows in dev mode. Quitting the TUI calls quit.
+func startTUI(ctx context.Context, quit context.CancelFunc, configuration Configuration, dispatcher *Dispatcher, wledConnection *WledConnection, goveeConnection *GoveeConnection, dev bool, wg *sync.WaitGroup) {
+	tui := NewTUI(configuration, dispatcher, wledConnection, goveeConnection)
 
-	ctx, quit := context.WithCancel(context.Background())
+	// Every level reaches the TUI, whose log pane filters them at runtime: it only renders the entries it shows
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	log.Logger = log.Output(tui)
 
 	stdoutReader, stdoutWriter := io.Pipe()
 	stderrReader, stderrWriter := io.Pipe()
 
-	var cmd *exec.Cmd
-
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := tui.RunNewProgram(stdoutReader, stderrReader); err != nil {
-			// log.Err(err).Msgf("TUI error: %s", err)
-			fmt.Printf("TUI error: %s\n", err)
-			return
+		defer quit()
+		if err := tui.RunNewProgram(ctx, stdoutReader, stderrReader); err != nil {
+			fmt.Fprintf(os.Stderr, "TUI error: %s\n", err)
 		}
+		// The terminal is back: the shutdown gets logged there
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-		quit()
-		time.Sleep(100 * time.Millisecond)
-		if cmd != nil && cmd.Process != nil {
-			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-		}
-		log.Info().Msgf("Exiting...")
-		os.Exit(0)
 	}()
 
-	time.Sleep(50 * time.Millisecond) // Give some time to the TUI to start
-
-	if *dev {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			cmd = exec.CommandContext(ctx, "/bin/sh", "-c", "pnpm dev")
-			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-			stdout, err := cmd.StdoutPipe()
-			if err != nil {
-				log.Err(err).Msgf("Cannot get stdout pipe: %s", err)
-				return
-			}
-			stderr, err := cmd.StderrPipe()
-			if err != nil {
-				log.Err(err).Msgf("Cannot get stderr pipe: %s", err)
-				return
-			}
-
-			var streamWG sync.WaitGroup
-			streamWG.Add(1)
-			go func() {
-				defer streamWG.Done()
-				io.Copy(tui, io.TeeReader(stdout, stdoutWriter))
-			}()
-			streamWG.Add(1)
-			go func() {
-				defer streamWG.Done()
-				io.Copy(tui, io.TeeReader(stderr, stderrWriter))
-			}()
-
-			log.Info().Msgf("Starting dev server...")
-
-			if err := cmd.Start(); err != nil {
-				log.Err(err).Msgf("Cannot start command: %s", err)
-				return
-			}
-		}()
+	if !dev {
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		return
 	}
 
-	wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := StartViteDevServer(ctx, stdoutWriter, stderrWriter); err != nil {
+			log.Err(err).Msgf("Dev server error: %s", err)
+		}
+	}()
 }
 
 func listenFromHueDevice(ctx context.Context, bridgeIP string, bridgeUsername string, sendToGovee chan []byte, sender GoveeCommandSender) {
@@ -356,7 +464,7 @@ func listenFromHueDevice(ctx context.Context, bridgeIP string, bridgeUsername st
 												},
 											})
 											if sender != nil {
-												sender.SendMsg("33:1E:D6:38:32:31:2A:3A", msg)
+												sender.SendMsg(ctx, "33:1E:D6:38:32:31:2A:3A", msg)
 											}
 											sendToGovee <- msg
 										case 3002, 4002:
@@ -370,7 +478,7 @@ func listenFromHueDevice(ctx context.Context, bridgeIP string, bridgeUsername st
 												},
 											})
 											if sender != nil {
-												sender.SendMsg("33:1E:D6:38:32:31:2A:3A", msg)
+												sender.SendMsg(ctx, "33:1E:D6:38:32:31:2A:3A", msg)
 											}
 											sendToGovee <- msg
 										}
@@ -627,6 +735,9 @@ func xyToRGB(x float64, y float64, brightness float64) (uint8, uint8, uint8) {
 	g := -X*0.707196 + Y*1.655397 + Z*0.036152
 	b := X*0.051713 - Y*0.121364 + Z*1.011530
 
+	// Colors on the edge of the gamut get slightly negative components, which would wrap around once converted
+	r, g, b = math.Max(r, 0), math.Max(g, 0), math.Max(b, 0)
+
 	if r <= 0.0031308 {
 		r = 12.92 * r
 	} else {
@@ -656,6 +767,64 @@ func xyToRGB(x float64, y float64, brightness float64) (uint8, uint8, uint8) {
 	return uint8(math.Floor(r * 255)), uint8(math.Floor(g * 255)), uint8(math.Floor(b * 255))
 }
 
+// rgbToXY is the inverse of xyToRGB: the brightness is lost, and colors out of the gamut are moved onto its edge
+func rgbToXY(red, green, blue uint8) (float64, float64) {
+	gammaExpand := func(c uint8) float64 {
+		v := float64(c) / 255
+		if v > 0.04045 {
+			return math.Pow((v+0.055)/(1.0+0.055), 2.4)
+		}
+		return v / 12.92
+	}
+
+	r, g, b := gammaExpand(red), gammaExpand(green), gammaExpand(blue)
+
+	X := r*0.664511 + g*0.154324 + b*0.162028
+	Y := r*0.283881 + g*0.668433 + b*0.047685
+	Z := r*0.000088 + g*0.072310 + b*0.986039
+
+	if X+Y+Z == 0 {
+		// Black has no chromaticity: use the white point
+		return 0.3127, 0.3290
+	}
+
+	x := X / (X + Y + Z)
+	y := Y / (X + Y + Z)
+
+	if !xyIsInGamutRange(x, y) {
+		x, y = getClosestColor(point{x, y})
+	}
+	return x, y
+}
+
+// kelvinToRGB approximates the color of a black body at the given temperature (1000K-40000K)
+func kelvinToRGB(kelvin int) (uint8, uint8, uint8) {
+	temperature := math.Min(math.Max(float64(kelvin), 1000), 40000) / 100
+
+	var r, g, b float64
+	if temperature <= 66 {
+		r = 255
+		g = 99.4708025861*math.Log(temperature) - 161.1195681661
+	} else {
+		r = 329.698727446 * math.Pow(temperature-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(temperature-60, -0.0755148492)
+	}
+
+	switch {
+	case temperature >= 66:
+		b = 255
+	case temperature <= 19:
+		b = 0
+	default:
+		b = 138.5177312231*math.Log(temperature-10) - 305.0447927307
+	}
+
+	clamp := func(v float64) uint8 {
+		return uint8(math.Min(math.Max(v, 0), 255))
+	}
+	return clamp(r), clamp(g), clamp(b)
+}
+
 type point struct {
 	x float64
 	y float64
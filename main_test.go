@@ -1,9 +1,81 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
 
 func BenchmarkXYToRGB(b *testing.B) {
 	for b.Loop() {
 		_, _, _ = xyToRGB(0.3127, 0.3290, 0.5)
 	}
 }
+
+// startTestHueBridge serves a bridge without any light, group or sensor
+func startTestHueBridge(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/sensors") {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Write([]byte(`{"lights":{},"groups":{},"sensors":{},"config":{}}`))
+	}))
+}
+
+func TestRunStopsWithoutLeakingGoroutines(t *testing.T) {
+	_, brokerURL := startTestBroker(t)
+
+	// The broker keeps running after the test: its goroutines are part of the baseline
+	baseline := runtime.NumGoroutine()
+
+	bridge := startTestHueBridge(t)
+	configuration := Configuration{
+		AppName: "test",
+		Hue: HueConfiguration{
+			Bridge: HueBridgeDeviceConfiguration{IP: strings.TrimPrefix(bridge.URL, "http://"), Username: "test"},
+		},
+		HTTP: HTTPConfiguration{Address: "127.0.0.1:0"},
+		MQTT: MQTTConfiguration{Broker: brokerURL, TopicPrefix: "test_lifecycle"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, configuration, runOptions{headless: true})
+	}()
+
+	// Let every component start and poll the bridge a few times
+	time.Sleep(time.Second)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			// e.g. no multicast support
+			t.Skipf("cannot run in this environment: %s", err)
+		}
+	case <-time.After(shutdownTimeout + 5*time.Second):
+		t.Fatalf("run did not return after the context was cancelled")
+	}
+
+	bridge.Close()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+
+	// Some goroutines take a moment to notice that their connection got closed
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buffer := make([]byte, 1<<20)
+			n := runtime.Stack(buffer, true)
+			t.Fatalf("%d goroutines running after shutdown, %d before starting:\n%s", runtime.NumGoroutine(), baseline, buffer[:n])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	mqttDefaultDiscoveryPrefix = "homeassistant"
+	mqttPublishTimeout         = 5 * time.Second
+)
+
+// hueButtonActions maps the last digit of a Hue button event (e.g. 1002) to its meaning
+var hueButtonActions = map[int]string{
+	0: "initial_press",
+	1: "repeat",
+	2: "short_release",
+	3: "long_release",
+}
+
+// MQTTBridge publishes the status of every device as retained JSON on its state topic, accepts commands
+// on its command topic and publishes the tap dial events. Home Assistant discovers the devices as lights
+// and the tap dial buttons as device triggers.
+//
+// Topics, under the configured prefix:
+//   - <prefix>/status: "online" or "offline" (last will)
+//   - <prefix>/light/<device>/state and <prefix>/light/<device>/set
+//   - <prefix>/dial/<dial>/button and <prefix>/dial/<dial>/rotation
+type MQTTBridge struct {
+	configuration Configuration
+	dispatcher    *Dispatcher
+
+	client          mqtt.Client
+	topicPrefix     string
+	discoveryPrefix string
+	devices         map[string]string // Key is the topic slug, value is the device name
+
+	ctx context.Context // Lifetime of the bridge, used for dispatching commands
+}
+
+func NewMQTTBridge(configuration Configuration, dispatcher *Dispatcher) *MQTTBridge {
+	mqttConfiguration := configuration.MQTT
+
+	topicPrefix := mqttConfiguration.TopicPrefix
+	if topicPrefix == "" {
+		topicPrefix = mqttSlug(configuration.AppName)
+	}
+	if topicPrefix == "" {
+		topicPrefix = "govee-test"
+	}
+
+	discoveryPrefix := mqttConfiguration.DiscoveryPrefix
+	if discoveryPrefix == "" {
+		discoveryPrefix = mqttDefaultDiscoveryPrefix
+	}
+
+	clientID := mqttConfiguration.ClientID
+	if clientID == "" {
+		clientID = topicPrefix
+	}
+
+	b := &MQTTBridge{
+		configuration:   configuration,
+		dispatcher:      dispatcher,
+		topicPrefix:     topicPrefix,
+		discoveryPrefix: discoveryPrefix,
+		devices:         make(map[string]string),
+	}
+
+	for device := range Status.GetAll() {
+		b.devices[mqttSlug(device)] = device
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(mqttConfiguration.Broker).
+		SetClientID(clientID).
+		SetUsername(mqttConfiguration.Username).
+		SetPassword(mqttConfiguration.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5*time.Second).
+		SetWill(b.availabilityTopic(), "offline", 1, true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Err(err).Msgf("MQTT connection lost: %s", err)
+		})
+	b.client = mqtt.NewClient(options)
+
+	return b
+}
+
+// Start connects to the broker (retrying in background) and publishes status changes and events
+// until the context is cancelled
+func (b *MQTTBridge) Start(ctx context.Context) error {
+	b.ctx = ctx
+
+	statusChanges, unsubscribeStatus := Status.Subscribe()
+	defer unsubscribeStatus()
+	events, unsubscribeEvents := Events.Subscribe()
+	defer unsubscribeEvents()
+
+	b.client.Connect()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if b.client.IsConnectionOpen() {
+				b.publish(b.availabilityTopic(), true, "offline")
+			}
+			b.client.Disconnect(250)
+			return nil
+		case device := <-statusChanges:
+			if b.client.IsConnectionOpen() {
+				b.publishState(device)
+			}
+		case event := <-events:
+			if b.client.IsConnectionOpen() {
+				b.publishEvent(event)
+			}
+		}
+	}
+}
+
+// onConnect runs on every (re)connection: the broker may have lost the retained messages in the meantime
+func (b *MQTTBridge) onConnect(client mqtt.Client) {
+	log.Info().Msgf("Connected to MQTT broker")
+
+	commandTopic := fmt.Sprintf("%s/light/+/set", b.topicPrefix)
+	if token := client.Subscribe(commandTopic, 1, b.handleCommand); token.WaitTimeout(mqttPublishTimeout) && token.Error() != nil {
+		log.Err(token.Error()).Msgf("error subscribing to %s: %s", commandTopic, token.Error())
+	}
+
+	b.publishDiscovery()
+	b.publish(b.availabilityTopic(), true, "online")
+	for device := range Status.GetAll() {
+		b.publishState(device)
+	}
+}
+
+func (b *MQTTBridge) handleCommand(_ mqtt.Client, message mqtt.Message) {
+	slug := strings.TrimSuffix(strings.TrimPrefix(message.Topic(), b.topicPrefix+"/light/"), "/set")
+	device, ok := b.devices[slug]
+	if !ok {
+		log.Warn().Msgf("MQTT command for unknown device [%s]", slug)
+		return
+	}
+
+	var command MQTTLightCommand
+	if err := json.Unmarshal(message.Payload(), &command); err != nil {
+		log.Err(err).Msgf("error decoding MQTT command for device %s: %s", device, err)
+		return
+	}
+
+	var state DeviceState
+	if command.State != nil {
+		state.On = valToPtr(strings.EqualFold(*command.State, "ON"))
+	}
+	state.Brightness = command.Brightness
+	state.Color = command.Color
+
+	var transition time.Duration
+	if command.Transition != nil {
+		transition = time.Duration(*command.Transition * float64(time.Second))
+	}
+
+	goveeMessages, twinklyMessages, switchbotMessages, wledMessages := b.configuration.GetMessagesToApplyDeviceState(device, state, transition)
+	b.dispatcher.Dispatch(b.ctx, fmt.Sprintf("mqtt command to %s", device), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+}
+
+func (b *MQTTBridge) publishState(device string) {
+	status, ok := Status.Get(device)
+	if !ok {
+		return
+	}
+
+	state := MQTTLightState{Origin: status.Origin}
+	switch status.On {
+	case 1:
+		state.State = "ON"
+	case 0:
+		state.State = "OFF"
+	}
+	if status.Provider != ProviderTwinkly {
+		if status.Brightness >= 0 {
+			state.Brightness = valToPtr(status.Brightness)
+		}
+		if status.Color.Red >= 0 && status.Color.Green >= 0 && status.Color.Blue >= 0 {
+			state.ColorMode = "rgb"
+			state.Color = valToPtr(status.Color)
+		}
+	}
+
+	b.publishJSON(b.lightTopic(device, "state"), true, state)
+}
+
+func (b *MQTTBridge) publishEvent(event Event) {
+	switch event.Type {
+	case EventTypeButtonPressed:
+		data, _ := event.Data.(map[string]int)
+		rawButton := data["button"]
+		b.publishJSON(fmt.Sprintf("%s/dial/%s/button", b.topicPrefix, mqttSlug(event.Source)), false, MQTTButtonEvent{
+			Button: rawButton / 1000,
+			Event:  rawButton,
+			Action: hueButtonActions[rawButton%10],
+		})
+	case EventTypeDialRotated:
+		data, _ := event.Data.(map[string]int)
+		b.publishJSON(fmt.Sprintf("%s/dial/%s/rotation", b.topicPrefix, mqttSlug(event.Source)), false, MQTTRotationEvent{
+			ExpectedRotation: data["expected_rotation"],
+		})
+	}
+}
+
+func (b *MQTTBridge) publishDiscovery() {
+	for device, status := range Status.GetAll() {
+		slug := mqttSlug(device)
+		light := MQTTDiscoveryLight{
+			UniqueID:          fmt.Sprintf("%s_%s", mqttSlug(b.topicPrefix), slug),
+			Schema:            "json",
+			StateTopic:        b.lightTopic(device, "state"),
+			CommandTopic:      b.lightTopic(device, "set"),
+			AvailabilityTopic: b.availabilityTopic(),
+			Device: MQTTDiscoveryDevice{
+				Identifiers:  []string{fmt.Sprintf("%s_%s", mqttSlug(b.topicPrefix), slug)},
+				Name:         device,
+				Manufacturer: status.Provider,
+			},
+		}
+		if status.Provider == ProviderTwinkly {
+			light.SupportedColorModes = []string{"onoff"}
+		} else {
+			light.Brightness = true
+			light.BrightnessScale = 100
+			light.SupportedColorModes = []string{"rgb"}
+		}
+		b.publishJSON(fmt.Sprintf("%s/light/%s/%s/config", b.discoveryPrefix, mqttSlug(b.topicPrefix), slug), true, light)
+	}
+
+	dials := b.configuration.GetRequiredHueDials()
+	slices.Sort(dials)
+	for _, dial := range slices.Compact(dials) {
+		slug := mqttSlug(dial)
+		for button := 1; button <= 4; button++ {
+			for _, action := range []string{"short_release", "long_release"} {
+				trigger := MQTTDiscoveryTrigger{
+					AutomationType: "trigger",
+					Type:           "button_" + action,
+					Subtype:        fmt.Sprintf("button_%d", button),
+					Topic:          fmt.Sprintf("%s/dial/%s/button", b.topicPrefix, slug),
+					Payload:        fmt.Sprintf("%d_%s", button, action),
+					ValueTemplate:  "{{ value_json.button }}_{{ value_json.action }}",
+					Device: MQTTDiscoveryDevice{
+						Identifiers:  []string{fmt.Sprintf("%s_%s", mqttSlug(b.topicPrefix), slug)},
+						Name:         dial,
+						Manufacturer: "Philips Hue",
+						Model:        "Hue tap dial switch",
+					},
+				}
+				objectID := fmt.Sprintf("%s_button_%d_%s", slug, button, action)
+				b.publishJSON(fmt.Sprintf("%s/device_automation/%s/%s/config", b.discoveryPrefix, mqttSlug(b.topicPrefix), objectID), true, trigger)
+			}
+		}
+	}
+}
+
+func (b *MQTTBridge) publishJSON(topic string, retained bool, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Err(err).Msgf("error encoding MQTT payload for %s: %s", topic, err)
+		return
+	}
+	b.publish(topic, retained, data)
+}
+
+func (b *MQTTBridge) publish(topic string, retained bool, payload any) {
+	token := b.client.Publish(topic, 1, retained, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		log.Warn().Msgf("Timeout publishing to %s", topic)
+		return
+	}
+	if err := token.Error(); err != nil {
+		log.Err(err).Msgf("error publishing to %s: %s", topic, err)
+	}
+}
+
+func (b *MQTTBridge) availabilityTopic() string {
+	return b.topicPrefix + "/status"
+}
+
+func (b *MQTTBridge) lightTopic(device, suffix string) string {
+	return fmt.Sprintf("%s/light/%s/%s", b.topicPrefix, mqttSlug(device), suffix)
+}
+
+// mqttSlug turns a name into a topic level (and a Home Assistant object ID): lowercase letters, digits and underscores
+func mqttSlug(name string) string {
+	var slug strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			slug.WriteRune(r)
+		default:
+			slug.WriteRune('_')
+		}
+	}
+	return strings.Trim(slug.String(), "_")
+}
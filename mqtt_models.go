@@ -0,0 +1,59 @@
+package main
+
+// MQTTLightState is published (retained) on the state topic of every device, following the Home Assistant
+// JSON schema for lights. Unknown attributes are omitted.
+type MQTTLightState struct {
+	State      string       `json:"state,omitempty"` // "ON" or "OFF"
+	Brightness *int         `json:"brightness,omitempty"`
+	ColorMode  string       `json:"color_mode,omitempty"`
+	Color      *deviceColor `json:"color,omitempty"`
+	Origin     ChangeOrigin `json:"origin,omitempty"`
+}
+
+// MQTTLightCommand is received on the command topic of every device: omitted attributes are left untouched
+type MQTTLightCommand struct {
+	State      *string      `json:"state"`
+	Brightness *int         `json:"brightness"`
+	Color      *deviceColor `json:"color"`
+	Transition *float64     `json:"transition"` // Seconds
+}
+
+type MQTTButtonEvent struct {
+	Button int    `json:"button"` // 1-4
+	Event  int    `json:"event"`  // Raw Hue button event, e.g. 1002
+	Action string `json:"action"` // "initial_press", "repeat", "short_release" or "long_release"
+}
+
+type MQTTRotationEvent struct {
+	ExpectedRotation int `json:"expected_rotation"`
+}
+
+type MQTTDiscoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+}
+
+type MQTTDiscoveryLight struct {
+	Name                *string             `json:"name"` // null: the entity takes the name of the device
+	UniqueID            string              `json:"unique_id"`
+	Schema              string              `json:"schema"`
+	StateTopic          string              `json:"state_topic"`
+	CommandTopic        string              `json:"command_topic"`
+	AvailabilityTopic   string              `json:"availability_topic"`
+	Brightness          bool                `json:"brightness"`
+	BrightnessScale     int                 `json:"brightness_scale,omitempty"`
+	SupportedColorModes []string            `json:"supported_color_modes"`
+	Device              MQTTDiscoveryDevice `json:"device"`
+}
+
+type MQTTDiscoveryTrigger struct {
+	AutomationType string              `json:"automation_type"`
+	Type           string              `json:"type"`
+	Subtype        string              `json:"subtype"`
+	Topic          string              `json:"topic"`
+	Payload        string              `json:"payload"`
+	ValueTemplate  string              `json:"value_template"`
+	Device         MQTTDiscoveryDevice `json:"device"`
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// startTestBroker runs an embedded broker on a free local port
+func startTestBroker(t *testing.T) (*mochi.Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error finding a free port: %s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.DiscardHandler),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("error adding auth hook: %s", err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: address})); err != nil {
+		t.Fatalf("error adding listener: %s", err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatalf("error starting broker: %s", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	return server, "tcp://" + address
+}
+
+// subscribeTestBroker forwards the payloads published on the topic to the returned channel
+func subscribeTestBroker(t *testing.T, server *mochi.Server, topic string, subscriptionID int) <-chan []byte {
+	t.Helper()
+	messages := make(chan []byte, 10)
+	err := server.Subscribe(topic, subscriptionID, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		messages <- pk.Payload
+	})
+	if err != nil {
+		t.Fatalf("error subscribing to %s: %s", topic, err)
+	}
+	return messages
+}
+
+func waitTestMessage(t *testing.T, messages <-chan []byte, v any) {
+	t.Helper()
+	select {
+	case payload := <-messages:
+		if err := json.Unmarshal(payload, v); err != nil {
+			t.Fatalf("error decoding %s: %s", payload, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for message")
+	}
+}
+
+func startTestMQTTBridge(t *testing.T, device string) (*mochi.Server, string) {
+	t.Helper()
+
+	server, brokerURL := startTestBroker(t)
+	prefix := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	Status.Register(device, ProviderWled)
+
+	configuration := Configuration{
+		AppName: "test",
+		MQTT:    MQTTConfiguration{Broker: brokerURL, TopicPrefix: prefix},
+		Wled:    map[string]WledDeviceConfiguration{device: {IP: "127.0.0.1:1"}},
+	}
+	bridge := NewMQTTBridge(configuration, NewDispatcher(nil, nil, nil, NewWledConnection(configuration)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bridge.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return server, prefix
+}
+
+func TestMQTTBridgePublishesDiscoveryAndState(t *testing.T) {
+	server, prefix := startTestMQTTBridge(t, "MQTT Discovery Lamp")
+	Status.SetOn("MQTT Discovery Lamp", true)
+	Status.SetBrightness("MQTT Discovery Lamp", 30)
+
+	discoveryMessages := subscribeTestBroker(t, server, fmt.Sprintf("homeassistant/light/%s/mqtt_discovery_lamp/config", prefix), 1)
+	stateMessages := subscribeTestBroker(t, server, prefix+"/light/mqtt_discovery_lamp/state", 2)
+
+	var discovery MQTTDiscoveryLight
+	waitTestMessage(t, discoveryMessages, &discovery)
+	if discovery.CommandTopic != prefix+"/light/mqtt_discovery_lamp/set" || discovery.Schema != "json" {
+		t.Errorf("unexpected discovery payload: %+v", discovery)
+	}
+	if discovery.Device.Name != "MQTT Discovery Lamp" || discovery.Device.Manufacturer != ProviderWled {
+		t.Errorf("unexpected discovery device: %+v", discovery.Device)
+	}
+
+	var state MQTTLightState
+	waitTestMessage(t, stateMessages, &state)
+	if state.State != "ON" || state.Brightness == nil || *state.Brightness != 30 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
+
+func TestMQTTBridgeAppliesCommands(t *testing.T) {
+	server, prefix := startTestMQTTBridge(t, "MQTT Command Lamp")
+
+	// The bridge subscribes to the commands once connected, right before publishing the states
+	stateMessages := subscribeTestBroker(t, server, prefix+"/light/mqtt_command_lamp/state", 1)
+	var state MQTTLightState
+	waitTestMessage(t, stateMessages, &state)
+
+	if err := server.Publish(prefix+"/light/mqtt_command_lamp/set", []byte(`{"state":"ON","brightness":42}`), false, 1); err != nil {
+		t.Fatalf("error publishing command: %s", err)
+	}
+
+	for range 50 {
+		waitTestMessage(t, stateMessages, &state)
+		if state.State == "ON" && state.Brightness != nil && *state.Brightness == 42 {
+			return
+		}
+	}
+	t.Errorf("command not applied, last state: %+v", state)
+}
+
+func TestMQTTBridgePublishesButtonEvents(t *testing.T) {
+	server, prefix := startTestMQTTBridge(t, "MQTT Button Lamp")
+
+	stateMessages := subscribeTestBroker(t, server, prefix+"/light/mqtt_button_lamp/state", 1)
+	var state MQTTLightState
+	waitTestMessage(t, stateMessages, &state)
+
+	buttonMessages := subscribeTestBroker(t, server, prefix+"/dial/test_dial/button", 2)
+	Events.Publish(EventTypeButtonPressed, "Test Dial", map[string]int{"button": 2002})
+
+	var event MQTTButtonEvent
+	waitTestMessage(t, buttonMessages, &event)
+	if event != (MQTTButtonEvent{Button: 2, Event: 2002, Action: "short_release"}) {
+		t.Errorf("unexpected button event: %+v", event)
+	}
+}
+
+func TestMQTTSlug(t *testing.T) {
+	tests := map[string]string{
+		"Bedroom Floor Lamp SX":  "bedroom_floor_lamp_sx",
+		"hue-govee synchronizer": "hue_govee_synchronizer",
+		" Dial (TV) ":            "dial__tv",
+	}
+	for name, expected := range tests {
+		if slug := mqttSlug(name); slug != expected {
+			t.Errorf("mqttSlug(%q) = %q, expected %q", name, slug, expected)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"syscall"
 )
 
 const (
@@ -21,13 +23,114 @@ func init() {
 	multicastAddr = addr
 }
 
-func openMulticastConnection() (*net.UDPConn, error) {
-	conn, err := net.ListenMulticastUDP("udp", nil, multicastAddr)
+// lanInterface is a network interface to reach the Govee devices through, with the IPv4 address to bind to.
+// The zero value lets the system route the scan, and listens on every interface.
+type lanInterface struct {
+	Interface *net.Interface
+	IP        net.IP
+}
+
+func (i lanInterface) String() string {
+	if i.Interface == nil {
+		return "the default interface"
+	}
+	return fmt.Sprintf("%s (%s)", i.Interface.Name, i.IP)
+}
+
+// resolveLANInterfaces finds the interfaces given by name or by IPv4 address, or all of them when none is given
+func resolveLANInterfaces(namesOrIPs []string) ([]lanInterface, error) {
+	if len(namesOrIPs) == 0 {
+		return []lanInterface{{}}, nil
+	}
+
+	interfaces, err := net.Interfaces()
 	if err != nil {
-		return nil, fmt.Errorf("error listening to multicast UDP: %v", err)
+		return nil, fmt.Errorf("error listing network interfaces: %w", err)
+	}
+
+	var resolved []lanInterface
+	for _, nameOrIP := range namesOrIPs {
+		lanInterface, err := findLANInterface(interfaces, nameOrIP)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, lanInterface)
 	}
+	return resolved, nil
+}
 
-	return conn, nil
+func findLANInterface(interfaces []net.Interface, nameOrIP string) (lanInterface, error) {
+	wantedIP := net.ParseIP(nameOrIP)
+	for _, iface := range interfaces {
+		if wantedIP == nil && iface.Name != nameOrIP {
+			continue
+		}
+		addresses, err := iface.Addrs()
+		if err != nil {
+			return lanInterface{}, fmt.Errorf("error listing the addresses of %s: %w", iface.Name, err)
+		}
+		for _, address := range addresses {
+			ipNet, ok := address.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			if wantedIP == nil || ipNet.IP.Equal(wantedIP) {
+				return lanInterface{Interface: &iface, IP: ipNet.IP.To4()}, nil
+			}
+		}
+		if wantedIP == nil {
+			return lanInterface{}, fmt.Errorf("network interface %s has no IPv4 address", nameOrIP)
+		}
+	}
+	return lanInterface{}, fmt.Errorf("no network interface named %s or holding that IPv4 address", nameOrIP)
+}
+
+// openMulticastConnections opens a socket sending the scan requests out of each interface
+func openMulticastConnections(interfaces []lanInterface) ([]*net.UDPConn, error) {
+	var conns []*net.UDPConn
+	for _, lanInterface := range interfaces {
+		conn, err := net.ListenMulticastUDP("udp4", lanInterface.Interface, multicastAddr)
+		if err != nil {
+			closeUDPConnections(conns)
+			return nil, fmt.Errorf("error listening to multicast UDP on %s: %w", lanInterface, err)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// listenGoveeResponses opens a socket receiving the answers of the devices on each interface
+func listenGoveeResponses(interfaces []lanInterface) ([]*net.UDPConn, error) {
+	var conns []*net.UDPConn
+	for _, lanInterface := range interfaces {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: lanInterface.IP, Port: listenPort})
+		if err != nil {
+			closeUDPConnections(conns)
+			if errors.Is(err, syscall.EADDRINUSE) {
+				return nil, fmt.Errorf("UDP port %d on %s is already in use, is another synchronizer or a discover command running? %w", listenPort, lanInterface, err)
+			}
+			return nil, fmt.Errorf("error listening on UDP port %d on %s: %w", listenPort, lanInterface, err)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+func closeUDPConnections(conns []*net.UDPConn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// sendScanRequests sends the scan request out of every interface
+func sendScanRequests(conns []*net.UDPConn) error {
+	var errs []error
+	for _, conn := range conns {
+		if err := sendScanRequest(conn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func sendScanRequest(conn *net.UDPConn) error {
@@ -1,14 +0,0 @@
-package main
-
-func init() {
-
-	// discovery := flag.Bool("discovery", false, "Discovery mode: scan for new devices")
-	// flag.Parse()
-
-	// if *discovery {
-	// 	fmt.Println("Discovery mode")
-	// } else {
-	// 	fmt.Println("Normal mode")
-	// }
-
-}
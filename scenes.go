@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+)
+
+type sceneRegistry struct {
+	mtx       struct{ sync.RWMutex }
+	scenes    map[string]ConfigurationScene // Defined in configuration
+	snapshots map[string]ConfigurationScene // Captured at runtime, discarded once restored
+}
+
+// Scenes holds both the scenes defined in configuration and the ones captured at runtime via snapshots.
+// A snapshot never replaces a scene of the configuration.
+var Scenes = &sceneRegistry{
+	scenes:    make(map[string]ConfigurationScene),
+	snapshots: make(map[string]ConfigurationScene),
+}
+
+func (s *sceneRegistry) Register(name string, scene ConfigurationScene) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.scenes[name] = scene
+}
+
+func (s *sceneRegistry) Get(name string) (ConfigurationScene, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if scene, ok := s.snapshots[name]; ok {
+		return scene, true
+	}
+	scene, ok := s.scenes[name]
+	return scene, ok
+}
+
+// DiscardSnapshot forgets a scene captured at runtime: the scenes of the configuration are kept
+func (s *sceneRegistry) DiscardSnapshot(name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.snapshots, name)
+}
+
+func (s *sceneRegistry) GetAll() map[string]ConfigurationScene {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	copied := make(map[string]ConfigurationScene)
+	maps.Copy(copied, s.scenes)
+	maps.Copy(copied, s.snapshots)
+	return copied
+}
+
+// Snapshot captures the current Status of the given devices (every known device when empty)
+// and stores it as a scene with the given name, overwriting any previous snapshot with the same name.
+// Attributes whose status is still unknown are left out of the scene.
+// The name cannot be the one of a scene of the configuration.
+func (s *sceneRegistry) Snapshot(name string, devices []string) (ConfigurationScene, error) {
+	s.mtx.RLock()
+	_, configured := s.scenes[name]
+	s.mtx.RUnlock()
+	if configured {
+		return ConfigurationScene{}, fmt.Errorf("scene %s is defined in the configuration and cannot be replaced by a snapshot", name)
+	}
+
+	allStatuses := Status.GetAll()
+
+	if len(devices) == 0 {
+		for device := range allStatuses {
+			devices = append(devices, device)
+		}
+	}
+
+	scene := ConfigurationScene{
+		Devices: make(map[string]DeviceState, len(devices)),
+	}
+
+	for _, device := range devices {
+		ds, ok := allStatuses[device]
+		if !ok {
+			continue
+		}
+		scene.Devices[device] = ds.ToDeviceState()
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.snapshots[name] = scene
+
+	return scene, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestSceneRestoreKeepsConfiguredScenes(t *testing.T) {
+	configuration := Configuration{}
+	Scenes.Register("test configured", ConfigurationScene{Devices: map[string]DeviceState{}})
+	t.Cleanup(func() {
+		Scenes.mtx.Lock()
+		defer Scenes.mtx.Unlock()
+		delete(Scenes.scenes, "test configured")
+		delete(Scenes.snapshots, "test snapshot")
+	})
+
+	// Restoring a scene of the configuration applies it without forgetting it
+	configuration.GetMessagesToDispatchOnSceneAction(ConfigurationActionSceneAction{Scene: "test configured", Action: SceneActionRestore})
+	if _, ok := Scenes.Get("test configured"); !ok {
+		t.Fatalf("configured scene deleted by a restore")
+	}
+
+	// A snapshot cannot replace it
+	if _, err := Scenes.Snapshot("test configured", []string{}); err == nil {
+		t.Errorf("expected an error when snapshotting over a configured scene")
+	}
+
+	// A snapshot is discarded once restored
+	configuration.GetMessagesToDispatchOnSceneAction(ConfigurationActionSceneAction{Scene: "test snapshot", Action: SceneActionSnapshot})
+	if _, ok := Scenes.Get("test snapshot"); !ok {
+		t.Fatalf("snapshot not stored")
+	}
+	configuration.GetMessagesToDispatchOnSceneAction(ConfigurationActionSceneAction{Scene: "test snapshot", Action: SceneActionRestore})
+	if _, ok := Scenes.Get("test snapshot"); ok {
+		t.Errorf("snapshot kept after its restore")
+	}
+}
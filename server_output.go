@@ -81,6 +81,11 @@ func (m *ServerOutputModel) SetSize(width, height int) {
 	}
 }
 
+// HasOutput tells whether the pane is shown: it stays hidden until the server writes something
+func (m *ServerOutputModel) HasOutput() bool {
+	return m.ready && len(m.lines) > 0
+}
+
 // Init implements tea.Model
 func (m *ServerOutputModel) Init() tea.Cmd {
 	return m.waitForActivity()
@@ -135,7 +140,7 @@ func (m *ServerOutputModel) Update(msg tea.Msg) (*ServerOutputModel, tea.Cmd) {
 
 // View implements tea.Model
 func (m *ServerOutputModel) View() string {
-	if !m.ready || len(m.lines) == 0 {
+	if !m.HasOutput() {
 		return ""
 	}
 
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// shutdownTimeout bounds the graceful shutdown: the messages still queued after it are given up
+const shutdownTimeout = 10 * time.Second
+
+// shutdown stops the components once the root context is cancelled, in order:
+// the sources of the messages (polling, servers) are waited for, the dispatch queues are drained,
+// and only then the device connections are closed.
+func shutdown(sources *sync.WaitGroup, dispatcher *Dispatcher, closeConnections context.CancelFunc, connections *sync.WaitGroup) error {
+	log.Info().Msgf("Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := waitGroupContext(ctx, sources); err != nil {
+		log.Warn().Msgf("Some sources of messages did not stop within %s", shutdownTimeout)
+	}
+
+	if err := dispatcher.Drain(ctx); err != nil {
+		log.Warn().Err(err).Msgf("Some messages were not delivered before shutting down")
+	}
+
+	closeConnections()
+	if err := waitGroupContext(ctx, connections); err != nil {
+		return fmt.Errorf("error closing the device connections: %w", err)
+	}
+
+	log.Info().Msgf("Stopped")
+	return nil
+}
+
+// waitGroupContext waits for wg, or for ctx to be done
+func waitGroupContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,34 +1,198 @@
-import { useState } from 'react'
-import reactLogo from './assets/react.svg'
-import viteLogo from '/vite.svg'
+import { useEffect, useState, type FormEvent } from 'react'
+import {
+  api,
+  APIError,
+  getToken,
+  saveToken,
+  streamURL,
+  type Command,
+  type Device,
+  type DeviceStatus,
+  type GroupStatus,
+  type Scene,
+  type StatusDelta,
+  type StreamMessage,
+} from './api.ts'
+import DeviceTile from './components/DeviceTile.tsx'
+import EventLog from './components/EventLog.tsx'
 import './App.css'
 
+const EVENT_LOG_SIZE = 200
+
+function errorMessage(error: unknown): string {
+  if (error instanceof APIError && error.status === 401) {
+    return 'An API token is required: set it above.'
+  }
+  return error instanceof Error ? error.message : String(error)
+}
+
+function applyStatus(devices: Record<string, Device>, name: string, status: DeviceStatus): Record<string, Device> {
+  const device = devices[name]
+  if (!device) {
+    return devices
+  }
+  return { ...devices, [name]: { ...device, status } }
+}
+
+function applyDelta(devices: Record<string, Device>, name: string, delta: StatusDelta): Record<string, Device> {
+  const device = devices[name]
+  if (!device) {
+    return devices
+  }
+  return applyStatus(devices, name, { ...device.status, ...delta })
+}
+
 function App() {
-  const [count, setCount] = useState(0)
+  const [token, setToken] = useState(getToken)
+  const [tokenDraft, setTokenDraft] = useState(token)
+  const [devices, setDevices] = useState<Record<string, Device>>({})
+  const [groups, setGroups] = useState<Record<string, GroupStatus>>({})
+  const [scenes, setScenes] = useState<Scene[]>([])
+  const [messages, setMessages] = useState<StreamMessage[]>([])
+  const [connected, setConnected] = useState(false)
+  const [error, setError] = useState<string | null>(null)
+
+  useEffect(() => {
+    let cancelled = false
+    Promise.all([api.devices(), api.groups(), api.scenes()])
+      .then(([devices, groups, scenes]) => {
+        if (cancelled) return
+        setDevices(Object.fromEntries(devices.map((device) => [device.name, device])))
+        setGroups(groups)
+        setScenes(scenes)
+        setError(null)
+      })
+      .catch((error: unknown) => {
+        if (!cancelled) setError(errorMessage(error))
+      })
+    return () => {
+      cancelled = true
+    }
+  }, [token])
+
+  useEffect(() => {
+    // EventSource reconnects by itself, sending the ID of the last message: the server resends what was missed
+    const source = new EventSource(streamURL())
+    source.onopen = () => setConnected(true)
+    source.onerror = () => setConnected(false)
+    source.onmessage = (event: MessageEvent<string>) => {
+      const message = JSON.parse(event.data) as StreamMessage
+      if (message.type === 'snapshot') {
+        const statuses = message.data as Record<string, DeviceStatus>
+        setDevices((devices) =>
+          Object.entries(statuses).reduce((updated, [name, status]) => applyStatus(updated, name, status), devices),
+        )
+        return
+      }
+      if (message.type === 'status' && message.source) {
+        const name = message.source
+        setDevices((devices) => applyDelta(devices, name, message.data as StatusDelta))
+      }
+      setMessages((messages) => [message, ...messages].slice(0, EVENT_LOG_SIZE))
+    }
+    return () => source.close()
+  }, [token])
+
+  const run = (operation: Promise<unknown>) => {
+    operation.then(() => setError(null)).catch((error: unknown) => setError(errorMessage(error)))
+  }
+
+  const commandDevice = (name: string, command: Command) => run(api.commandDevice(name, command))
+  const commandGroup = (name: string, command: Command) => run(api.commandGroup(name, command))
+  const applyScene = (name: string) => run(api.applyScene(name))
+
+  const submitToken = (event: FormEvent) => {
+    event.preventDefault()
+    saveToken(tokenDraft.trim())
+    setToken(tokenDraft.trim())
+  }
+
+  const sortedDevices = Object.values(devices).sort((a, b) => a.name.localeCompare(b.name))
+  const sortedGroups = Object.entries(groups).sort(([a], [b]) => a.localeCompare(b))
 
   return (
-    <>
-      <div>
-        <a href="https://vite.dev" target="_blank">
-          <img src={viteLogo} className="logo" alt="Vite logo" />
-        </a>
-        <a href="https://react.dev" target="_blank">
-          <img src={reactLogo} className="logo react" alt="React logo" />
-        </a>
-      </div>
-      <h1>Vite + React</h1>
-      <div className="card">
-        <button onClick={() => setCount((count) => count + 1)}>
-          count is {count}
-        </button>
-        <p>
-          Edit <code>src/App.tsx</code> and save to test HMR
-        </p>
-      </div>
-      <p className="read-the-docs">
-        Click on the Vite and React logos to learn more
-      </p>
-    </>
+    <div className="dashboard">
+      <header className="topbar">
+        <h1>Light sync</h1>
+        <span className={`connection ${connected ? 'connection-up' : ''}`}>{connected ? 'Live' : 'Disconnected'}</span>
+        <form className="token-form" onSubmit={submitToken}>
+          <input
+            type="password"
+            placeholder="API token"
+            autoComplete="current-password"
+            value={tokenDraft}
+            onChange={(e) => setTokenDraft(e.target.value)}
+          />
+          <button type="submit">Save</button>
+        </form>
+      </header>
+
+      {error && <p className="error">{error}</p>}
+
+      <main className="layout">
+        <section className="panel devices">
+          <header className="panel-header">
+            <h2>Devices</h2>
+          </header>
+          <div className="tiles">
+            {sortedDevices.map((device) => (
+              <DeviceTile key={device.name} device={device} onCommand={(command) => commandDevice(device.name, command)} />
+            ))}
+          </div>
+        </section>
+
+        <aside className="sidebar">
+          <section className="panel">
+            <header className="panel-header">
+              <h2>Groups</h2>
+            </header>
+            {sortedGroups.length === 0 && <p className="muted">No groups configured.</p>}
+            <ul className="rows">
+              {sortedGroups.map(([name, group]) => {
+                // Computed from the devices, which are kept up to date by the stream
+                const onCount = group.members.filter((member) => devices[member]?.status.on === 1).length
+                return (
+                  <li key={name} className="row">
+                    <div>
+                      <strong>{name}</strong>
+                      <span className="muted">
+                        {onCount}/{group.members.length} on
+                      </span>
+                    </div>
+                    <div className="row-actions">
+                      <button onClick={() => commandGroup(name, { on: true })}>On</button>
+                      <button onClick={() => commandGroup(name, { on: false })}>Off</button>
+                    </div>
+                  </li>
+                )
+              })}
+            </ul>
+          </section>
+
+          <section className="panel">
+            <header className="panel-header">
+              <h2>Scenes</h2>
+            </header>
+            {scenes.length === 0 && <p className="muted">No scenes configured.</p>}
+            <ul className="rows">
+              {scenes.map((scene) => (
+                <li key={scene.name} className="row">
+                  <div>
+                    <strong>{scene.name}</strong>
+                    <span className="muted">{Object.keys(scene.devices).length} devices</span>
+                  </div>
+                  <div className="row-actions">
+                    <button onClick={() => applyScene(scene.name)}>Apply</button>
+                  </div>
+                </li>
+              ))}
+            </ul>
+          </section>
+
+          <EventLog messages={messages} onClear={() => setMessages([])} />
+        </aside>
+      </main>
+    </div>
   )
 }
 
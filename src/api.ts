@@ -0,0 +1,149 @@
+// Client of the REST API served by the Go binary (see /api/v1/openapi.json)
+
+const BASE_URL = '/api/v1'
+const TOKEN_STORAGE_KEY = 'light-sync.token'
+
+export type Capability = 'on_off' | 'brightness' | 'color' | 'color_temperature' | 'effect'
+
+export type Color = { r: number; g: number; b: number }
+
+// -1 means unknown
+export type DeviceStatus = {
+  provider: string
+  on: number
+  brightness: number
+  color: Color
+  reachable: number
+  origin?: string
+}
+
+export type Device = {
+  name: string
+  provider: string
+  capabilities: Capability[]
+  status: DeviceStatus
+}
+
+export type GroupStatus = {
+  members: string[]
+  on: string
+  brightness: number
+}
+
+export type DeviceState = {
+  on?: boolean
+  brightness?: number
+  color?: Color
+  effect?: number
+}
+
+export type Scene = {
+  name: string
+  devices: Record<string, DeviceState>
+  transition_ms: number
+}
+
+export type Command = {
+  on?: boolean
+  brightness?: number
+  color?: Color
+  color_temperature?: number
+  effect?: number
+  transition_ms?: number
+}
+
+export type StatusDelta = {
+  on?: number
+  brightness?: number
+  color?: Color
+  reachable?: number
+  origin?: string
+}
+
+export type DispatchResult = {
+  name: string
+  started_at: string
+  duration: number
+  succeeded: string[] | null
+  failed: Record<string, string> | null
+}
+
+export type StreamMessage = {
+  id: number
+  type: string
+  source?: string
+  time: string
+  data?: unknown
+}
+
+export class APIError extends Error {
+  status: number
+  code: string
+
+  constructor(status: number, code: string, message: string) {
+    super(message)
+    this.status = status
+    this.code = code
+  }
+}
+
+export function getToken(): string {
+  return localStorage.getItem(TOKEN_STORAGE_KEY) ?? ''
+}
+
+export function saveToken(token: string) {
+  if (token) {
+    localStorage.setItem(TOKEN_STORAGE_KEY, token)
+  } else {
+    localStorage.removeItem(TOKEN_STORAGE_KEY)
+  }
+}
+
+async function request<T>(method: string, path: string, body?: unknown): Promise<T> {
+  const headers: Record<string, string> = {}
+  const token = getToken()
+  if (token) {
+    headers['Authorization'] = `Bearer ${token}`
+  }
+  if (body !== undefined) {
+    headers['Content-Type'] = 'application/json'
+  }
+
+  const response = await fetch(BASE_URL + path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  })
+  const payload = await response.json().catch(() => null)
+  if (!response.ok) {
+    const error = payload?.error
+    throw new APIError(response.status, error?.code ?? 'unknown', error?.message ?? response.statusText)
+  }
+  return payload as T
+}
+
+const segment = (name: string) => encodeURIComponent(name)
+
+export const api = {
+  devices: () => request<Device[]>('GET', '/devices'),
+  groups: () => request<Record<string, GroupStatus>>('GET', '/groups'),
+  scenes: () => request<Scene[]>('GET', '/scenes'),
+  commandDevice: (name: string, command: Command) => request('POST', `/devices/${segment(name)}/commands`, command),
+  commandGroup: (name: string, command: Command) => request('POST', `/groups/${segment(name)}/commands`, command),
+  applyScene: (name: string) => request('POST', `/scenes/${segment(name)}/apply`),
+}
+
+// streamURL is the Server-Sent Events endpoint: EventSource cannot send headers, so the token goes in the query
+export function streamURL(): string {
+  const token = getToken()
+  return `${BASE_URL}/stream` + (token ? `?access_token=${encodeURIComponent(token)}` : '')
+}
+
+export function colorToHex({ r, g, b }: Color): string {
+  return '#' + [r, g, b].map((component) => Math.max(component, 0).toString(16).padStart(2, '0')).join('')
+}
+
+export function hexToColor(hex: string): Color {
+  const value = parseInt(hex.slice(1), 16)
+  return { r: (value >> 16) & 0xff, g: (value >> 8) & 0xff, b: value & 0xff }
+}
@@ -0,0 +1,84 @@
+import { useRef, useState } from 'react'
+import { colorToHex, hexToColor, type Command, type Device } from '../api.ts'
+
+type DeviceTileProps = {
+  device: Device
+  onCommand: (command: Command) => void
+}
+
+const COLOR_DEBOUNCE_MS = 250
+
+function DeviceTile({ device, onCommand }: DeviceTileProps) {
+  const { status, capabilities } = device
+  // Values being edited: the tile shows them until the status streamed back replaces them
+  const [brightness, setBrightness] = useState<number | null>(null)
+  const [color, setColor] = useState<string | null>(null)
+  const colorTimer = useRef<number | undefined>(undefined)
+
+  const on = status.on === 1
+  const knownColor = status.color.r >= 0 && status.color.g >= 0 && status.color.b >= 0
+  const shownBrightness = brightness ?? Math.max(status.brightness, 0)
+  const shownColor = color ?? (knownColor ? colorToHex(status.color) : '#ffffff')
+
+  const commitBrightness = () => {
+    if (brightness !== null) {
+      onCommand({ brightness })
+      setBrightness(null)
+    }
+  }
+
+  const changeColor = (hex: string) => {
+    setColor(hex)
+    window.clearTimeout(colorTimer.current)
+    colorTimer.current = window.setTimeout(() => {
+      onCommand({ color: hexToColor(hex) })
+      setColor(null)
+    }, COLOR_DEBOUNCE_MS)
+  }
+
+  return (
+    <article className={`tile ${on ? 'tile-on' : ''}`} style={on && knownColor ? { borderColor: shownColor } : undefined}>
+      <header className="tile-header">
+        <div>
+          <h3>{device.name}</h3>
+          <span className="muted">
+            {device.provider}
+            {status.origin ? ` · ${status.origin}` : ''}
+          </span>
+        </div>
+        {capabilities.includes('on_off') && (
+          <button
+            className={`toggle ${on ? 'toggle-on' : ''}`}
+            aria-pressed={on}
+            title={status.on === -1 ? 'Unknown state' : undefined}
+            onClick={() => onCommand({ on: !on })}
+          >
+            {status.on === -1 ? '?' : on ? 'On' : 'Off'}
+          </button>
+        )}
+      </header>
+      {capabilities.includes('brightness') && (
+        <label className="control">
+          <span>Brightness {status.brightness === -1 && brightness === null ? '?' : `${shownBrightness}%`}</span>
+          <input
+            type="range"
+            min={0}
+            max={100}
+            value={shownBrightness}
+            onChange={(e) => setBrightness(Number(e.target.value))}
+            onPointerUp={commitBrightness}
+            onKeyUp={commitBrightness}
+          />
+        </label>
+      )}
+      {capabilities.includes('color') && (
+        <label className="control control-inline">
+          <span>Color</span>
+          <input type="color" value={shownColor} onChange={(e) => changeColor(e.target.value)} />
+        </label>
+      )}
+    </article>
+  )
+}
+
+export default DeviceTile
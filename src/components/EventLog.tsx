@@ -0,0 +1,59 @@
+import type { DispatchResult, StatusDelta, StreamMessage } from '../api.ts'
+import { colorToHex } from '../api.ts'
+
+type EventLogProps = {
+  messages: StreamMessage[]
+  onClear: () => void
+}
+
+function describe(message: StreamMessage): string {
+  switch (message.type) {
+    case 'status': {
+      const delta = message.data as StatusDelta
+      const changes: string[] = []
+      if (delta.on !== undefined) changes.push(delta.on === 1 ? 'on' : delta.on === 0 ? 'off' : 'on/off unknown')
+      if (delta.brightness !== undefined) changes.push(`brightness ${delta.brightness}%`)
+      if (delta.color !== undefined) changes.push(`color ${colorToHex(delta.color)}`)
+      return changes.join(', ') + (delta.origin ? ` (${delta.origin})` : '')
+    }
+    case 'dispatch': {
+      const result = message.data as DispatchResult
+      const failed = Object.entries(result.failed ?? {})
+      if (failed.length === 0) {
+        return `${result.succeeded?.length ?? 0} devices served`
+      }
+      return `failed on ${failed.map(([device, error]) => `${device}: ${error}`).join('; ')}`
+    }
+    default:
+      return message.data === undefined ? '' : JSON.stringify(message.data)
+  }
+}
+
+function EventLog({ messages, onClear }: EventLogProps) {
+  return (
+    <section className="panel event-log">
+      <header className="panel-header">
+        <h2>Live events</h2>
+        <button onClick={onClear} disabled={messages.length === 0}>
+          Clear
+        </button>
+      </header>
+      {messages.length === 0 ? (
+        <p className="muted">Nothing happened yet.</p>
+      ) : (
+        <ol>
+          {messages.map((message) => (
+            <li key={message.id} className={`event event-${message.type.replace(/\s+/g, '-')}`}>
+              <time dateTime={message.time}>{new Date(message.time).toLocaleTimeString()}</time>
+              <span className="event-type">{message.type}</span>
+              <strong>{message.source}</strong>
+              <span>{describe(message)}</span>
+            </li>
+          ))}
+        </ol>
+      )}
+    </section>
+  )
+}
+
+export default EventLog
@@ -6,10 +6,13 @@ import (
 )
 
 type deviceStatus struct {
-	Provider   string      `json:"provider"`
-	On         int         `json:"on"` // -1=unknown, 0=off, 1=on
-	Brightness int         `json:"brightness"`
-	Color      deviceColor `json:"color"`
+	Provider   string       `json:"provider"`
+	On         int          `json:"on"` // -1=unknown, 0=off, 1=on
+	Brightness int          `json:"brightness"`
+	Color      deviceColor  `json:"color"`
+	Reachable  int          `json:"reachable"`        // -1=unknown, 0=unreachable, 1=reachable
+	Model      string       `json:"model,omitempty"`  // As reported by the device, e.g. the Govee SKU
+	Origin     ChangeOrigin `json:"origin,omitempty"` // Who caused the last change
 }
 
 type deviceColor struct {
@@ -22,6 +25,7 @@ func getDefaultDeviceStatus() deviceStatus {
 	return deviceStatus{
 		Brightness: -1,
 		On:         -1,
+		Reachable:  -1,
 		Color: deviceColor{
 			Red:   -1,
 			Green: -1,
@@ -30,13 +34,34 @@ func getDefaultDeviceStatus() deviceStatus {
 	}
 }
 
+type groupOnState string
+
+const (
+	groupOnStateUnknown groupOnState = "unknown"
+	groupOnStateAllOn   groupOnState = "all on"
+	groupOnStateSomeOn  groupOnState = "some on"
+	groupOnStateOff     groupOnState = "off"
+)
+
+type groupStatus struct {
+	Members    []string     `json:"members"`
+	On         groupOnState `json:"on"`
+	Brightness int          `json:"brightness"` // Average brightness of the members with a known brightness, -1 if none
+}
+
 type status struct {
 	mtx      struct{ sync.RWMutex }
 	statuses map[string]deviceStatus
+	groups   map[string][]string
+
+	subscribers      map[int]chan string // Receive the names of the changed devices
+	nextSubscriberID int
 }
 
 var Status = &status{
-	statuses: make(map[string]deviceStatus),
+	statuses:    make(map[string]deviceStatus),
+	groups:      make(map[string][]string),
+	subscribers: make(map[int]chan string),
 }
 
 func (s *status) Register(device, provider string) {
@@ -50,6 +75,12 @@ func (s *status) Register(device, provider string) {
 	}
 }
 
+func (s *status) RegisterGroup(group string, devices []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.groups[group] = devices
+}
+
 func (s *status) SetBrightness(device string, brightness int) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -59,7 +90,10 @@ func (s *status) SetBrightness(device string, brightness int) {
 		s.statuses[device] = ds
 	}
 	ds.Brightness = brightness
+	ds.Origin = ChangeOriginSync
 	s.statuses[device] = ds
+	s.notify(device)
+	SyncGuard.RecordWrite(device, DeviceState{Brightness: &brightness})
 }
 
 func (s *status) SetOn(device string, on bool) {
@@ -75,7 +109,10 @@ func (s *status) SetOn(device string, on bool) {
 	} else {
 		ds.On = 0
 	}
+	ds.Origin = ChangeOriginSync
 	s.statuses[device] = ds
+	s.notify(device)
+	SyncGuard.RecordWrite(device, DeviceState{On: &on})
 }
 
 func (s *status) SetColor(device string, red, green, blue int) {
@@ -89,7 +126,105 @@ func (s *status) SetColor(device string, red, green, blue int) {
 	ds.Color.Red = red
 	ds.Color.Green = green
 	ds.Color.Blue = blue
+	ds.Origin = ChangeOriginSync
 	s.statuses[device] = ds
+	s.notify(device)
+	SyncGuard.RecordWrite(device, DeviceState{Color: &deviceColor{Red: red, Green: green, Blue: blue}})
+}
+
+// SetReachable records whether the last exchange with the device succeeded.
+// Unlike the other setters it only notifies the subscribers when the reachability actually changes.
+func (s *status) SetReachable(device string, reachable bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	ds, ok := s.statuses[device]
+	if !ok {
+		return
+	}
+	value := 0
+	if reachable {
+		value = 1
+	}
+	if ds.Reachable == value {
+		return
+	}
+	ds.Reachable = value
+	s.statuses[device] = ds
+	s.notify(device)
+}
+
+// SetModel records the model reported by the device, notifying the subscribers only when it changes
+func (s *status) SetModel(device, model string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	ds, ok := s.statuses[device]
+	if !ok || ds.Model == model {
+		return
+	}
+	ds.Model = model
+	s.statuses[device] = ds
+	s.notify(device)
+}
+
+// Observe stores the state actually read from the device: unlike the setters, it is not recorded as our own write
+func (s *status) Observe(device string, state DeviceState, origin ChangeOrigin) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	ds, ok := s.statuses[device]
+	if !ok {
+		ds = getDefaultDeviceStatus()
+	}
+	if state.On != nil {
+		ds.On = 0
+		if *state.On {
+			ds.On = 1
+		}
+	}
+	if state.Brightness != nil {
+		ds.Brightness = *state.Brightness
+	}
+	if state.Color != nil {
+		ds.Color = *state.Color
+	}
+	ds.Origin = origin
+	s.statuses[device] = ds
+	s.notify(device)
+}
+
+// Subscribe returns the channel receiving the names of the devices whose status changes,
+// and the function to call to stop receiving them
+func (s *status) Subscribe() (<-chan string, func()) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	id := s.nextSubscriberID
+	s.nextSubscriberID++
+	subscriber := make(chan string, 100)
+	s.subscribers[id] = subscriber
+	return subscriber, func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+// notify must be called with the mutex held: it never blocks, subscribers too slow to keep up lose notifications
+func (s *status) notify(device string) {
+	for _, subscriber := range s.subscribers {
+		select {
+		case subscriber <- device:
+		default:
+		}
+	}
+}
+
+func (s *status) Get(device string) (deviceStatus, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	ds, ok := s.statuses[device]
+	if !ok {
+		return getDefaultDeviceStatus(), false
+	}
+	return ds, true
 }
 
 func (s *status) GetAll() map[string]deviceStatus {
@@ -99,3 +234,80 @@ func (s *status) GetAll() map[string]deviceStatus {
 	maps.Copy(copied, s.statuses)
 	return copied
 }
+
+func (s *status) GetGroup(group string) (groupStatus, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	devices, ok := s.groups[group]
+	if !ok {
+		return groupStatus{}, false
+	}
+	return s.aggregate(devices), true
+}
+
+func (s *status) GetAllGroups() map[string]groupStatus {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	groups := make(map[string]groupStatus, len(s.groups))
+	for group, devices := range s.groups {
+		groups[group] = s.aggregate(devices)
+	}
+	return groups
+}
+
+// aggregate must be called with the mutex held
+func (s *status) aggregate(devices []string) groupStatus {
+	gs := groupStatus{
+		Members:    devices,
+		On:         groupOnStateUnknown,
+		Brightness: -1,
+	}
+
+	var onCount, offCount, brightnessCount, brightnessSum int
+	for _, device := range devices {
+		ds, ok := s.statuses[device]
+		if !ok {
+			continue
+		}
+		switch ds.On {
+		case 1:
+			onCount++
+		case 0:
+			offCount++
+		}
+		if ds.Brightness != -1 {
+			brightnessCount++
+			brightnessSum += ds.Brightness
+		}
+	}
+
+	switch {
+	case onCount > 0 && onCount == len(devices):
+		gs.On = groupOnStateAllOn
+	case onCount > 0:
+		gs.On = groupOnStateSomeOn
+	case offCount > 0:
+		gs.On = groupOnStateOff
+	}
+
+	if brightnessCount > 0 {
+		gs.Brightness = brightnessSum / brightnessCount
+	}
+
+	return gs
+}
+
+// ToDeviceState converts the known attributes of the status into a DeviceState
+func (ds deviceStatus) ToDeviceState() DeviceState {
+	var state DeviceState
+	if ds.On != -1 {
+		state.On = valToPtr(ds.On == 1)
+	}
+	if ds.Brightness != -1 {
+		state.Brightness = valToPtr(ds.Brightness)
+	}
+	if ds.Color.Red != -1 && ds.Color.Green != -1 && ds.Color.Blue != -1 {
+		state.Color = valToPtr(ds.Color)
+	}
+	return state
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	streamHistorySize       = 500 // Messages kept for resuming clients
+	streamClientBufferSize  = 256
+	streamKeepAliveInterval = 15 * time.Second
+	streamWriteTimeout      = 10 * time.Second
+)
+
+// StreamHub turns status changes, Hue events and dispatch results into numbered messages and fans them out
+// to the stream clients. The latest messages are kept so that clients reconnecting with the last ID they
+// received get what they missed; those too far behind get a fresh snapshot instead.
+type StreamHub struct {
+	mtx          struct{ sync.Mutex }
+	lastID       uint64
+	history      []StreamMessage
+	lastStatus   map[string]deviceStatus // Last status streamed for each device, to compute the deltas
+	clients      map[int]*streamClient
+	nextClientID int
+}
+
+// streamFilter selects the messages a client is interested in: empty lists match everything
+type streamFilter struct {
+	devices []string
+	types   []StreamMessageType
+}
+
+type streamClient struct {
+	filter   streamFilter
+	messages chan StreamMessage
+	dropped  chan struct{} // Closed when the client cannot keep up and gets disconnected
+}
+
+func NewStreamHub() *StreamHub {
+	return &StreamHub{
+		lastStatus: Status.GetAll(),
+		clients:    make(map[int]*streamClient),
+	}
+}
+
+// Start collects the messages until the context is cancelled
+func (h *StreamHub) Start(ctx context.Context) error {
+	statusChanges, unsubscribeStatus := Status.Subscribe()
+	defer unsubscribeStatus()
+	events, unsubscribeEvents := Events.Subscribe()
+	defer unsubscribeEvents()
+	dispatches, unsubscribeDispatches := Dispatches.Subscribe()
+	defer unsubscribeDispatches()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case device := <-statusChanges:
+			h.publishStatus(device)
+		case event := <-events:
+			h.publish(StreamMessage{Type: StreamMessageType(event.Type), Source: event.Source, Time: event.Time, Data: event.Data})
+		case result := <-dispatches:
+			h.publish(StreamMessage{Type: StreamMessageTypeDispatch, Source: result.Name, Time: result.StartedAt.Add(result.Duration), Data: result})
+		}
+	}
+}
+
+func (h *StreamHub) publishStatus(device string) {
+	current, ok := Status.Get(device)
+	if !ok {
+		return
+	}
+
+	h.mtx.Lock()
+	previous, known := h.lastStatus[device]
+	h.lastStatus[device] = current
+	h.mtx.Unlock()
+
+	var delta StreamStatusDelta
+	if !known || current.On != previous.On {
+		delta.On = valToPtr(current.On)
+	}
+	if !known || current.Brightness != previous.Brightness {
+		delta.Brightness = valToPtr(current.Brightness)
+	}
+	if !known || current.Color != previous.Color {
+		delta.Color = valToPtr(current.Color)
+	}
+	if !known || current.Reachable != previous.Reachable {
+		delta.Reachable = valToPtr(current.Reachable)
+	}
+	if delta.On == nil && delta.Brightness == nil && delta.Color == nil && delta.Reachable == nil {
+		return
+	}
+	delta.Origin = current.Origin
+
+	h.publish(StreamMessage{Type: StreamMessageTypeStatus, Source: device, Time: time.Now(), Data: delta})
+}
+
+func (h *StreamHub) publish(message StreamMessage) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.lastID++
+	message.ID = h.lastID
+	h.history = append(h.history, message)
+	if len(h.history) > streamHistorySize {
+		h.history = h.history[len(h.history)-streamHistorySize:]
+	}
+
+	for id, client := range h.clients {
+		if !client.filter.matches(message) {
+			continue
+		}
+		select {
+		case client.messages <- message:
+		default:
+			// Never block the hub: the client will reconnect and resume from its last ID
+			close(client.dropped)
+			delete(h.clients, id)
+		}
+	}
+}
+
+// subscribe registers a client and returns what it has to receive before the live messages: the messages
+// it missed since lastID or, when resuming is not possible, a snapshot of the status of every device
+func (h *StreamHub) subscribe(filter streamFilter, lastID *uint64) ([]StreamMessage, *streamClient, func()) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	var backlog []StreamMessage
+	canResume := lastID != nil && *lastID <= h.lastID &&
+		(*lastID == h.lastID || (len(h.history) > 0 && h.history[0].ID <= *lastID+1))
+	if canResume {
+		for _, message := range h.history {
+			if message.ID > *lastID && filter.matches(message) {
+				backlog = append(backlog, message)
+			}
+		}
+	} else {
+		snapshot := make(map[string]deviceStatus)
+		for device, deviceStatus := range Status.GetAll() {
+			if filter.matchesDevice(device) {
+				snapshot[device] = deviceStatus
+			}
+		}
+		backlog = append(backlog, StreamMessage{ID: h.lastID, Type: StreamMessageTypeSnapshot, Time: time.Now(), Data: snapshot})
+	}
+
+	id := h.nextClientID
+	h.nextClientID++
+	client := &streamClient{
+		filter:   filter,
+		messages: make(chan StreamMessage, streamClientBufferSize),
+		dropped:  make(chan struct{}),
+	}
+	h.clients[id] = client
+
+	return backlog, client, func() {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		delete(h.clients, id)
+	}
+}
+
+func (f streamFilter) matches(message StreamMessage) bool {
+	if len(f.types) > 0 && !slices.Contains(f.types, message.Type) {
+		return false
+	}
+	if len(f.devices) == 0 {
+		return true
+	}
+	if message.Type == StreamMessageTypeDispatch {
+		result, _ := message.Data.(DispatchResult)
+		for device := range result.Failed {
+			if f.matchesDevice(device) {
+				return true
+			}
+		}
+		return slices.ContainsFunc(result.Succeeded, f.matchesDevice)
+	}
+	return f.matchesDevice(message.Source)
+}
+
+func (f streamFilter) matchesDevice(device string) bool {
+	return len(f.devices) == 0 || slices.Contains(f.devices, device)
+}
+
+// parseStreamRequest reads the filter from the "devices" and "types" query parameters (comma separated or repeated)
+// and the ID to resume from, from the Last-Event-ID header (sent by EventSource when reconnecting) or the
+// "last_event_id" query parameter
+func parseStreamRequest(r *http.Request) (streamFilter, *uint64, error) {
+	var filter streamFilter
+	query := r.URL.Query()
+	for _, devices := range query["devices"] {
+		for device := range strings.SplitSeq(devices, ",") {
+			if device = strings.TrimSpace(device); device != "" {
+				filter.devices = append(filter.devices, device)
+			}
+		}
+	}
+	for _, types := range query["types"] {
+		for messageType := range strings.SplitSeq(types, ",") {
+			if messageType = strings.TrimSpace(messageType); messageType != "" {
+				filter.types = append(filter.types, StreamMessageType(messageType))
+			}
+		}
+	}
+
+	rawLastID := r.Header.Get("Last-Event-ID")
+	if rawLastID == "" {
+		rawLastID = query.Get("last_event_id")
+	}
+	if rawLastID == "" {
+		return filter, nil, nil
+	}
+	lastID, err := strconv.ParseUint(rawLastID, 10, 64)
+	if err != nil {
+		return filter, nil, fmt.Errorf("invalid last event ID %q", rawLastID)
+	}
+	return filter, &lastID, nil
+}
+
+var streamUpgrader = websocket.Upgrader{
+	// The API is meant to be reachable by any dashboard on the network
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleStream serves the stream as WebSocket when the client asks for an upgrade, as Server-Sent Events otherwise
+func (s *HTTPServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	filter, lastID, err := parseStreamRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, HTTPErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWebSocketStream(w, r, filter, lastID)
+		return
+	}
+	s.serveSSEStream(w, r, filter, lastID)
+}
+
+func (s *HTTPServer) serveSSEStream(w http.ResponseWriter, r *http.Request, filter streamFilter, lastID *uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, HTTPErrorCodeInternal, "streaming not supported")
+		return
+	}
+
+	backlog, client, unsubscribe := s.stream.subscribe(filter, lastID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	writeMessage := func(message StreamMessage) error {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("error encoding stream message: %w", err)
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", message.ID, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, message := range backlog {
+		if err := writeMessage(message); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.dropped:
+			log.Warn().Msgf("Stream client %s too slow, disconnected", r.RemoteAddr)
+			return
+		case message := <-client.messages:
+			if err := writeMessage(message); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *HTTPServer) serveWebSocketStream(w http.ResponseWriter, r *http.Request, filter streamFilter, lastID *uint64) {
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered with an error
+		log.Err(err).Msgf("error upgrading stream to WebSocket: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	backlog, client, unsubscribe := s.stream.subscribe(filter, lastID)
+	defer unsubscribe()
+
+	// Clients are not expected to send anything: reading is only needed to process control frames and notice closing
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	writeMessage := func(message StreamMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(message)
+	}
+
+	for _, message := range backlog {
+		if err := writeMessage(message); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-s.ctx.Done():
+			// Hijacked connections are not closed by the server: say goodbye when shutting down
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"), time.Now().Add(time.Second))
+			return
+		case <-client.dropped:
+			log.Warn().Msgf("Stream client %s too slow, disconnected", r.RemoteAddr)
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+			return
+		case message := <-client.messages:
+			if err := writeMessage(message); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
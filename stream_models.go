@@ -0,0 +1,31 @@
+package main
+
+import "time"
+
+// StreamMessageType is "snapshot", "status", "dispatch" or the type of a Hue event (e.g. "button pressed")
+type StreamMessageType string
+
+const (
+	StreamMessageTypeSnapshot StreamMessageType = "snapshot"
+	StreamMessageTypeStatus   StreamMessageType = "status"
+	StreamMessageTypeDispatch StreamMessageType = "dispatch"
+)
+
+// StreamMessage is pushed to the clients of /api/v1/stream. IDs grow by one for every message, whatever
+// the client filters: a client can resume from the last ID it received.
+type StreamMessage struct {
+	ID     uint64            `json:"id"`
+	Type   StreamMessageType `json:"type"`
+	Source string            `json:"source,omitempty"` // Device for "status", dial/sensor/light/group for Hue events, dispatch name for "dispatch"
+	Time   time.Time         `json:"time"`
+	Data   any               `json:"data,omitempty"`
+}
+
+// StreamStatusDelta carries only the attributes of the device status that changed
+type StreamStatusDelta struct {
+	On         *int         `json:"on,omitempty"`
+	Brightness *int         `json:"brightness,omitempty"`
+	Color      *deviceColor `json:"color,omitempty"`
+	Reachable  *int         `json:"reachable,omitempty"`
+	Origin     ChangeOrigin `json:"origin,omitempty"`
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/rs/zerolog/log"
@@ -13,9 +16,12 @@ type SwitchbotConnection struct {
 }
 
 type SwitchbotCommandSender interface {
-	SendMsg(message SwitchbotMessage) error
+	SendMsg(ctx context.Context, message SwitchbotMessage) error
 }
 
+var _ SwitchbotCommandSender = (*SwitchbotConnection)(nil)
+var _ DeviceStateReader = (*SwitchbotConnection)(nil)
+
 func NewSwitchbotConnection(configuration Configuration) *SwitchbotConnection {
 	devices := configuration.Switchbot
 	return &SwitchbotConnection{
@@ -23,10 +29,10 @@ func NewSwitchbotConnection(configuration Configuration) *SwitchbotConnection {
 	}
 }
 
-func (c *SwitchbotConnection) SendMsg(msg SwitchbotMessage) error {
+func (c *SwitchbotConnection) SendMsg(ctx context.Context, msg SwitchbotMessage) error {
 	device, ok := c.devices[msg.Device]
 	if !ok {
-		return fmt.Errorf("device not found: %s", msg.Device)
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, msg.Device)
 	}
 
 	body := fmt.Sprintf(`{"command":"%s","parameter":"%s","commandType":"%s"}`, msg.Command, msg.Parameter, msg.CommandType)
@@ -36,7 +42,7 @@ func (c *SwitchbotConnection) SendMsg(msg SwitchbotMessage) error {
 		Str("body", body).
 		Msgf("Sending message to switchbot device")
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://api.switch-bot.com/v1.0/devices/%s/commands", device.DeviceID), strings.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://api.switch-bot.com/v1.0/devices/%s/commands", device.DeviceID), strings.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("error creating request: %v", err)
 	}
@@ -47,9 +53,70 @@ func (c *SwitchbotConnection) SendMsg(msg SwitchbotMessage) error {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return checkHTTPResponse(resp)
+}
+
+// ReadState retrieves the device status from the cloud API: mind the daily requests quota when polling it
+func (c *SwitchbotConnection) ReadState(ctx context.Context, deviceName string) (DeviceState, error) {
+	device, ok := c.devices[deviceName]
+	if !ok {
+		return DeviceState{}, fmt.Errorf("%w: %s", ErrDeviceNotFound, deviceName)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://api.switch-bot.com/v1.0/devices/%s/status", device.DeviceID), nil)
+	if err != nil {
+		return DeviceState{}, fmt.Errorf("error creating request: %v", err)
+	}
+
+	req.Header.Set("Authorization", device.Authorization.Token)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return DeviceState{}, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	return nil
+	if err := checkHTTPResponse(resp); err != nil {
+		return DeviceState{}, err
+	}
+
+	var status SwitchbotStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return DeviceState{}, fmt.Errorf("error decoding response: %v", err)
+	}
+	if status.StatusCode != 100 {
+		return DeviceState{}, fmt.Errorf("error retrieving status: %s", status.Message)
+	}
+
+	state := DeviceState{
+		On:         valToPtr(status.Body.Power == "on"),
+		Brightness: valToPtr(status.Body.Brightness),
+	}
+	// The color is reported as "r:g:b"
+	if parts := strings.Split(status.Body.Color, ":"); len(parts) == 3 {
+		r, errR := strconv.Atoi(parts[0])
+		g, errG := strconv.Atoi(parts[1])
+		b, errB := strconv.Atoi(parts[2])
+		if errR == nil && errG == nil && errB == nil {
+			state.Color = &deviceColor{Red: r, Green: g, Blue: b}
+		}
+	}
+	return state, nil
+}
+
+type SwitchbotStatusResponse struct {
+	StatusCode int                         `json:"statusCode"`
+	Message    string                      `json:"message"`
+	Body       SwitchbotStatusResponseBody `json:"body"`
+}
+
+type SwitchbotStatusResponseBody struct {
+	Power      string `json:"power"`
+	Brightness int    `json:"brightness"`
+	Color      string `json:"color"`
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ChangeOrigin tells who caused a state change
+type ChangeOrigin string
+
+const (
+	ChangeOriginHue    ChangeOrigin = "hue"    // Observed on the Hue bridge
+	ChangeOriginDevice ChangeOrigin = "device" // Observed on a Govee/WLED/Switchbot/Twinkly device (its own app or button)
+	ChangeOriginSync   ChangeOrigin = "sync"   // Written by this program
+)
+
+const defaultSuppressionWindow = 3 * time.Second
+
+// Tolerances used to recognize our own writes when they are read back: both Hue and the devices
+// round brightness and convert colors, so the value read back rarely matches the written one
+const (
+	echoBrightnessTolerance = 2  // Percentage points
+	echoColorTolerance      = 12 // Per RGB channel
+)
+
+type guardedWrite struct {
+	state     DeviceState
+	writtenAt time.Time
+}
+
+type guardedChange struct {
+	origin    ChangeOrigin
+	changedAt time.Time
+}
+
+// syncGuard prevents loops in bidirectional sync: it remembers what we wrote to each target (Hue light or group, or device)
+// so that reading it back within the suppression window is not mistaken for a new change,
+// and it remembers who changed each target last to resolve conflicts between the two sides.
+type syncGuard struct {
+	mtx     struct{ sync.Mutex }
+	window  time.Duration
+	writes  map[string]guardedWrite
+	changes map[string]guardedChange
+}
+
+var SyncGuard = &syncGuard{
+	window:  defaultSuppressionWindow,
+	writes:  make(map[string]guardedWrite),
+	changes: make(map[string]guardedChange),
+}
+
+func (g *syncGuard) SetWindow(window time.Duration) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.window = window
+}
+
+// RecordWrite merges the written attributes into the ones written previously (if still within the window).
+// A change observed on the target within the window stays its last change: the conflicts with it are resolved
+// by its origin, not by what we wrote meanwhile.
+func (g *syncGuard) RecordWrite(target string, state DeviceState) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	now := time.Now()
+	write, ok := g.writes[target]
+	if !ok || now.Sub(write.writtenAt) > g.window {
+		write = guardedWrite{}
+	}
+	if state.On != nil {
+		write.state.On = state.On
+	}
+	if state.Brightness != nil {
+		write.state.Brightness = state.Brightness
+	}
+	if state.Color != nil {
+		write.state.Color = state.Color
+	}
+	write.writtenAt = now
+	g.writes[target] = write
+	if change, ok := g.changes[target]; ok && change.origin != ChangeOriginSync && now.Sub(change.changedAt) <= g.window {
+		return
+	}
+	g.changes[target] = guardedChange{origin: ChangeOriginSync, changedAt: now}
+}
+
+// IsEcho tells whether the observed state is just our own recent write being read back.
+// Only the attributes we wrote are compared: an observed attribute we never wrote is a real change.
+func (g *syncGuard) IsEcho(target string, observed DeviceState) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	write, ok := g.writes[target]
+	if !ok || time.Since(write.writtenAt) > g.window {
+		return false
+	}
+
+	if observed.On != nil && (write.state.On == nil || *write.state.On != *observed.On) {
+		return false
+	}
+	if observed.Brightness != nil && (write.state.Brightness == nil || math.Abs(float64(*write.state.Brightness-*observed.Brightness)) > echoBrightnessTolerance) {
+		return false
+	}
+	if observed.Color != nil {
+		if write.state.Color == nil {
+			return false
+		}
+		for _, delta := range []int{
+			write.state.Color.Red - observed.Color.Red,
+			write.state.Color.Green - observed.Color.Green,
+			write.state.Color.Blue - observed.Color.Blue,
+		} {
+			if math.Abs(float64(delta)) > echoColorTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// RecordChange remembers that a real (not echoed) change has been observed on the target
+func (g *syncGuard) RecordChange(target string, origin ChangeOrigin) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.changes[target] = guardedChange{origin: origin, changedAt: time.Now()}
+}
+
+// ChangedRecentlyBy tells whether the last change of the target, within the window, came from the given origin
+func (g *syncGuard) ChangedRecentlyBy(target string, origin ChangeOrigin) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	change, ok := g.changes[target]
+	return ok && change.origin == origin && time.Since(change.changedAt) <= g.window
+}
+
+func hueLightGuardTarget(source HueSyncSource) string {
+	if source.Group {
+		return "hue group/" + source.Name
+	}
+	return "hue light/" + source.Name
+}
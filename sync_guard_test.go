@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestSyncGuard() *syncGuard {
+	return &syncGuard{
+		window:  defaultSuppressionWindow,
+		writes:  make(map[string]guardedWrite),
+		changes: make(map[string]guardedChange),
+	}
+}
+
+func TestSyncGuardIsEcho(t *testing.T) {
+	written := DeviceState{
+		On:         valToPtr(true),
+		Brightness: valToPtr(60),
+		Color:      &deviceColor{Red: 255, Green: 128, Blue: 0},
+	}
+
+	for _, test := range []struct {
+		name     string
+		age      time.Duration // Of the write when the state is observed
+		observed DeviceState
+		expected bool
+	}{
+		{"same value within the window", 0, written, true},
+		{"rounded value within the window", 0, DeviceState{Brightness: valToPtr(58), Color: &deviceColor{Red: 250, Green: 135, Blue: 10}}, true},
+		{"subset of the written attributes", time.Second, DeviceState{On: valToPtr(true)}, true},
+		{"same value after the window", defaultSuppressionWindow + time.Second, written, false},
+		{"different on within the window", 0, DeviceState{On: valToPtr(false)}, false},
+		{"different brightness within the window", 0, DeviceState{Brightness: valToPtr(40)}, false},
+		{"different color within the window", 0, DeviceState{Color: &deviceColor{Red: 0, Green: 128, Blue: 0}}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			guard := newTestSyncGuard()
+			guard.RecordWrite("lamp", written)
+			guard.writes["lamp"] = guardedWrite{state: guard.writes["lamp"].state, writtenAt: time.Now().Add(-test.age)}
+
+			if echo := guard.IsEcho("lamp", test.observed); echo != test.expected {
+				t.Errorf("expected echo %v, got %v", test.expected, echo)
+			}
+			if guard.IsEcho("other", test.observed) {
+				t.Errorf("echo reported for a target never written")
+			}
+		})
+	}
+}
+
+func TestSyncGuardRecordWriteMerges(t *testing.T) {
+	guard := newTestSyncGuard()
+	guard.RecordWrite("lamp", DeviceState{On: valToPtr(true)})
+	guard.RecordWrite("lamp", DeviceState{Brightness: valToPtr(30)})
+	if !guard.IsEcho("lamp", DeviceState{On: valToPtr(true), Brightness: valToPtr(30)}) {
+		t.Errorf("attributes written within the window not merged")
+	}
+	if guard.IsEcho("lamp", DeviceState{Color: &deviceColor{Red: 255}}) {
+		t.Errorf("an attribute never written reported as echo")
+	}
+
+	// A write after the window starts over
+	guard.writes["lamp"] = guardedWrite{state: guard.writes["lamp"].state, writtenAt: time.Now().Add(-2 * defaultSuppressionWindow)}
+	guard.RecordWrite("lamp", DeviceState{Brightness: valToPtr(80)})
+	if guard.IsEcho("lamp", DeviceState{On: valToPtr(true)}) {
+		t.Errorf("attribute written before the window still reported as echo")
+	}
+	if !guard.ChangedRecentlyBy("lamp", ChangeOriginSync) {
+		t.Errorf("write not recorded as a change from the sync")
+	}
+}
+
+func TestSyncGuardRecordWriteKeepsObservedChange(t *testing.T) {
+	guard := newTestSyncGuard()
+	guard.RecordChange("lamp", ChangeOriginDevice)
+	guard.RecordWrite("lamp", DeviceState{On: valToPtr(false)})
+	if !guard.ChangedRecentlyBy("lamp", ChangeOriginDevice) {
+		t.Errorf("change observed on the device overwritten by our own write")
+	}
+
+	// Once the window is over, our write is the last change
+	guard.changes["lamp"] = guardedChange{origin: ChangeOriginDevice, changedAt: time.Now().Add(-2 * defaultSuppressionWindow)}
+	guard.RecordWrite("lamp", DeviceState{On: valToPtr(true)})
+	if !guard.ChangedRecentlyBy("lamp", ChangeOriginSync) {
+		t.Errorf("write after the window not recorded as a change from the sync")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type TransitionAttribute string
+
+const (
+	TransitionAttributeBrightness TransitionAttribute = "brightness"
+	TransitionAttributeColor      TransitionAttribute = "color"
+)
+
+// transitionStepIntervals is the minimum delay between two consecutive stepped updates sent to the same device.
+// Providers that are not listed either support transitions natively (WLED, Hue) or cannot be stepped at all
+// without hitting their rate limits (Switchbot cloud API) or lacking the feature (Twinkly).
+var transitionStepIntervals = map[string]time.Duration{
+	ProviderGovee: 200 * time.Millisecond,
+}
+
+// Transition describes a software fade of a single attribute: the device is brought from the "from" value
+// to the "to" value over the given duration
+type Transition struct {
+	Duration       time.Duration
+	Attribute      TransitionAttribute
+	FromBrightness int
+	ToBrightness   int
+	FromColor      deviceColor
+	ToColor        deviceColor
+}
+
+// TransitionStep is a single intermediate value of a transition: only the field of the transition attribute is set
+type TransitionStep struct {
+	Brightness *int
+	Color      *deviceColor
+}
+
+type transitionKey struct {
+	device    string
+	attribute TransitionAttribute
+}
+
+type transitionEngine struct {
+	mtx       struct{ sync.Mutex }
+	running   map[transitionKey]context.CancelFunc
+	nextSlots map[string]time.Time // Key is device name: when the next step can be sent
+}
+
+var Transitions = &transitionEngine{
+	running:   make(map[transitionKey]context.CancelFunc),
+	nextSlots: make(map[string]time.Time),
+}
+
+// NewBrightnessTransition returns nil when no transition is needed (zero duration, unknown starting value
+// or nothing to interpolate), so that callers can fall back to an instant update
+func NewBrightnessTransition(duration time.Duration, from, to int) *Transition {
+	if duration <= 0 || from < 0 || from == to {
+		return nil
+	}
+	return &Transition{
+		Duration:       duration,
+		Attribute:      TransitionAttributeBrightness,
+		FromBrightness: from,
+		ToBrightness:   to,
+	}
+}
+
+// NewColorTransition returns nil when no transition is needed, like NewBrightnessTransition
+func NewColorTransition(duration time.Duration, from, to deviceColor) *Transition {
+	if duration <= 0 || from.Red < 0 || from.Green < 0 || from.Blue < 0 || from == to {
+		return nil
+	}
+	return &Transition{
+		Duration:  duration,
+		Attribute: TransitionAttributeColor,
+		FromColor: from,
+		ToColor:   to,
+	}
+}
+
+// Run starts the transition in background, replacing any transition of the same attribute already running on the device.
+// The last step always carries the target value.
+func (t *transitionEngine) Run(ctx context.Context, device, provider string, transition Transition, send func(TransitionStep) error) {
+	interval, ok := transitionStepIntervals[provider]
+	if !ok {
+		interval = transition.Duration
+	}
+
+	key := transitionKey{device: device, attribute: transition.Attribute}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	t.mtx.Lock()
+	if previousCancel, found := t.running[key]; found {
+		previousCancel()
+	}
+	t.running[key] = cancel
+	t.mtx.Unlock()
+
+	steps := max(int(transition.Duration/interval), 1)
+
+	go func() {
+		defer func() {
+			t.mtx.Lock()
+			// The transition may have already been replaced by a newer one
+			if ctx.Err() == nil {
+				delete(t.running, key)
+			}
+			t.mtx.Unlock()
+			cancel()
+		}()
+
+		startedAt := time.Now()
+		for step := 1; step <= steps; step++ {
+			if !t.waitSlot(ctx, device, interval, startedAt.Add(time.Duration(step)*transition.Duration/time.Duration(steps))) {
+				return
+			}
+			if err := send(transition.Step(float64(step) / float64(steps))); err != nil {
+				log.Err(err).Msgf("error sending transition step to device %s", device)
+			}
+		}
+	}()
+}
+
+// Cancel stops a running transition, e.g. because an instant update of the same attribute is about to be sent
+func (t *transitionEngine) Cancel(device string, attribute TransitionAttribute) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	key := transitionKey{device: device, attribute: attribute}
+	if cancel, found := t.running[key]; found {
+		cancel()
+		delete(t.running, key)
+	}
+}
+
+// waitSlot sleeps until the scheduled time of the step, while also keeping steps sent to the same device
+// (maybe by transitions of different attributes) at least one interval apart
+func (t *transitionEngine) waitSlot(ctx context.Context, device string, interval time.Duration, scheduledAt time.Time) bool {
+	t.mtx.Lock()
+	slot := t.nextSlots[device]
+	if slot.Before(scheduledAt) {
+		slot = scheduledAt
+	}
+	t.nextSlots[device] = slot.Add(interval)
+	t.mtx.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(time.Until(slot)):
+		return true
+	}
+}
+
+// Step linearly interpolates the transition at the given progress (0-1)
+func (t Transition) Step(progress float64) TransitionStep {
+	progress = math.Min(math.Max(progress, 0), 1)
+	interpolate := func(from, to int) int {
+		return int(math.Round(float64(from) + (float64(to-from) * progress)))
+	}
+
+	switch t.Attribute {
+	case TransitionAttributeBrightness:
+		return TransitionStep{
+			Brightness: valToPtr(interpolate(t.FromBrightness, t.ToBrightness)),
+		}
+	case TransitionAttributeColor:
+		return TransitionStep{
+			Color: &deviceColor{
+				Red:   interpolate(t.FromColor.Red, t.ToColor.Red),
+				Green: interpolate(t.FromColor.Green, t.ToColor.Green),
+				Blue:  interpolate(t.FromColor.Blue, t.ToColor.Blue),
+			},
+		}
+	}
+	return TransitionStep{}
+}
+
+// wledTransition converts a duration into the WLED "tt" unit (tenths of a second)
+func wledTransition(duration time.Duration) int {
+	return int(duration / (100 * time.Millisecond))
+}
+
+// hueTransitionTime converts a duration into the Hue "transitiontime" unit (tenths of a second)
+func hueTransitionTime(duration time.Duration) uint16 {
+	return uint16(duration / (100 * time.Millisecond))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestTransitionEngine() *transitionEngine {
+	return &transitionEngine{
+		running:   make(map[transitionKey]context.CancelFunc),
+		nextSlots: make(map[string]time.Time),
+	}
+}
+
+func TestTransitionStep(t *testing.T) {
+	brightness := Transition{Attribute: TransitionAttributeBrightness, FromBrightness: 10, ToBrightness: 60}
+	color := Transition{
+		Attribute: TransitionAttributeColor,
+		FromColor: deviceColor{Red: 255, Green: 0, Blue: 100},
+		ToColor:   deviceColor{Red: 0, Green: 255, Blue: 100},
+	}
+
+	for _, test := range []struct {
+		progress   float64
+		brightness int
+		color      deviceColor
+	}{
+		{0, 10, deviceColor{Red: 255, Green: 0, Blue: 100}},
+		{0.25, 23, deviceColor{Red: 191, Green: 64, Blue: 100}},
+		{0.5, 35, deviceColor{Red: 128, Green: 128, Blue: 100}},
+		{1, 60, deviceColor{Red: 0, Green: 255, Blue: 100}},
+		// Progress out of range is clamped
+		{-1, 10, deviceColor{Red: 255, Green: 0, Blue: 100}},
+		{2, 60, deviceColor{Red: 0, Green: 255, Blue: 100}},
+	} {
+		step := brightness.Step(test.progress)
+		if step.Brightness == nil || *step.Brightness != test.brightness || step.Color != nil {
+			t.Errorf("brightness at %v: expected %d, got %+v", test.progress, test.brightness, step)
+		}
+		step = color.Step(test.progress)
+		if step.Color == nil || *step.Color != test.color || step.Brightness != nil {
+			t.Errorf("color at %v: expected %+v, got %+v", test.progress, test.color, step)
+		}
+	}
+}
+
+func TestTransitionReplacesRunningOne(t *testing.T) {
+	engine := newTestTransitionEngine()
+	ctx := t.Context()
+
+	first := make(chan TransitionStep, 100)
+	engine.Run(ctx, "lamp", ProviderGovee, *NewBrightnessTransition(10*time.Second, 0, 100), func(step TransitionStep) error {
+		first <- step
+		return nil
+	})
+	second := make(chan TransitionStep, 100)
+	engine.Run(ctx, "lamp", ProviderGovee, *NewBrightnessTransition(400*time.Millisecond, 0, 50), func(step TransitionStep) error {
+		second <- step
+		return nil
+	})
+
+	// The new transition is stepped every 200ms and ends on its target
+	for brightness := 0; brightness != 50; {
+		select {
+		case step := <-second:
+			brightness = *step.Brightness
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the new transition did not complete")
+		}
+	}
+	select {
+	case step := <-first:
+		t.Errorf("the replaced transition sent a step: %+v", step)
+	default:
+	}
+
+	// The transition unregisters itself right after its last step
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		engine.mtx.Lock()
+		running := len(engine.running)
+		engine.mtx.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("completed transitions still registered: %d", running)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGoveeTurnMessageCancelsEveryTransition(t *testing.T) {
+	engine := newTestTransitionEngine()
+	ctx := t.Context()
+
+	noop := func(TransitionStep) error { return nil }
+	engine.Run(ctx, "lamp", ProviderGovee, *NewBrightnessTransition(time.Hour, 0, 100), noop)
+	engine.Run(ctx, "lamp", ProviderGovee, *NewColorTransition(time.Hour, deviceColor{}, deviceColor{Red: 255}), noop)
+
+	message := NewGoveeTurnMessage("lamp", false)
+	for _, attribute := range message.Cancels {
+		engine.Cancel(message.Device, attribute)
+	}
+
+	engine.mtx.Lock()
+	defer engine.mtx.Unlock()
+	if len(engine.running) != 0 {
+		t.Errorf("turning off left transitions running: %v", engine.running)
+	}
+}
+
+func TestWledMessageTransitionIsOneShot(t *testing.T) {
+	brightness := 50
+	for _, test := range []struct {
+		transition time.Duration
+		expected   string
+	}{
+		{0, `{"bri":128}`},
+		{1500 * time.Millisecond, `{"bri":128,"tt":15}`},
+	} {
+		// "transition" would change the default fade of the device for the following requests too
+		message := NewWledMessageForDevice("strip").SetState(DeviceState{Brightness: &brightness}, test.transition)
+		if string(message.Body) != test.expected {
+			t.Errorf("transition %s: expected %s, got %s", test.transition, test.expected, message.Body)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"io"
+	"os"
 	"strings"
+	"sync/atomic"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,45 +21,88 @@ var (
 	blueTextStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("12"))
 )
 
+// TUI shows the live status of the devices and groups, and controls them through the dispatcher
 type TUI struct {
-	done         bool
-	logUpdated   chan string
+	done         atomic.Bool
+	logUpdated   chan tuiUpdateLog
 	modelUpdated chan struct{}
+
+	configuration           Configuration
+	dispatcher              *Dispatcher
+	wledBrightnessRetriever BrightnessRetriever
+	goveeConnection         *GoveeConnection // Lists the unconfigured devices to adopt
+
+	ctx context.Context // Lifetime of the program, used for dispatching
 }
 
-func NewTUI() *TUI {
+func NewTUI(configuration Configuration, dispatcher *Dispatcher, wledBrightnessRetriever BrightnessRetriever, goveeConnection *GoveeConnection) *TUI {
 	return &TUI{
-		done:         false,
-		logUpdated:   make(chan string, 100),   // Buffered channel to avoid blocking
-		modelUpdated: make(chan struct{}, 100), // Buffered channel to avoid blocking
+		logUpdated:              make(chan tuiUpdateLog, 100), // Buffered channel to avoid blocking
+		modelUpdated:            make(chan struct{}, 100),     // Buffered channel to avoid blocking
+		configuration:           configuration,
+		dispatcher:              dispatcher,
+		wledBrightnessRetriever: wledBrightnessRetriever,
+		goveeConnection:         goveeConnection,
 	}
 }
 
-// Write implements io.Writer interface to be able to receive logs from any logger (e.g. zerolog)
+// Write implements io.Writer interface to be able to receive logs from any logger
 func (t *TUI) Write(p []byte) (n int, err error) {
+	return t.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements zerolog.LevelWriter: the entries keep their level, for the log pane to filter them
+func (t *TUI) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
 	if t != nil {
-		if !t.done {
-			t.logUpdated <- string(p)
+		if !t.done.Load() {
+			t.logUpdated <- tuiUpdateLog{level: level, log: strings.TrimSpace(string(p))}
 		} else {
-			fmt.Print(string(p))
+			return zerolog.ConsoleWriter{Out: os.Stderr}.Write(p)
 		}
 	}
 	return len(p), nil
 }
 
+// UpdateTUI asks the TUI to reload its model. It never blocks: a pending update already reloads everything.
 func (t *TUI) UpdateTUI() {
 	if t != nil {
-		t.modelUpdated <- struct{}{}
+		select {
+		case t.modelUpdated <- struct{}{}:
+		default:
+		}
 	}
 }
 
-func (t *TUI) RunNewProgram(stdout, stderr io.Reader) error {
+func (t *TUI) RunNewProgram(ctx context.Context, stdout, stderr io.Reader) error {
+	t.ctx = ctx
+
+	// Every status change refreshes the device and group tables
+	statusChanges, unsubscribe := Status.Subscribe()
+	defer unsubscribe()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-statusChanges:
+				t.UpdateTUI()
+			}
+		}
+	}()
+
 	_, err := tea.NewProgram(
 		newModel(t, stdout, stderr),
-		// tea.WithAltScreen(),
+		tea.WithAltScreen(),
+		tea.WithContext(ctx),
 		// tea.WithMouseCellMotion(),
 	).Run()
-	t.done = true
+	t.done.Store(true)
+	if errors.Is(err, tea.ErrProgramKilled) && ctx.Err() != nil {
+		// Stopped from outside, e.g. by SIGTERM
+		return nil
+	}
 	return err
 }
 
@@ -64,14 +111,32 @@ func newModel(tui *TUI, stdout, stderr io.Reader) model {
 	return model{
 		tui:          tui,
 		serverOutput: NewServerOutputModel(stdout, stderr),
+		deviceTable:  NewDeviceTableModel(),
+		groupTable:   NewGroupTableModel(),
+		logPane:      NewLogPaneModel(),
 	}
 }
 
+// tuiFocus is the pane the keys act on
+type tuiFocus int
+
+const (
+	tuiFocusDevices tuiFocus = iota
+	tuiFocusGroups
+	tuiFocusLogs
+)
+
 type model struct {
-	tui          *TUI
-	serverOutput *ServerOutputModel
-	width        int
-	height       int
+	tui            *TUI
+	serverOutput   *ServerOutputModel
+	deviceTable    *DeviceTableModel
+	groupTable     *GroupTableModel
+	logPane        *LogPaneModel
+	commandPalette *CommandPaletteModel // Nil when closed
+	focus          tuiFocus
+	lastCommand    string // Feedback of the last control key
+	width          int
+	height         int
 }
 
 func (m model) Init() tea.Cmd {
@@ -83,16 +148,22 @@ func (m model) Init() tea.Cmd {
 func (m model) waitForUpdate() tea.Msg {
 	// whenever the noop updates its model (by enqueueing a message in the channel)
 	// we dispatch a tuiUpdateModel msg to update the TUI model and consequently the terminal's view
-	noop := <-m.tui.modelUpdated
-	return tuiUpdateModel(noop)
+	select {
+	case <-m.tui.ctx.Done():
+		return nil
+	case noop := <-m.tui.modelUpdated:
+		return tuiUpdateModel(noop)
+	}
 }
 
 func (m model) waitForLog() tea.Msg {
 	// whenever the logger writes into this channel
 	// we dispatch a tuiUpdateLog msg to update the TUI model and consequently the terminal's view
-	log := <-m.tui.logUpdated
-	return tuiUpdateLog{
-		log: strings.TrimSpace(log),
+	select {
+	case <-m.tui.ctx.Done():
+		return nil
+	case entry := <-m.tui.logUpdated:
+		return entry
 	}
 }
 
@@ -103,7 +174,8 @@ type tuiUpdateModel struct{}
 // tuiUpdateLog is the tea.Msg that gets dispatched when a new log is written,
 // and we need to reflect that in the TUI.
 type tuiUpdateLog struct {
-	log string
+	level zerolog.Level
+	log   string
 }
 
 // quit is the tea.Msg that gets dispatched when we want to exit the program.
@@ -119,60 +191,193 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// When we are about to quit, we also want to print the last status of the TUI model
 		return m, tea.Quit
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "q":
+		if msg.String() == "ctrl+c" {
 			return m, func() tea.Msg {
 				return quit{}
 			}
 		}
+		if m.commandPalette != nil {
+			m.commandPalette, otherCmd = m.commandPalette.Update(msg)
+			return m, otherCmd
+		}
+		if m.logPane.Editing() {
+			m.logPane, otherCmd = m.logPane.Update(msg)
+			return m, otherCmd
+		}
+		if msg.String() == "q" {
+			return m, func() tea.Msg {
+				return quit{}
+			}
+		}
+		// The keys drive the tables only: the server output would scroll with the same arrows
+		return m.handleKey(msg)
+	case commandPaletteSelected:
+		m.commandPalette = nil
+		m.lastCommand = m.tui.runPaletteEntry(msg.entry)
+	case commandPaletteClosed:
+		m.commandPalette = nil
 	case tea.WindowSizeMsg:
 		m.width, m.height = msg.Width, msg.Height
-		serverHeight := msg.Height / 5
-		log.Info().Msgf("Window resized: width=%d, height=%d, serverHeight=%d", msg.Width, msg.Height, serverHeight)
-		m.serverOutput.SetSize(msg.Width, serverHeight)
+		log.Debug().Msgf("Window resized: width=%d, height=%d", msg.Width, msg.Height)
+		m.layout()
 	case tuiUpdateModel:
-
-		// TODO: Load data from model and update the TUI model accordingly
-
-		// cmds := []tea.Cmd{m.waitForUpdate}
+		m.deviceTable, _ = m.deviceTable.Update(msg)
+		m.groupTable, _ = m.groupTable.Update(msg)
 		otherCmd = m.waitForUpdate
-
-		// if otherCmd != nil {
-		// 	// If we have a paginator command, we want to add it to the list of commands
-		// 	// so that the ui get synced
-		// 	cmds = append(cmds, otherCmd)
-		// }
-
-		// return m, tea.Batch(cmds...)
 	case tuiUpdateLog:
 		// if we received a tuiUpdateLog, it means that we have another piece of log
 		// that needs to be displayed calling "tea.Printf" to enqueue the log before the rendered TUI view
-		otherCmd = tea.Sequence(tea.Printf(strings.ReplaceAll(msg.log, "%", "%%")), m.waitForLog)
+		m.logPane.Add(msg.level, msg.log)
+		otherCmd = m.waitForLog
+	}
+
+	// The palette input needs the other messages too, e.g. to blink its cursor
+	if m.commandPalette != nil {
+		var commandPaletteCmd tea.Cmd
+		m.commandPalette, commandPaletteCmd = m.commandPalette.Update(msg)
+		otherCmd = tea.Batch(otherCmd, commandPaletteCmd)
 	}
 
+	hadServerOutput := m.serverOutput.HasOutput()
 	var serverOutputCmd tea.Cmd
 	m.serverOutput, serverOutputCmd = m.serverOutput.Update(msg)
+	if m.serverOutput.HasOutput() != hadServerOutput {
+		m.layout()
+	}
 	return m, tea.Batch(otherCmd, serverOutputCmd)
 }
 
+// tuiMainPaneRows are the rows of the main pane not used by the tables: border, title, help and feedback
+const tuiMainPaneRows = 5
+
+// layout splits the height between the main pane, the logs and the server output (shown in dev mode only)
+func (m model) layout() {
+	mainHeight := m.height * 2 / 5
+	serverHeight := m.height / 5
+	m.serverOutput.SetSize(m.width, serverHeight)
+	if !m.serverOutput.HasOutput() {
+		serverHeight = 0
+	}
+
+	m.deviceTable.SetSize(m.width-4, mainHeight-tuiMainPaneRows)
+	m.groupTable.SetSize(m.width-4, mainHeight-tuiMainPaneRows)
+	m.logPane.SetSize(m.width, m.height-mainHeight-serverHeight)
+}
+
+// handleKey applies the control keys to the selected device or group, and gives the others to the focused table
+func (m model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	key := msg.String()
+	switch key {
+	case "tab":
+		m.focus = (m.focus + 1) % (tuiFocusLogs + 1)
+		m.logPane.SetFocused(m.focus == tuiFocusLogs)
+		return m, nil
+	case ":", "p":
+		m.commandPalette = NewCommandPaletteModel(m.tui.commandPaletteEntries(), m.width-4)
+		return m, m.commandPalette.Init()
+	}
+
+	if m.focus == tuiFocusLogs {
+		m.logPane, cmd = m.logPane.Update(msg)
+		return m, cmd
+	}
+
+	target, ok := m.selectedTarget()
+	if ok {
+		switch key {
+		case " ", "enter":
+			m.lastCommand = m.tui.toggle(target)
+			return m, nil
+		case "left", "h", "-":
+			m.lastCommand = m.tui.nudgeBrightness(target, -tuiBrightnessStep)
+			return m, nil
+		case "right", "l", "+":
+			m.lastCommand = m.tui.nudgeBrightness(target, tuiBrightnessStep)
+			return m, nil
+		}
+		for _, preset := range tuiColorPresets {
+			if key == preset.Key {
+				m.lastCommand = m.tui.applyColorPreset(target, preset)
+				return m, nil
+			}
+		}
+	}
+
+	if m.focus == tuiFocusGroups {
+		m.groupTable, cmd = m.groupTable.Update(msg)
+	} else {
+		m.deviceTable, cmd = m.deviceTable.Update(msg)
+	}
+	return m, cmd
+}
+
+// selectedTarget returns the device or group under the cursor of the focused table
+func (m model) selectedTarget() (tuiTarget, bool) {
+	if m.focus == tuiFocusGroups {
+		name, ok := m.groupTable.Selected()
+		if !ok {
+			return tuiTarget{}, false
+		}
+		devices, ok := m.tui.configuration.GetGroupDevices(name)
+		return tuiTarget{Name: name, Group: true, Devices: devices}, ok
+	}
+
+	name, ok := m.deviceTable.Selected()
+	if !ok {
+		return tuiTarget{}, false
+	}
+	return tuiTarget{Name: name, Devices: []string{name}}, true
+}
+
 func (m model) View() string {
-	var b strings.Builder
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86"))
+	inactiveTitleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
 
-	b.WriteString("\n\n")
-	b.WriteString(greenTextStyle.Render("OK"))
-	b.WriteString("\n\n")
+	devicesTitle, groupsTitle := inactiveTitleStyle.Render("Devices"), inactiveTitleStyle.Render("Groups")
+	var table string
+	switch {
+	case m.commandPalette != nil:
+		// The palette takes the place of the table, so that the layout does not move
+		table = m.commandPalette.View()
+	case m.focus == tuiFocusGroups:
+		groupsTitle = titleStyle.Render("Groups")
+		table = m.groupTable.View()
+	default:
+		if m.focus == tuiFocusDevices {
+			devicesTitle = titleStyle.Render("Devices")
+		}
+		table = m.deviceTable.View()
+	}
 
+	var b strings.Builder
+	b.WriteString(devicesTitle + "  " + groupsTitle)
+	b.WriteString("\n")
+	b.WriteString(table)
+	b.WriteString("\n")
+	b.WriteString(deviceTableCell(deviceTableMutedStyle, max(1, m.width-4), "tab devices/groups/logs · space toggle · ←/→ brightness · 0-9 colors · : palette · q quit"))
+	if m.lastCommand != "" {
+		b.WriteString("\n")
+		b.WriteString(blueTextStyle.Render("→ " + m.lastCommand))
+	}
+
+	borderColor := lipgloss.Color("86")
+	if m.focus == tuiFocusLogs && m.commandPalette == nil {
+		borderColor = lipgloss.Color("241")
+	}
 	mainContent := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("86")).
-		Padding(1).
-		Width(m.width - 4).
-		Height(m.height/5 - 4).
+		BorderForeground(borderColor).
+		Padding(0, 1).
+		Width(max(0, m.width-2)).
+		Height(max(0, m.height*2/5-2)).
 		Render(b.String())
 
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
 		mainContent,
+		m.logPane.View(),
 		m.serverOutput.View(),
 	)
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const tuiBrightnessStep = 10 // Percentage points added or removed by the arrow keys
+
+// tuiColorPreset is a color applied with a number key
+type tuiColorPreset struct {
+	Key   string
+	Name  string
+	Color deviceColor
+}
+
+var tuiColorPresets = []tuiColorPreset{
+	{Key: "1", Name: "red", Color: deviceColor{Red: 255, Green: 0, Blue: 0}},
+	{Key: "2", Name: "orange", Color: deviceColor{Red: 255, Green: 120, Blue: 0}},
+	{Key: "3", Name: "yellow", Color: deviceColor{Red: 255, Green: 220, Blue: 0}},
+	{Key: "4", Name: "green", Color: deviceColor{Red: 0, Green: 255, Blue: 0}},
+	{Key: "5", Name: "cyan", Color: deviceColor{Red: 0, Green: 255, Blue: 255}},
+	{Key: "6", Name: "blue", Color: deviceColor{Red: 0, Green: 0, Blue: 255}},
+	{Key: "7", Name: "purple", Color: deviceColor{Red: 160, Green: 0, Blue: 255}},
+	{Key: "8", Name: "pink", Color: deviceColor{Red: 255, Green: 0, Blue: 128}},
+	{Key: "9", Name: "warm white", Color: deviceColor{Red: 255, Green: 180, Blue: 110}},
+	{Key: "0", Name: "white", Color: deviceColor{Red: 255, Green: 255, Blue: 255}},
+}
+
+// tuiTarget is what the control keys act on: the device or group selected in the TUI
+type tuiTarget struct {
+	Name    string
+	Group   bool
+	Devices []string
+}
+
+func (t tuiTarget) String() string {
+	if t.Group {
+		return "group " + t.Name
+	}
+	return "device " + t.Name
+}
+
+// supports tells whether at least one device of the target has the capability:
+// the attributes a device does not support are ignored when building its messages
+func (t tuiTarget) supports(capability DeviceCapability) bool {
+	for _, device := range t.Devices {
+		status, ok := Status.Get(device)
+		if ok && slices.Contains(ProviderCapabilities[status.Provider], capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns the on state (-1 unknown, 0 off, 1 on) and the brightness (-1 unknown) of the target
+func (t tuiTarget) status() (on int, brightness int) {
+	if !t.Group {
+		status, _ := Status.Get(t.Name)
+		return status.On, status.Brightness
+	}
+
+	groupStatus, _ := Status.GetGroup(t.Name)
+	switch groupStatus.On {
+	case groupOnStateAllOn:
+		on = 1
+	case groupOnStateSomeOn, groupOnStateOff:
+		on = 0
+	default:
+		on = -1
+	}
+	return on, groupStatus.Brightness
+}
+
+// toggle switches the target off when it is on, on otherwise. A group is only switched off when all its devices are on.
+func (t *TUI) toggle(target tuiTarget) string {
+	on, _ := target.status()
+	turnOn := on != 1
+	verb := "off"
+	if turnOn {
+		verb = "on"
+	}
+	return t.applyState(target, DeviceState{On: &turnOn}, "turn "+verb)
+}
+
+// nudgeBrightness moves the brightness of the target by delta, starting from the middle when it is unknown
+func (t *TUI) nudgeBrightness(target tuiTarget, delta int) string {
+	if !target.supports(DeviceCapabilityBrightness) {
+		return fmt.Sprintf("%s does not support brightness", target)
+	}
+	_, brightness := target.status()
+	if brightness < 0 {
+		brightness = 50
+	}
+	brightness = max(1, min(100, brightness+delta))
+	return t.applyState(target, DeviceState{Brightness: &brightness}, fmt.Sprintf("brightness %d%%", brightness))
+}
+
+func (t *TUI) applyColorPreset(target tuiTarget, preset tuiColorPreset) string {
+	if !target.supports(DeviceCapabilityColor) {
+		return fmt.Sprintf("%s does not support colors", target)
+	}
+	color := preset.Color
+	return t.applyState(target, DeviceState{Color: &color}, "color "+preset.Name)
+}
+
+// applyState dispatches the state to every device of the target, like the commands received through the API
+func (t *TUI) applyState(target tuiTarget, state DeviceState, description string) string {
+	var goveeMessages []GoveeMessage
+	var twinklyMessages []TwinklyMessage
+	var switchbotMessages []SwitchbotMessage
+	var wledMessages []WledMessage
+	for _, device := range target.Devices {
+		deviceGoveeMessages, deviceTwinklyMessages, deviceSwitchbotMessages, deviceWledMessages := t.configuration.GetMessagesToApplyDeviceState(device, state, 0)
+		goveeMessages = append(goveeMessages, deviceGoveeMessages...)
+		twinklyMessages = append(twinklyMessages, deviceTwinklyMessages...)
+		switchbotMessages = append(switchbotMessages, deviceSwitchbotMessages...)
+		wledMessages = append(wledMessages, deviceWledMessages...)
+	}
+	t.dispatcher.Dispatch(t.ctx, fmt.Sprintf("tui command to %s", target), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+
+	return fmt.Sprintf("%s: %s", target, description)
+}
+
+// runPaletteEntry triggers the action or applies the scene chosen in the command palette
+func (t *TUI) runPaletteEntry(entry commandPaletteEntry) string {
+	switch entry.Kind {
+	case commandPaletteEntryKindAction:
+		action, ok := t.configuration.GetActionByName(entry.Name)
+		if !ok {
+			return fmt.Sprintf("action %s not found", entry.Name)
+		}
+		goveeMessages, twinklyMessages, switchbotMessages, wledMessages := t.configuration.GetMessagesToDispatchOnAction(action, t.wledBrightnessRetriever)
+		t.dispatcher.Dispatch(t.ctx, fmt.Sprintf("tui trigger of action %s", entry.Name), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+		return fmt.Sprintf("action %s triggered", entry.Name)
+	case commandPaletteEntryKindScene:
+		scene, ok := Scenes.Get(entry.Name)
+		if !ok {
+			return fmt.Sprintf("scene %s not found", entry.Name)
+		}
+		goveeMessages, twinklyMessages, switchbotMessages, wledMessages := t.configuration.GetMessagesToApplyScene(scene)
+		t.dispatcher.Dispatch(t.ctx, fmt.Sprintf("tui apply of scene %s", entry.Name), goveeMessages, twinklyMessages, switchbotMessages, wledMessages)
+		return fmt.Sprintf("scene %s applied", entry.Name)
+	case commandPaletteEntryKindAdopt:
+		if _, err := t.goveeConnection.AdoptDevice(entry.ID, entry.Name); err != nil {
+			return fmt.Sprintf("cannot adopt %s: %s", entry.ID, err)
+		}
+		return fmt.Sprintf("%s added to configuration.json as %q: restart to control it", entry.ID, entry.Name)
+	}
+	return ""
+}
+
+// commandPaletteEntries lists the actions that can be triggered on their own and the scenes
+func (t *TUI) commandPaletteEntries() []commandPaletteEntry {
+	var entries []commandPaletteEntry
+	for _, action := range t.configuration.Actions {
+		if action.Name == "" || !isActionTriggerable(action) {
+			continue
+		}
+		entries = append(entries, commandPaletteEntry{
+			Kind:        commandPaletteEntryKindAction,
+			Name:        action.Name,
+			Description: strings.Join(action.GetDevices(), ", "),
+		})
+	}
+
+	scenes := Scenes.GetAll()
+	sceneNames := make([]string, 0, len(scenes))
+	for name := range scenes {
+		sceneNames = append(sceneNames, name)
+	}
+	slices.Sort(sceneNames)
+	for _, name := range sceneNames {
+		scene := scenes[name]
+		description := fmt.Sprintf("%d devices", len(scene.Devices))
+		if scene.TransitionMs > 0 {
+			description += fmt.Sprintf(", %s fade", time.Duration(scene.TransitionMs)*time.Millisecond)
+		}
+		entries = append(entries, commandPaletteEntry{
+			Kind:        commandPaletteEntryKindScene,
+			Name:        name,
+			Description: description,
+		})
+	}
+
+	// The Govee devices answering the scan can be added to the configuration with a suggested alias
+	for _, device := range t.goveeConnection.GetUnconfiguredDevices() {
+		if device.AdoptedAs != "" {
+			continue
+		}
+		entries = append(entries, commandPaletteEntry{
+			Kind: commandPaletteEntryKindAdopt,
+			Name: suggestGoveeAlias(device.SKU, device.MAC),
+			Description: fmt.Sprintf("%s at %s, wifi %s/%s, ble %s/%s", device.MAC, device.IP,
+				device.WifiVersionHard, device.WifiVersionSoft, device.BleVersionHard, device.BleVersionSoft),
+			ID: device.MAC,
+		})
+	}
+
+	return entries
+}
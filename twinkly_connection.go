@@ -14,13 +14,13 @@ import (
 )
 
 type TwinklyCommandSender interface {
-	SendMsg(message TwinklyMessage) error
+	SendMsg(ctx context.Context, message TwinklyMessage) error
 }
 
 type TwinklyConnection struct {
-	ip             string
-	authToken      atomic.String
-	pollingStarted bool
+	ip              string
+	authToken       atomic.String
+	tokenExpiration atomic.Int64 // As returned by the last login
 }
 
 func NewTwinklyConnection(ip string) *TwinklyConnection {
@@ -75,33 +75,35 @@ func (c *TwinklyConnection) Login(ctx context.Context, ip string) error {
 	}
 
 	c.authToken.Store(authResp.Token)
-
-	if !c.pollingStarted {
-		c.pollingStarted = true
-		c.startLoginPolling(ctx, authResp.AuthenticationTokenExpiresIn)
-	}
+	c.tokenExpiration.Store(int64(authResp.AuthenticationTokenExpiresIn))
 
 	return nil
 }
 
-func (c *TwinklyConnection) startLoginPolling(ctx context.Context, expirationInSeconds int) {
+// Start renews the login before the token expires, until ctx is cancelled
+func (c *TwinklyConnection) Start(ctx context.Context) {
 	if c.ip == "" {
 		return
 	}
 
-	go func() {
-		sleep := (time.Duration(expirationInSeconds) / 2) * time.Millisecond
-		for ctx.Err() == nil {
-			time.Sleep(sleep)
-			err := c.Login(ctx, c.ip)
-			if err != nil {
-				log.Err(err).Msgf("error logging in and verifying twinkly connection: %s", err)
-				sleep = 1 * time.Second
-				continue
+	sleep := (time.Duration(c.tokenExpiration.Load()) / 2) * time.Millisecond
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sleep):
+		}
+		err := c.Login(ctx, c.ip)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
 			}
-			sleep = (time.Duration(expirationInSeconds) / 2) * time.Millisecond
+			log.Err(err).Msgf("error logging in and verifying twinkly connection: %s", err)
+			sleep = 1 * time.Second
+			continue
 		}
-	}()
+		sleep = (time.Duration(c.tokenExpiration.Load()) / 2) * time.Millisecond
+	}
 }
 
 func (c *TwinklyConnection) turnOn(ctx context.Context) error {
@@ -125,10 +127,14 @@ func (c *TwinklyConnection) turnOn(ctx context.Context) error {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending turn on request: %v", err)
+		return fmt.Errorf("error sending turn on request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if err := checkHTTPResponse(resp); err != nil {
+		return fmt.Errorf("turn on request failed: %w", err)
+	}
+
 	// data = map[string]string{}
 	// jsonData, err = json.Marshal(data)
 	// if err != nil {
@@ -175,14 +181,18 @@ func (c *TwinklyConnection) turnOff(ctx context.Context) error {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending turn off request: %v", err)
+		return fmt.Errorf("error sending turn off request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if err := checkHTTPResponse(resp); err != nil {
+		return fmt.Errorf("turn off request failed: %w", err)
+	}
+
 	return nil
 }
 
-func (c *TwinklyConnection) SendMsg(message TwinklyMessage) error {
+func (c *TwinklyConnection) SendMsg(ctx context.Context, message TwinklyMessage) error {
 	if c.ip == "" {
 		return nil
 	}
@@ -190,10 +200,10 @@ func (c *TwinklyConnection) SendMsg(message TwinklyMessage) error {
 	switch message {
 	case TwinklyMessageOn:
 		log.Debug().Msg("Turning on Twinkly lights")
-		return c.turnOn(context.Background())
+		return c.turnOn(ctx)
 	case TwinklyMessageOff:
 		log.Debug().Msg("Turning off Twinkly lights")
-		return c.turnOff(context.Background())
+		return c.turnOff(ctx)
 	default:
 		return fmt.Errorf("unknown Twinkly message: %s", message)
 	}
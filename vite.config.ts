@@ -10,4 +10,20 @@ export default defineConfig({
       },
     }),
   ],
+  build: {
+    // Embedded into the Go binary (see dashboard.go)
+    outDir: 'dashboard/dist',
+  },
+  server: {
+    // The Go binary proxies the dashboard to this port in -dev mode
+    port: 5173,
+    strictPort: true,
+    // When the dashboard is opened from Vite directly
+    proxy: {
+      '/api': {
+        target: 'http://localhost:8080',
+        ws: true,
+      },
+    },
+  },
 })
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -15,7 +16,7 @@ type WledConnection struct {
 }
 
 type WledCommandSender interface {
-	SendMsg(message WledMessage) error
+	SendMsg(ctx context.Context, message WledMessage) error
 }
 
 func NewWledConnection(configuration Configuration) *WledConnection {
@@ -25,10 +26,13 @@ func NewWledConnection(configuration Configuration) *WledConnection {
 	}
 }
 
-func (c *WledConnection) SendMsg(msg WledMessage) error {
+var _ WledCommandSender = (*WledConnection)(nil)
+var _ DeviceStateReader = (*WledConnection)(nil)
+
+func (c *WledConnection) SendMsg(ctx context.Context, msg WledMessage) error {
 	device, ok := c.devices[msg.Device]
 	if !ok {
-		return fmt.Errorf("device not found: %s", msg.Device)
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, msg.Device)
 	}
 
 	log.Debug().
@@ -36,58 +40,104 @@ func (c *WledConnection) SendMsg(msg WledMessage) error {
 		Str("ip", device.IP).
 		Msgf("Sending message to WLED device")
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/json/state", device.IP), bytes.NewReader(msg.Body))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("http://%s/json/state", device.IP), bytes.NewReader(msg.Body))
 	if err != nil {
 		return fmt.Errorf("error creating request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{
-		Timeout: 500 * time.Millisecond,
-	}
+	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+		return fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	return nil
+	return checkHTTPResponse(resp)
 }
 
 func (c *WledConnection) GetDeviceBrightness(deviceName string) (int, error) {
-	device, ok := c.devices[deviceName]
-	if !ok {
-		return 0, fmt.Errorf("device not found: %s", deviceName)
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
 
 	log.Debug().
 		Str("device", deviceName).
-		Str("ip", device.IP).
 		Msgf("Getting brightness from WLED device")
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/json/state", device.IP), nil)
+	state, err := c.getState(ctx, deviceName)
+	if err != nil {
+		return 0, err
+	}
+
+	return mapBrightness(state.Bri, []int{0, 255}, []int{0, 100}), nil
+}
+
+// ReadState returns on, brightness and the primary color of the first segment
+func (c *WledConnection) ReadState(ctx context.Context, deviceName string) (DeviceState, error) {
+	state, err := c.getState(ctx, deviceName)
 	if err != nil {
-		return 0, fmt.Errorf("error creating request: %v", err)
+		return DeviceState{}, err
+	}
+
+	deviceState := DeviceState{
+		On:         valToPtr(state.On),
+		Brightness: valToPtr(mapBrightness(state.Bri, []int{0, 255}, []int{0, 100})),
+	}
+	if len(state.Seg) > 0 && len(state.Seg[0].Col) > 0 && len(state.Seg[0].Col[0]) >= 3 {
+		col := state.Seg[0].Col[0]
+		deviceState.Color = &deviceColor{Red: col[0], Green: col[1], Blue: col[2]}
+	}
+	return deviceState, nil
+}
+
+func (c *WledConnection) getState(ctx context.Context, deviceName string) (WledStateResponse, error) {
+	device, ok := c.devices[deviceName]
+	if !ok {
+		return WledStateResponse{}, fmt.Errorf("%w: %s", ErrDeviceNotFound, deviceName)
 	}
-	client := &http.Client{
-		Timeout: 500 * time.Millisecond,
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s/json/state", device.IP), nil)
+	if err != nil {
+		return WledStateResponse{}, fmt.Errorf("error creating request: %v", err)
 	}
+	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("error sending request: %v", err)
+		return WledStateResponse{}, fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if err := checkHTTPResponse(resp); err != nil {
+		return WledStateResponse{}, err
+	}
+
 	var state WledStateResponse
 	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
-		return 0, fmt.Errorf("error decoding response: %v", err)
+		return WledStateResponse{}, fmt.Errorf("error decoding response: %v", err)
 	}
-
-	return mapBrightness(state.Bri, []int{0, 255}, []int{0, 100}), nil
+	return state, nil
 }
 
 type WledStateResponse struct {
-	On  bool `json:"on"`
-	Bri int  `json:"bri"`
+	On  bool                  `json:"on"`
+	Bri int                   `json:"bri"`
+	Seg []WledSegmentResponse `json:"seg"`
+}
+
+type WledSegmentResponse struct {
+	Col [][]int `json:"col"`
+	Fx  int     `json:"fx"`
+}
+
+type WledStateRequest struct {
+	On         *bool                `json:"on,omitempty"`
+	Bri        *int                 `json:"bri,omitempty"`
+	Transition *int                 `json:"tt,omitempty"` // Tenths of a second, for this request only: "transition" would change the default
+	Seg        []WledSegmentRequest `json:"seg,omitempty"`
+}
+
+type WledSegmentRequest struct {
+	Col [][]int `json:"col,omitempty"`
+	Fx  *int    `json:"fx,omitempty"`
 }